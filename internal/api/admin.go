@@ -0,0 +1,75 @@
+package api
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+)
+
+const adminTokenPrefix = "Bearer "
+
+// adminAuth пропускает запрос, только если передан `Authorization: Bearer <admin_token>`.
+// Без admin_token в конфигурации административное API выключено.
+func adminAuth(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := currentSettings().adminToken
+		if token == "" {
+			httpRespond(w, http.StatusForbidden, "admin API is disabled, set admin_token")
+			return
+		}
+
+		got, ok := strings.CutPrefix(r.Header.Get("Authorization"), adminTokenPrefix)
+		if !ok || subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			httpRespond(w, http.StatusUnauthorized, "unauthorized")
+			return
+		}
+
+		next(w, r)
+	}
+}
+
+func handleStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		httpRespond(w, http.StatusMethodNotAllowed, "")
+		return
+	}
+	allowSlowResponse(w)
+	httpRespondJSON(w, http.StatusOK, Status())
+}
+
+// handleAdminCheckpoint сохраняет все фильтры и возвращает результат последнего чекпоинта каждого.
+func handleAdminCheckpoint(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		httpRespond(w, http.StatusMethodNotAllowed, "")
+		return
+	}
+	if err := checkIsReady(w); err != nil {
+		return
+	}
+
+	allowSlowResponse(w)
+	// ошибки видны в результате каждого фильтра
+	_ = Checkpoint()
+
+	names, _ := registeredFilters()
+	result := make(map[string]*CheckpointStatus, len(names))
+	for _, name := range names {
+		result[name] = lastCheckpoint(name)
+	}
+	httpRespondJSON(w, http.StatusOK, result)
+}
+
+func handleReload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		httpRespond(w, http.StatusMethodNotAllowed, "")
+		return
+	}
+
+	report := Reload()
+	status := http.StatusOK
+	if len(report.Errors) > 0 {
+		status = http.StatusInternalServerError
+	}
+	httpRespondJSON(w, status, report)
+}
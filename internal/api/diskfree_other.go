@@ -0,0 +1,10 @@
+//go:build !(linux || darwin || freebsd || windows)
+
+package api
+
+import "errors"
+
+// freeDiskBytes на остальных системах свободное место не проверяется.
+func freeDiskBytes(string) (uint64, error) {
+	return 0, errors.ErrUnsupported
+}
@@ -0,0 +1,16 @@
+package api
+
+import "golang.org/x/sys/windows"
+
+// freeDiskBytes свободное место, доступное текущему пользователю.
+func freeDiskBytes(dir string) (uint64, error) {
+	path, err := windows.UTF16PtrFromString(dir)
+	if err != nil {
+		return 0, err
+	}
+	var free uint64
+	if err = windows.GetDiskFreeSpaceEx(path, &free, nil, nil); err != nil {
+		return 0, err
+	}
+	return free, nil
+}
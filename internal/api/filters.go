@@ -0,0 +1,506 @@
+package api
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+
+	"bloom-du/internal/bloom"
+)
+
+// DefaultFilter имя фильтра, который создаётся из флагов командной строки,
+// если в конфигурации нет секции filters.
+const DefaultFilter = "default"
+
+// FilterConfig описание фильтра в секции filters конфигурации.
+type FilterConfig struct {
+	Name           string `mapstructure:"name" json:"name"`
+	Engine         string `mapstructure:"engine" json:"engine"`
+	CheckpointPath string `mapstructure:"checkpoint_path" json:"checkpoint_path"`
+	Force          bool   `mapstructure:"force" json:"force"`
+	// Source файлы, директории и glob шаблоны источника
+	Source []string `mapstructure:"source" json:"source"`
+	// SourceFormat, SourceDelimiter, SourceHeader, SourceKey и SourceKeySeparator как читать записи источника, см. bloom.SourceOptions
+	SourceFormat       string   `mapstructure:"source_format" json:"source_format,omitempty"`
+	SourceDelimiter    string   `mapstructure:"source_delimiter" json:"source_delimiter,omitempty"`
+	SourceHeader       bool     `mapstructure:"source_header" json:"source_header,omitempty"`
+	SourceKey          []string `mapstructure:"source_key" json:"source_key,omitempty"`
+	SourceKeySeparator string   `mapstructure:"source_key_separator" json:"source_key_separator,omitempty"`
+	// SourceParallel сколько файлов источника читать одновременно
+	SourceParallel int `mapstructure:"source_parallel" json:"source_parallel,omitempty"`
+	// SourceSkipIngested не перечитывать после перезапуска с дампом уже загруженные файлы
+	SourceSkipIngested bool `mapstructure:"source_skip_ingested" json:"source_skip_ingested,omitempty"`
+	// Watch директория, новые и дописанные файлы которой добавляются в работающий фильтр (формат - source_*).
+	// WatchPattern шаблон имени файла, WatchProcessedDir - куда переносить прочитанные файлы, без неё
+	// дописанные файлы читаются с места, где остановились. WatchSettle - сколько файл не должен меняться.
+	Watch             string        `mapstructure:"watch" json:"watch,omitempty"`
+	WatchPattern      string        `mapstructure:"watch_pattern" json:"watch_pattern,omitempty"`
+	WatchProcessedDir string        `mapstructure:"watch_processed_dir" json:"watch_processed_dir,omitempty"`
+	WatchSettle       time.Duration `mapstructure:"watch_settle" json:"watch_settle,omitempty"`
+	// Normalize шаги нормализации значений источника и API: trim, lowercase, nfc, digits, s/шаблон/замена/.
+	Normalize []string `mapstructure:"normalize" json:"normalize,omitempty"`
+	// ValueMinLen, ValueMaxLen длина значения в символах, ValuePattern регулярное выражение всего значения,
+	// ValueCharset допустимые символы (digits, hex, alnum, ascii, printable), MaxBulk значений в /api/bulk.
+	// Проверяются после нормализации в API, сокете, watch, tail и при загрузке. Без value_min_len - общий value_min_len.
+	ValueMinLen  *int   `mapstructure:"value_min_len" json:"value_min_len,omitempty"`
+	ValueMaxLen  int    `mapstructure:"value_max_len" json:"value_max_len,omitempty"`
+	ValuePattern string `mapstructure:"value_pattern" json:"value_pattern,omitempty"`
+	ValueCharset string `mapstructure:"value_charset" json:"value_charset,omitempty"`
+	MaxBulk      int    `mapstructure:"max_bulk" json:"max_bulk,omitempty"`
+	// HMACKeyFile файл или HMACKeyEnv переменная окружения с секретным ключом: значения хранятся как HMAC-SHA256.
+	HMACKeyFile string `mapstructure:"hmac_key_file" json:"hmac_key_file,omitempty"`
+	HMACKeyEnv  string `mapstructure:"hmac_key_env" json:"hmac_key_env,omitempty"`
+	// Tail растущий файл, строки которого добавляются в фильтр по мере записи (как tail -F).
+	Tail string `mapstructure:"tail" json:"tail,omitempty"`
+	// Window и Generations параметры rotating фильтра, 0 - по умолчанию
+	Window      time.Duration `mapstructure:"window" json:"window,omitempty"`
+	Generations int           `mapstructure:"generations" json:"generations,omitempty"`
+	// Capacity (для rotating - одного поколения), FpRate и CellBits (stable) размер фильтра, как в calc, 0 - по умолчанию движка
+	Capacity uint    `mapstructure:"capacity" json:"capacity,omitempty"`
+	FpRate   float64 `mapstructure:"fp_rate" json:"fp_rate,omitempty"`
+	CellBits uint8   `mapstructure:"cell_bits" json:"cell_bits,omitempty"`
+	// Cardinality HyperLogLog уникальных значений рядом с фильтром
+	Cardinality bool `mapstructure:"cardinality" json:"cardinality"`
+	// TopK размер топа самых частых дублей, 0 - не вести
+	TopK uint `mapstructure:"topk" json:"topk,omitempty"`
+	// Saturation пороги насыщения, незаданные берутся из общей секции saturation
+	Saturation SaturationConfig `mapstructure:"saturation" json:"saturation"`
+}
+
+var (
+	filtersMux sync.RWMutex
+	filters    = map[string]bloom.Filter{}
+	// filterConfigs конфигурация, с которой были созданы фильтры
+	filterConfigs = map[string]FilterConfig{}
+	// defaultFilter используется, если в запросе не указано имя фильтра
+	defaultFilter = DefaultFilter
+	// configuredDefault первый фильтр конфигурации: станет defaultFilter, когда будет создан
+	configuredDefault = DefaultFilter
+
+	// pendingFilters фильтры, которые сейчас создаются (загрузка дампа или источника)
+	pendingMux     sync.Mutex
+	pendingFilters = map[string]*pendingFilter{}
+)
+
+// pendingFilter фильтр в процессе создания. retired - его убрали из конфигурации, пока он создавался:
+// после создания он не регистрируется, а только сохраняется.
+type pendingFilter struct {
+	cfg     FilterConfig
+	retired bool
+}
+
+// FilterConfigs читает описание фильтров из конфигурации. Без секции filters
+// возвращает один фильтр DefaultFilter, собранный из флагов source, engine, checkpoint_path, force,
+// window, generations, capacity, fp_rate, cell_bits, cardinality, topk и source_*.
+func FilterConfigs() ([]FilterConfig, error) {
+	var configs []FilterConfig
+	if err := viper.UnmarshalKey("filters", &configs); err != nil {
+		return nil, fmt.Errorf("filters: %w", err)
+	}
+	saturation, err := saturationDefaults()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(configs) == 0 {
+		cfg := FilterConfig{
+			Name:               DefaultFilter,
+			Engine:             viper.GetString("engine"),
+			Source:             viper.GetStringSlice("source"),
+			SourceFormat:       viper.GetString("source_format"),
+			SourceDelimiter:    viper.GetString("source_delimiter"),
+			SourceHeader:       viper.GetBool("source_header"),
+			SourceKey:          viper.GetStringSlice("source_key"),
+			SourceKeySeparator: viper.GetString("source_key_separator"),
+			SourceParallel:     viper.GetInt("source_parallel"),
+			SourceSkipIngested: viper.GetBool("source_skip_ingested"),
+			Watch:              viper.GetString("watch"),
+			WatchPattern:       viper.GetString("watch_pattern"),
+			WatchProcessedDir:  viper.GetString("watch_processed_dir"),
+			WatchSettle:        viper.GetDuration("watch_settle"),
+			Tail:               viper.GetString("tail"),
+			Normalize:          viper.GetStringSlice("normalize"),
+			ValueMaxLen:        viper.GetInt("value_max_len"),
+			ValuePattern:       viper.GetString("value_pattern"),
+			ValueCharset:       viper.GetString("value_charset"),
+			MaxBulk:            viper.GetInt("max_bulk"),
+			HMACKeyFile:        viper.GetString("hmac_key_file"),
+			HMACKeyEnv:         viper.GetString("hmac_key_env"),
+			CheckpointPath:     viper.GetString("checkpoint_path"),
+			Force:              viper.GetBool("force"),
+			Window:             viper.GetDuration("window"),
+			Generations:        viper.GetInt("generations"),
+			Capacity:           viper.GetUint("capacity"),
+			FpRate:             viper.GetFloat64("fp_rate"),
+			CellBits:           uint8(viper.GetUint("cell_bits")),
+			Cardinality:        viper.GetBool("cardinality"),
+			TopK:               viper.GetUint("topk"),
+			Saturation:         saturation,
+		}
+		if err := cfg.validateRotating(); err != nil {
+			return nil, err
+		}
+		if err := cfg.validateSize(); err != nil {
+			return nil, err
+		}
+		if err := cfg.sourceOptions().Validate(); err != nil {
+			return nil, err
+		}
+		if err := cfg.validateWatch(); err != nil {
+			return nil, err
+		}
+		if _, err := bloom.ParseNormalizer(cfg.Normalize); err != nil {
+			return nil, err
+		}
+		minLen := viper.GetInt("value_min_len")
+		cfg.ValueMinLen = &minLen
+		if _, err := bloom.NewValidator(cfg.validationRules()); err != nil {
+			return nil, err
+		}
+		if _, err := cfg.keyer(); err != nil {
+			return nil, err
+		}
+		return []FilterConfig{cfg}, nil
+	}
+
+	checkpointDir := filepath.Dir(viper.GetString("checkpoint_path"))
+	names := make([]string, 0, len(configs))
+	paths := make(map[string]string, len(configs))
+	for i := range configs {
+		cfg := &configs[i]
+		if cfg.Name == "" {
+			return nil, fmt.Errorf("filters[%d]: name is required", i)
+		}
+		if slices.Contains(names, cfg.Name) {
+			return nil, fmt.Errorf("filters[%d]: duplicate name `%s`", i, cfg.Name)
+		}
+		names = append(names, cfg.Name)
+
+		if cfg.Engine == "" {
+			cfg.Engine = bloom.StableBloom.String()
+		}
+		if _, err := bloom.ParseEngine(cfg.Engine); err != nil {
+			return nil, fmt.Errorf("filters[%d]: %w", i, err)
+		}
+		if cfg.CheckpointPath == "" {
+			cfg.CheckpointPath = filepath.Join(checkpointDir, cfg.Name+".bloom")
+		}
+		if owner, ok := paths[checkpointKey(cfg.CheckpointPath)]; ok {
+			return nil, fmt.Errorf("filters[%d]: checkpoint_path `%s` is already used by filter `%s`", i, cfg.CheckpointPath, owner)
+		}
+		paths[checkpointKey(cfg.CheckpointPath)] = cfg.Name
+		cfg.Saturation = cfg.Saturation.withDefaults(saturation)
+		if err := cfg.validateRotating(); err != nil {
+			return nil, fmt.Errorf("filters[%d]: %w", i, err)
+		}
+		if err := cfg.validateSize(); err != nil {
+			return nil, fmt.Errorf("filters[%d]: %w", i, err)
+		}
+		if err := cfg.sourceOptions().Validate(); err != nil {
+			return nil, fmt.Errorf("filters[%d]: %w", i, err)
+		}
+		if err := cfg.validateWatch(); err != nil {
+			return nil, fmt.Errorf("filters[%d]: %w", i, err)
+		}
+		if _, err := bloom.ParseNormalizer(cfg.Normalize); err != nil {
+			return nil, fmt.Errorf("filters[%d]: %w", i, err)
+		}
+		if cfg.ValueMinLen == nil {
+			minLen := viper.GetInt("value_min_len")
+			cfg.ValueMinLen = &minLen
+		}
+		if _, err := bloom.NewValidator(cfg.validationRules()); err != nil {
+			return nil, fmt.Errorf("filters[%d]: %w", i, err)
+		}
+		if _, err := cfg.keyer(); err != nil {
+			return nil, fmt.Errorf("filters[%d]: %w", i, err)
+		}
+	}
+
+	return configs, nil
+}
+
+// validationRules правила проверки значений фильтра.
+func (cfg FilterConfig) validationRules() bloom.ValidationRules {
+	rules := bloom.ValidationRules{
+		MaxLen:  cfg.ValueMaxLen,
+		Pattern: cfg.ValuePattern,
+		Charset: cfg.ValueCharset,
+		MaxBulk: cfg.MaxBulk,
+	}
+	if cfg.ValueMinLen != nil {
+		rules.MinLen = *cfg.ValueMinLen
+	}
+	return rules
+}
+
+// keyer HMAC ключ фильтра из файла или переменной окружения, nil - без ключа.
+func (cfg FilterConfig) keyer() (*bloom.Keyer, error) {
+	var key []byte
+	switch {
+	case cfg.HMACKeyFile != "" && cfg.HMACKeyEnv != "":
+		return nil, fmt.Errorf("only one of hmac_key_file and hmac_key_env can be set")
+	case cfg.HMACKeyFile != "":
+		data, err := os.ReadFile(cfg.HMACKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("hmac_key_file: %w", err)
+		}
+		key = bytes.TrimSpace(data)
+	case cfg.HMACKeyEnv != "":
+		key = []byte(os.Getenv(cfg.HMACKeyEnv))
+		if len(key) == 0 {
+			return nil, fmt.Errorf("hmac_key_env: environment variable %s is empty", cfg.HMACKeyEnv)
+		}
+	default:
+		return nil, nil
+	}
+	keyer, err := bloom.NewKeyer(key)
+	if err != nil {
+		return nil, fmt.Errorf("filter `%s`: %w", cfg.Name, err)
+	}
+	return keyer, nil
+}
+
+// validateRotating поколение rotating фильтра должно быть не короче секунды.
+func (cfg FilterConfig) validateRotating() error {
+	if cfg.Window < 0 || cfg.Generations < 0 {
+		return fmt.Errorf("window and generations must be >= 0")
+	}
+	if cfg.Window > 0 && cfg.Generations > 0 && cfg.Window/time.Duration(cfg.Generations) < time.Second {
+		return fmt.Errorf("window / generations must be >= 1s, got %s / %d", cfg.Window, cfg.Generations)
+	}
+	return nil
+}
+
+// validateSize fp_rate в (0, 1) и cell_bits от 1 до 8, нулевые - по умолчанию движка.
+func (cfg FilterConfig) validateSize() error {
+	if cfg.FpRate < 0 || cfg.FpRate >= 1 {
+		return fmt.Errorf("fp_rate must be in (0, 1), got %v", cfg.FpRate)
+	}
+	if cfg.CellBits > 8 {
+		return fmt.Errorf("cell_bits must be in [1, 8], got %d", cfg.CellBits)
+	}
+	return nil
+}
+
+func (cfg FilterConfig) sourceOptions() bloom.SourceOptions {
+	return bloom.SourceOptions{
+		Format:       cfg.SourceFormat,
+		Delimiter:    cfg.SourceDelimiter,
+		Header:       cfg.SourceHeader,
+		Key:          cfg.SourceKey,
+		KeySeparator: cfg.SourceKeySeparator,
+		Parallel:     cfg.SourceParallel,
+		SkipIngested: cfg.SourceSkipIngested,
+	}
+}
+
+// createFilter создаёт фильтр по конфигурации. checkpointInterval читается до запуска в фоне:
+// viper нельзя читать, пока Reload перечитывает конфигурацию.
+func createFilter(cfg FilterConfig, checkpointInterval time.Duration) (bloom.Filter, error) {
+	engine, err := bloom.ParseEngine(cfg.Engine)
+	if err != nil {
+		return nil, err
+	}
+	normalizer, err := bloom.ParseNormalizer(cfg.Normalize)
+	if err != nil {
+		return nil, err
+	}
+	validator, err := bloom.NewValidator(cfg.validationRules())
+	if err != nil {
+		return nil, err
+	}
+	keyer, err := cfg.keyer()
+	if err != nil {
+		return nil, err
+	}
+	opts := bloom.Options{
+		Window:      cfg.Window,
+		Generations: cfg.Generations,
+		Capacity:    cfg.Capacity,
+		FpRate:      cfg.FpRate,
+		CellBits:    cfg.CellBits,
+		Cardinality: cfg.Cardinality,
+		TopK:        cfg.TopK,
+		Source:      cfg.sourceOptions(),
+		Normalize:   normalizer,
+		Validator:   validator,
+		Keyer:       keyer,
+	}
+	// долгая загрузка источника тоже сохраняется раз в checkpoint_interval и продолжается после перезапуска
+	opts.Source.CheckpointInterval = checkpointInterval
+	return bloom.MakeEngine(engine, cfg.Source, cfg.Force, logCh, cfg.CheckpointPath, opts)
+}
+
+// markPending отмечает, что фильтр создаётся, false - если он уже создаётся.
+func markPending(cfg FilterConfig) bool {
+	pendingMux.Lock()
+	defer pendingMux.Unlock()
+	if _, ok := pendingFilters[cfg.Name]; ok {
+		return false
+	}
+	pendingFilters[cfg.Name] = &pendingFilter{cfg: cfg}
+	return true
+}
+
+// unmarkPending фильтр создан: последняя конфигурация (пороги могли поменяться при перезагрузке)
+// и false, если его уже убрали из конфигурации.
+func unmarkPending(name string) (FilterConfig, bool) {
+	pendingMux.Lock()
+	defer pendingMux.Unlock()
+	pending, ok := pendingFilters[name]
+	if !ok {
+		return FilterConfig{}, false
+	}
+	delete(pendingFilters, name)
+	return pending.cfg, !pending.retired
+}
+
+// setPendingRetired отмечает, что создаваемый фильтр убрали из конфигурации или вернули в неё.
+func setPendingRetired(name string, retired bool) {
+	pendingMux.Lock()
+	defer pendingMux.Unlock()
+	if pending, ok := pendingFilters[name]; ok {
+		pending.retired = retired
+	}
+}
+
+// pendingConfig конфигурация создаваемого фильтра, retired - его убрали из конфигурации.
+func pendingConfig(name string) (cfg FilterConfig, retired bool, ok bool) {
+	pendingMux.Lock()
+	defer pendingMux.Unlock()
+	pending, ok := pendingFilters[name]
+	if !ok {
+		return FilterConfig{}, false, false
+	}
+	return pending.cfg, pending.retired, true
+}
+
+// pendingConfigs создаваемые фильтры, кроме убранных из конфигурации.
+func pendingConfigs() []FilterConfig {
+	pendingMux.Lock()
+	defer pendingMux.Unlock()
+	configs := make([]FilterConfig, 0, len(pendingFilters))
+	for _, pending := range pendingFilters {
+		if !pending.retired {
+			configs = append(configs, pending.cfg)
+		}
+	}
+	slices.SortFunc(configs, func(a, b FilterConfig) int { return strings.Compare(a.Name, b.Name) })
+	return configs
+}
+
+// checkpointKey путь дампа для сравнения: у двух фильтров дампы и файлы рядом с ними
+// (.resume, .sources, .hll, .topk, .watch, .tail) перезаписывали бы друг друга.
+func checkpointKey(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		return abs
+	}
+	return filepath.Clean(path)
+}
+
+// checkpointOwners фильтры, которые пишут дампы: созданные и создаваемые, в том числе убранные
+// из конфигурации - они ещё сохранятся в последний раз.
+func checkpointOwners() map[string]string {
+	owners := map[string]string{}
+	for name, cfg := range registeredConfigs() {
+		owners[checkpointKey(cfg.CheckpointPath)] = name
+	}
+	pendingMux.Lock()
+	defer pendingMux.Unlock()
+	for name, pending := range pendingFilters {
+		owners[checkpointKey(pending.cfg.CheckpointPath)] = name
+	}
+	return owners
+}
+
+func registerFilter(cfg FilterConfig, filter bloom.Filter) {
+	filtersMux.Lock()
+	defer filtersMux.Unlock()
+	filters[cfg.Name] = filter
+	filterConfigs[cfg.Name] = cfg
+	if cfg.Name == configuredDefault {
+		defaultFilter = cfg.Name
+	}
+}
+
+// updateFilterConfig заменяет конфигурацию зарегистрированного или создаваемого фильтра
+// (то, что применяется без пересоздания).
+func updateFilterConfig(cfg FilterConfig) {
+	pendingMux.Lock()
+	if pending, ok := pendingFilters[cfg.Name]; ok {
+		pending.cfg = cfg
+	}
+	pendingMux.Unlock()
+
+	filtersMux.Lock()
+	defer filtersMux.Unlock()
+	if _, ok := filters[cfg.Name]; ok {
+		filterConfigs[cfg.Name] = cfg
+	}
+}
+
+func unregisterFilter(name string) bloom.Filter {
+	filtersMux.Lock()
+	defer filtersMux.Unlock()
+	filter := filters[name]
+	delete(filters, name)
+	delete(filterConfigs, name)
+	return filter
+}
+
+// setDefaultFilter фильтр name становится фильтром по умолчанию, а если он ещё создаётся - когда будет создан.
+// До этого запросы без имени фильтра идут в прежний фильтр по умолчанию.
+func setDefaultFilter(name string) {
+	filtersMux.Lock()
+	defer filtersMux.Unlock()
+	configuredDefault = name
+	if _, ok := filters[name]; ok {
+		defaultFilter = name
+	}
+}
+
+// getFilter возвращает фильтр и его имя, пустое имя - фильтр по умолчанию.
+func getFilter(name string) (string, bloom.Filter, error) {
+	filtersMux.RLock()
+	defer filtersMux.RUnlock()
+	if name == "" {
+		name = defaultFilter
+	}
+	filter, ok := filters[name]
+	if !ok {
+		return name, nil, fmt.Errorf("filter `%s` not found", name)
+	}
+	return name, filter, nil
+}
+
+// registeredFilters снимок зарегистрированных фильтров, отсортированный по имени.
+func registeredFilters() ([]string, map[string]bloom.Filter) {
+	filtersMux.RLock()
+	defer filtersMux.RUnlock()
+	snapshot := make(map[string]bloom.Filter, len(filters))
+	names := make([]string, 0, len(filters))
+	for name, filter := range filters {
+		snapshot[name] = filter
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names, snapshot
+}
+
+func registeredConfigs() map[string]FilterConfig {
+	filtersMux.RLock()
+	defer filtersMux.RUnlock()
+	snapshot := make(map[string]FilterConfig, len(filterConfigs))
+	for name, cfg := range filterConfigs {
+		snapshot[name] = cfg
+	}
+	return snapshot
+}
@@ -5,6 +5,8 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"strconv"
+	"sync"
 	"time"
 
 	"github.com/rs/zerolog/log"
@@ -18,7 +20,11 @@ const (
 	ContentType     = "Content-Type"
 	ContentTypeJSON = "application/json; charset=utf-8"
 	MsgJSONError    = "JSON encode error"
-	valueMinLen     = 2
+	// bulkMaxErrors сколько ошибок проверки значений /api/bulk возвращать, дальше только количество
+	bulkMaxErrors = 100
+	// incrMaxDelta countmin увеличивает счётчик по одному, большой delta держит блокировку фильтра
+	incrMaxDelta     = 10_000
+	msgWritesBlocked = "writes are temporarily blocked, please retry"
 )
 
 const (
@@ -26,18 +32,24 @@ const (
 	searchAddMsg = "✅ Время поиска + добавления"
 )
 
-var Filter bloom.Filter
-
-// var Filter *bloom.StableBloomFilter
 var isReady bool
 
+var logCh chan bloom.LogEvent
+
+// checkpointMux не даёт периодическому и финальному чекпоинту писать дамп одновременно
+var checkpointMux sync.Mutex
+
 type RequestData struct {
 	Value   string `json:"value"`
 	Options string `json:"options"`
+	Filter  string `json:"filter"`
+	// Delta шаг /api/incr, 0 - 1.
+	Delta uint64 `json:"delta"`
 }
 
 type RequestBulkData struct {
-	Data []string `json:"data"`
+	Data   []string `json:"data"`
+	Filter string   `json:"filter"`
 }
 
 type ResponseData struct {
@@ -45,35 +57,124 @@ type ResponseData struct {
 	Status  int    `json:"status"`
 }
 
+// ResponseValidation ответ 400 на значения, которые не прошли правила фильтра.
+type ResponseValidation struct {
+	Message string       `json:"message"`
+	Status  int          `json:"status"`
+	Errors  []ValueError `json:"errors"`
+}
+
+// ValueError правило, которое не прошло значение, Index - номер значения в /api/bulk.
+type ValueError struct {
+	Index *int `json:"index,omitempty"`
+	*bloom.ValidationError
+}
+
+// ResponseStats ответ /api/stats.
+type ResponseStats struct {
+	Filter string `json:"filter"`
+	bloom.Stats
+}
+
+// ResponseCount ответ /api/incr и /api/count.
+type ResponseCount struct {
+	Filter string `json:"filter"`
+	Value  string `json:"value"`
+	Count  uint64 `json:"count"`
+}
+
+// ResponseTopK ответ /api/topk.
+type ResponseTopK struct {
+	Filter string           `json:"filter"`
+	Top    []bloom.Frequent `json:"top"`
+}
+
+// ResponseFilter элемент ответа /api/filters.
+type ResponseFilter struct {
+	Name         string             `json:"name"`
+	Engine       string             `json:"engine"`
+	Default      bool               `json:"default"`
+	Capabilities []bloom.Capability `json:"capabilities"`
+	// HMACKeyID отпечаток ключа HMAC, если значения хранятся с ключом
+	HMACKeyID string `json:"hmac_key_id,omitempty"`
+}
+
 func init() {
 	isReady = false
 }
 
+// Start создаёт все фильтры из конфигурации. Первый фильтр в списке используется по умолчанию.
 func Start() {
-	logCh := make(chan bloom.LogEvent, 10)
+	logCh = make(chan bloom.LogEvent, 10)
 	go handleLogs(logCh)
 
-	sourceFile := viper.GetString("source")
-	force := viper.GetBool("force")
-	checkpointPath := viper.GetString("checkpoint_path")
-
-	Filter, _ = bloom.MakeEngine(
-		bloom.StableBloom,
-		sourceFile,
-		force,
-		logCh,
-		checkpointPath,
-	)
+	configs, err := FilterConfigs()
+	if err != nil {
+		log.Fatal().Err(err).Send()
+	}
+
+	for _, cfg := range configs {
+		if IsUpgrade() {
+			// предыдущий процесс только что сохранил дамп, источник повторно не читаем
+			cfg.Force = false
+		}
+		markPending(cfg)
+	}
+	for _, cfg := range configs {
+		filter, errs := createFilter(cfg, viper.GetDuration("checkpoint_interval"))
+		if errs != nil {
+			log.Fatal().Err(errs).Str("filter", cfg.Name).Send()
+		}
+		registerFilter(cfg, filter)
+		unmarkPending(cfg.Name)
+		startWatch(cfg)
+		startTail(cfg)
+	}
+	setDefaultFilter(configs[0].Name)
 	isReady = true
+}
 
+// Checkpoint сохраняет все фильтры.
+func Checkpoint() error {
+	if !isReady {
+		return nil
+	}
+	checkpointMux.Lock()
+	defer checkpointMux.Unlock()
+
+	names, snapshot := registeredFilters()
+	var errs []error
+	var failed []string
+	for _, name := range names {
+		if err := checkpointFilter(name, snapshot[name]); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", name, err))
+			failed = append(failed, name)
+		}
+	}
+	err := errors.Join(errs...)
+	if err != nil {
+		log.Error().Err(err).Strs("filters", failed).Msg("[checkpoint] filters are not saved")
+	}
+	return err
 }
 
-func Checkpoint() {
+// checkpointFilter сохраняет фильтр и фиксирует прочитанное watch и tail.
+func checkpointFilter(name string, filter bloom.Filter) error {
 	start := time.Now()
-	if isReady && Filter.Checkpoint() {
-		dumpSize := Filter.GetDumpSize()
-		bloom.StopWatchLog(Filter.LogCh(), start, "📍 Checkpoint done "+utils.HumByte(&dumpSize))
+	commitWatch, commitTail := watchCommit(name), tailCommit(name)
+	saved, err := filter.Checkpoint()
+	if err == nil {
+		commitWatch()
+		commitTail()
+	}
+	if saved || err != nil {
+		rememberCheckpoint(name, start, err)
+	}
+	if saved {
+		dumpSize := filter.GetDumpSize()
+		bloom.StopWatchLog(filter.LogCh(), start, fmt.Sprintf("📍 Checkpoint `%s` done %s", name, utils.HumByte(&dumpSize)))
 	}
+	return err
 }
 
 // Эксперименты с каналами
@@ -107,6 +208,17 @@ func checkIsReady(w http.ResponseWriter) error {
 	return nil
 }
 
+// checkWritable отвечает 503, пока запись заблокирована (обновление или остановка).
+func checkWritable(w http.ResponseWriter) error {
+	if writesBlocked.Load() {
+		w.Header().Set("Retry-After", "1")
+		httpRespond(w, http.StatusServiceUnavailable, msgWritesBlocked)
+		return errors.New("FAIL")
+	}
+
+	return nil
+}
+
 func decodeInputJSON(w http.ResponseWriter, r *http.Request) RequestData {
 	var data RequestData
 	err := json.NewDecoder(r.Body).Decode(&data)
@@ -116,24 +228,45 @@ func decodeInputJSON(w http.ResponseWriter, r *http.Request) RequestData {
 	return data
 }
 
+// requestFilter возвращает фильтр по имени из запроса или отвечает 404.
+func requestFilter(w http.ResponseWriter, name string) (string, bloom.Filter, error) {
+	name, filter, err := getFilter(name)
+	if err != nil {
+		httpRespond(w, http.StatusNotFound, err.Error())
+		return name, nil, err
+	}
+	return name, filter, nil
+}
+
 func handleFastCheck(w http.ResponseWriter, r *http.Request) {
 	start := time.Now()
 	if r.Method != http.MethodHead {
 		httpRespond(w, http.StatusMethodNotAllowed, "")
 	}
 
+	name, filter, err := requestFilter(w, r.URL.Query().Get("filter"))
+	if err != nil {
+		return
+	}
+
 	value := r.URL.Query().Get("value")
-	result := Filter.Test(value)
+	if err = bloom.ValidateValue(filter, value); err != nil {
+		observeRejected(name, err)
+		httpRespond(w, http.StatusBadRequest, "")
+		return
+	}
+	result := filter.Test(value)
+	observeCheck(name, result)
 
 	status := http.StatusNotFound
 	if result {
 		status = http.StatusOK
 	}
 
-	bloom.StopWatchLog(Filter.LogCh(), start, searchMsg)
+	bloom.StopWatchLog(filter.LogCh(), start, searchMsg)
 
 	// TODO проверить что заголовок передаётся на клиент
-	w.Header().Set("x-filter", string(Filter.Engine()))
+	w.Header().Set("x-filter", filter.Engine().String())
 	httpRespond(w, status, "")
 }
 
@@ -145,14 +278,21 @@ func handleCheck(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	value := decodeInputJSON(w, r).Value
+	data := decodeInputJSON(w, r)
+	value := data.Value
 
-	err = queryValidate(w, value)
+	name, filter, err := requestFilter(w, data.Filter)
 	if err != nil {
 		return
 	}
 
-	result := Filter.Test(value)
+	err = queryValidate(w, name, filter, value)
+	if err != nil {
+		return
+	}
+
+	result := filter.Test(value)
+	observeCheck(name, result)
 	msg := "Absolutely NOT exist!"
 	status := http.StatusNotFound
 	if result {
@@ -160,7 +300,7 @@ func handleCheck(w http.ResponseWriter, r *http.Request) {
 		status = http.StatusOK
 	}
 
-	bloom.StopWatchLog(Filter.LogCh(), start, searchMsg)
+	bloom.StopWatchLog(filter.LogCh(), start, searchMsg)
 
 	httpRespond(w, status, msg)
 }
@@ -168,18 +308,31 @@ func handleCheck(w http.ResponseWriter, r *http.Request) {
 func handleAdd(w http.ResponseWriter, r *http.Request) {
 	start := time.Now()
 
-	value := decodeInputJSON(w, r).Value
+	data := decodeInputJSON(w, r)
+	value := data.Value
+
+	err := checkWritable(w)
+	if err != nil {
+		return
+	}
+
+	name, filter, err := requestFilter(w, data.Filter)
+	if err != nil {
+		return
+	}
 
-	err := queryValidate(w, value)
+	err = queryValidate(w, name, filter, value)
 	if err != nil {
 		return
 	}
 
-	if Filter.TestAndAdd(value) {
-		bloom.StopWatchLog(Filter.LogCh(), start, searchAddMsg)
+	added := filter.TestAndAdd(value)
+	observeAdd(name, added)
+	if added {
+		bloom.StopWatchLog(filter.LogCh(), start, searchAddMsg)
 		httpRespond(w, http.StatusCreated, "✅ Добавлено!")
 	} else {
-		bloom.StopWatchLog(Filter.LogCh(), start, searchMsg)
+		bloom.StopWatchLog(filter.LogCh(), start, searchMsg)
 		httpNotModified(w)
 	}
 }
@@ -191,17 +344,31 @@ func handleBulkLoad(w http.ResponseWriter, r *http.Request) {
 	err := json.NewDecoder(r.Body).Decode(&bulk)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err = checkWritable(w); err != nil {
+		return
+	}
+
+	name, filter, err := requestFilter(w, bulk.Filter)
+	if err != nil {
+		return
+	}
+	if err = bulkValidate(w, name, filter, bulk.Data); err != nil {
+		return
 	}
 
 	added := 0
 	for _, entity := range bulk.Data {
-		if Filter.TestAndAdd(entity) {
+		if filter.TestAndAdd(entity) {
 			added++
 		}
 	}
 	skipped := len(bulk.Data) - added
+	observeAdds(name, added, skipped)
 	msg := fmt.Sprintf("[bulk] ✅ Добавлено: %d, Пропущено: %d", added, skipped)
-	bloom.StopWatchLog(Filter.LogCh(), start, msg)
+	bloom.StopWatchLog(filter.LogCh(), start, msg)
 
 	if added == 0 {
 		httpNotModified(w)
@@ -210,19 +377,224 @@ func handleBulkLoad(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// handleStats статистика фильтра: заполненность, оценки FP rate и количества уникальных значений.
+func handleStats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		httpRespond(w, http.StatusMethodNotAllowed, "")
+		return
+	}
+	if err := checkIsReady(w); err != nil {
+		return
+	}
+
+	name, filter, err := requestFilter(w, r.URL.Query().Get("filter"))
+	if err != nil {
+		return
+	}
+
+	allowSlowResponse(w)
+	httpRespondJSON(w, http.StatusOK, ResponseStats{Filter: name, Stats: filter.Stats()})
+}
+
+// requestCounter фильтр из запроса с возможностью counter, иначе 400.
+func requestCounter(w http.ResponseWriter, name string) (string, bloom.Filter, bloom.Counter, error) {
+	name, filter, err := requestFilter(w, name)
+	if err != nil {
+		return name, nil, nil, err
+	}
+	counter, ok := bloom.AsCounter(filter)
+	if !ok {
+		err = fmt.Errorf("filter `%s` (%s) does not support counters", name, filter.Engine())
+		httpRespond(w, http.StatusBadRequest, err.Error())
+		return name, nil, nil, err
+	}
+	return name, filter, counter, nil
+}
+
+// handleIncr увеличивает счётчик значения на delta (по умолчанию 1) и возвращает новую оценку.
+func handleIncr(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	if r.Method != http.MethodPost {
+		httpRespond(w, http.StatusMethodNotAllowed, "")
+		return
+	}
+
+	if err := checkIsReady(w); err != nil {
+		return
+	}
+	if err := checkWritable(w); err != nil {
+		return
+	}
+
+	data := decodeInputJSON(w, r)
+	delta := data.Delta
+	if delta == 0 {
+		delta = 1
+	}
+	if delta > incrMaxDelta {
+		httpRespond(w, http.StatusBadRequest, fmt.Sprintf("delta must be <= %d", incrMaxDelta))
+		return
+	}
+
+	name, filter, counter, err := requestCounter(w, data.Filter)
+	if err != nil {
+		return
+	}
+	if err = queryValidate(w, name, filter, data.Value); err != nil {
+		return
+	}
+
+	count := counter.Incr(data.Value, delta)
+	observeIncr(name)
+	bloom.StopWatchLog(filter.LogCh(), start, searchAddMsg)
+	httpRespondJSON(w, http.StatusOK, ResponseCount{Filter: name, Value: data.Value, Count: count})
+}
+
+// handleCount оценка частоты значения, может быть только больше настоящей.
+func handleCount(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	if r.Method != http.MethodPost {
+		httpRespond(w, http.StatusMethodNotAllowed, "")
+		return
+	}
+
+	if err := checkIsReady(w); err != nil {
+		return
+	}
+
+	data := decodeInputJSON(w, r)
+
+	name, filter, counter, err := requestCounter(w, data.Filter)
+	if err != nil {
+		return
+	}
+	if err = queryValidate(w, name, filter, data.Value); err != nil {
+		return
+	}
+
+	count := counter.Count(data.Value)
+	observeCheck(name, count > 0)
+	bloom.StopWatchLog(filter.LogCh(), start, searchMsg)
+	httpRespondJSON(w, http.StatusOK, ResponseCount{Filter: name, Value: data.Value, Count: count})
+}
+
+// handleTopK самые частые дубли фильтра (?n=, по умолчанию весь топ).
+func handleTopK(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		httpRespond(w, http.StatusMethodNotAllowed, "")
+		return
+	}
+	if err := checkIsReady(w); err != nil {
+		return
+	}
+
+	n := 0
+	if param := r.URL.Query().Get("n"); param != "" {
+		var err error
+		if n, err = strconv.Atoi(param); err != nil || n <= 0 {
+			httpRespond(w, http.StatusBadRequest, "n must be a positive integer")
+			return
+		}
+	}
+
+	name, filter, err := requestFilter(w, r.URL.Query().Get("filter"))
+	if err != nil {
+		return
+	}
+	topk, ok := bloom.AsTopK(filter)
+	if !ok {
+		httpRespond(w, http.StatusBadRequest, fmt.Sprintf("filter `%s` has no top-k, set topk in config", name))
+		return
+	}
+
+	httpRespondJSON(w, http.StatusOK, ResponseTopK{Filter: name, Top: topk.Top(n)})
+}
+
+// handleFilters список фильтров с движком и возможностями (counter, cardinality, ...).
+func handleFilters(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		httpRespond(w, http.StatusMethodNotAllowed, "")
+		return
+	}
+	if err := checkIsReady(w); err != nil {
+		return
+	}
+
+	defaultName, _, _ := getFilter("")
+	names, snapshot := registeredFilters()
+	response := make([]ResponseFilter, 0, len(names))
+	for _, name := range names {
+		filter := snapshot[name]
+		response = append(response, ResponseFilter{
+			Name:         name,
+			Engine:       filter.Engine().String(),
+			Default:      name == defaultName,
+			Capabilities: bloom.Capabilities(filter),
+			HMACKeyID:    bloom.KeyID(filter),
+		})
+	}
+	httpRespondJSON(w, http.StatusOK, response)
+}
+
 func handleCheckpoint(w http.ResponseWriter, r *http.Request) {
 	if r.Method == http.MethodPut {
-		Checkpoint()
+		if err := Checkpoint(); err != nil {
+			httpRespond(w, http.StatusInternalServerError, err.Error())
+			return
+		}
 		httpRespond(w, http.StatusCreated, "Success!")
 	}
 }
 
-func queryValidate(w http.ResponseWriter, value string) error {
-	if len(value) <= valueMinLen {
-		httpRespond(w, http.StatusBadRequest, fmt.Sprintf("value lenght must be >= %d", valueMinLen))
-		return errors.New("FAIL")
+// queryValidate проверяет значение по правилам фильтра (после нормализации), иначе 400 с нарушенным правилом.
+func queryValidate(w http.ResponseWriter, name string, filter bloom.Filter, value string) error {
+	err := bloom.ValidateValue(filter, value)
+	if err == nil {
+		return nil
 	}
-	return nil
+	observeRejected(name, err)
+	respondValidation(w, err.Error(), []ValueError{{ValidationError: asValidationError(err)}})
+	return errors.New("FAIL")
+}
+
+// bulkValidate проверяет размер /api/bulk и каждое значение: если хоть одно не прошло, не добавляется ничего.
+func bulkValidate(w http.ResponseWriter, name string, filter bloom.Filter, values []string) error {
+	if err := bloom.ValidateBulk(filter, len(values)); err != nil {
+		observeRejected(name, err)
+		respondValidation(w, err.Error(), []ValueError{{ValidationError: asValidationError(err)}})
+		return err
+	}
+
+	var valueErrors []ValueError
+	rejected := 0
+	for i, value := range values {
+		err := bloom.ValidateValue(filter, value)
+		if err == nil {
+			continue
+		}
+		observeRejected(name, err)
+		if rejected++; len(valueErrors) < bulkMaxErrors {
+			valueErrors = append(valueErrors, ValueError{Index: &i, ValidationError: asValidationError(err)})
+		}
+	}
+	if rejected == 0 {
+		return nil
+	}
+	msg := fmt.Sprintf("%d of %d values rejected, nothing added", rejected, len(values))
+	respondValidation(w, msg, valueErrors)
+	return errors.New(msg)
+}
+
+func asValidationError(err error) *bloom.ValidationError {
+	var invalid *bloom.ValidationError
+	if !errors.As(err, &invalid) {
+		invalid = &bloom.ValidationError{Message: err.Error()}
+	}
+	return invalid
+}
+
+func respondValidation(w http.ResponseWriter, msg string, valueErrors []ValueError) {
+	httpRespondJSON(w, http.StatusBadRequest, ResponseValidation{Message: msg, Status: http.StatusBadRequest, Errors: valueErrors})
 }
 
 func httpRespond(w http.ResponseWriter, statusCode int, msg string) {
@@ -239,6 +611,15 @@ func httpRespond(w http.ResponseWriter, statusCode int, msg string) {
 	}
 }
 
+func httpRespondJSON(w http.ResponseWriter, statusCode int, data any) {
+	w.Header().Set(ContentType, ContentTypeJSON)
+	w.WriteHeader(statusCode)
+
+	if err := json.NewEncoder(w).Encode(data); err != nil {
+		log.Error().Msgf("%s: %v", MsgJSONError, err)
+	}
+}
+
 func httpNotModified(w http.ResponseWriter) {
 	w.WriteHeader(http.StatusNotModified)
 }
@@ -0,0 +1,61 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"bloom-du/internal/bloom"
+)
+
+func TestBulkValidation(t *testing.T) {
+	logCh := make(chan bloom.LogEvent)
+	go func() {
+		for range logCh {
+		}
+	}()
+	minLen := 3
+	cfg := FilterConfig{Name: "bulk-test", ValueMinLen: &minLen, ValueCharset: "digits", MaxBulk: 3,
+		CheckpointPath: filepath.Join(t.TempDir(), "filter.bloom"), Window: 3600e9, Generations: 1, Capacity: 1_000}
+	validator, err := bloom.NewValidator(cfg.validationRules())
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := bloom.Options{Window: cfg.Window, Generations: cfg.Generations, Capacity: cfg.Capacity, Validator: validator}
+	filter, err := bloom.MakeEngine(bloom.RotatingBloom, nil, false, logCh, cfg.CheckpointPath, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	registerFilter(cfg, filter)
+	defer unregisterFilter(cfg.Name)
+
+	bulk := func(body string) (int, ResponseValidation) {
+		t.Helper()
+		recorder := httptest.NewRecorder()
+		handleBulkLoad(recorder, httptest.NewRequest(http.MethodPost, "/api/bulk", strings.NewReader(body)))
+		var response ResponseValidation
+		_ = json.NewDecoder(recorder.Body).Decode(&response)
+		return recorder.Code, response
+	}
+
+	// одно значение не прошло - не добавляется ничего
+	code, response := bulk(`{"filter":"bulk-test","data":["123","12","12a"]}`)
+	if code != http.StatusBadRequest || len(response.Errors) != 2 || *response.Errors[0].Index != 1 ||
+		response.Errors[0].Rule != bloom.RuleMinLen || response.Errors[1].Rule != bloom.RuleCharset {
+		t.Fatalf("bulk with invalid values = %d %+v", code, response)
+	}
+	if filter.Test("123") {
+		t.Error("valid value of rejected bulk must not be added")
+	}
+
+	if code, response = bulk(`{"filter":"bulk-test","data":["123","456","789","101"]}`); code != http.StatusBadRequest ||
+		response.Errors[0].Rule != bloom.RuleMaxBulk {
+		t.Errorf("bulk over max_bulk = %d %+v", code, response)
+	}
+	if code, _ = bulk(`{"filter":"bulk-test","data":["123","456"]}`); code != http.StatusCreated || !filter.Test("456") {
+		t.Errorf("valid bulk = %d", code)
+	}
+}
@@ -0,0 +1,294 @@
+package api
+
+import (
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"bloom-du/internal/bloom"
+	"bloom-du/internal/build"
+)
+
+const (
+	labelFilter = "filter"
+	labelResult = "result"
+	labelOp     = "op"
+	labelFormat = "format"
+
+	opCheck         = "check"
+	opAdd           = "add"
+	opIncr          = "incr"
+	resultPresent   = "present"
+	resultAbsent    = "absent"
+	resultAdded     = "added"
+	resultExists    = "exists"
+	resultMalformed = "malformed"
+	resultRejected  = "rejected"
+	labelRule       = "rule"
+
+	checkpointSuccess = "success"
+	checkpointError   = "error"
+)
+
+// Метрики фильтров обновляются раз в metrics_interval: заполненность считается по всему битовому массиву
+// под read lock фильтра, запись новых элементов при этом не блокируется.
+var (
+	filterFillRatio   = newFilterGauge("fill_ratio", "Доля ненулевых ячеек фильтра")
+	filterFpRate      = newFilterGauge("fp_rate_estimated", "Оценка вероятности ложноположительного ответа при текущей заполненности")
+	filterCardinality = newFilterGauge("cardinality_estimated",
+		"Оценка количества уникальных элементов по заполненности (classic, redis)")
+	filterDistinct = newFilterGauge("distinct_estimated",
+		"Оценка HyperLogLog количества уникальных значений, прошедших через фильтр (cardinality: true)")
+	filterMemory    = newFilterGauge("memory_bytes", "Размер данных фильтра в памяти")
+	filterDumpBytes = newFilterGauge("dump_bytes", "Размер последнего дампа")
+
+	checkpointTimestamp = newFilterGauge("last_checkpoint_timestamp_seconds", "Время последнего чекпоинта")
+	checkpointDuration  = newFilterGauge("last_checkpoint_duration_seconds", "Длительность последнего чекпоинта")
+	checkpointLastOK    = newFilterGauge("last_checkpoint_success", "1 - последний чекпоинт успешен, 0 - с ошибкой")
+	saturationState     = newFilterGauge("saturation_state", "Уровень насыщения фильтра: 0 - ok, 1 - warning, 2 - critical")
+	// sourceMalformed записи источника, пропущенные при последней загрузке, по формату источника
+	sourceMalformed = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Subsystem: "filter",
+			Name:      "source_malformed_records",
+			Help:      "Некорректные записи источника (csv, ndjson), пропущенные при последней загрузке",
+		}, []string{labelFilter, labelFormat},
+	)
+	sourceRejected = newFilterGauge("source_rejected_records",
+		"Записи источника, которые не прошли правила проверки значений при последней загрузке")
+	// filterRejected значения API, сокета и tail, которые не прошли правила проверки, по правилу
+	filterRejected = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Subsystem: "filter",
+			Name:      "rejected_values_total",
+			Help:      "Количество значений, отклонённых правилами проверки (min_len, max_len, pattern, charset, max_bulk)",
+		}, []string{labelFilter, labelRule},
+	)
+	checkpointsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Subsystem: "filter",
+			Name:      "checkpoints_total",
+			Help:      "Количество чекпоинтов, которые что-то сохраняли, по результату",
+		}, []string{labelFilter, labelResult},
+	)
+
+	// filterOperations результаты проверок и добавлений: доля exists среди add - доля дублей.
+	filterOperations = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Subsystem: "filter",
+			Name:      "operations_total",
+			Help:      "Количество операций с фильтром по типу (check, add) и результату (present, absent, added, exists)",
+		}, []string{labelFilter, labelOp, labelResult},
+	)
+
+	// watchFiles, watchRecords файлы и записи, прочитанные из директории watch в работающий фильтр.
+	watchFiles = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Subsystem: "watch",
+			Name:      "files_total",
+			Help:      "Количество прочитанных файлов директории watch по результату (ingested, error)",
+		}, []string{labelFilter, labelResult},
+	)
+	watchRecords = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Subsystem: "watch",
+			Name:      "records_total",
+			Help:      "Количество записей из файлов директории watch по результату (added, exists, malformed, rejected)",
+		}, []string{labelFilter, labelResult},
+	)
+	watchTimestamp = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Subsystem: "watch",
+			Name:      "last_ingest_timestamp_seconds",
+			Help:      "Время последнего удачно прочитанного файла директории watch",
+		}, []string{labelFilter},
+	)
+
+	// tailLines, tailRotations строки растущего файла tail и его ротации (rotated, truncated).
+	tailLines = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Subsystem: "tail",
+			Name:      "lines_total",
+			Help:      "Количество строк файла tail по результату (added, exists, rejected)",
+		}, []string{labelFilter, labelResult},
+	)
+	tailRotations = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Subsystem: "tail",
+			Name:      "rotations_total",
+			Help:      "Количество переходов файла tail на начало: rotated - новый файл, truncated - файл обрезан",
+		}, []string{labelFilter, labelResult},
+	)
+
+	filterGauges = []*prometheus.GaugeVec{
+		filterFillRatio, filterFpRate, filterCardinality, filterDistinct, filterMemory, filterDumpBytes,
+		checkpointTimestamp, checkpointDuration, checkpointLastOK, saturationState, sourceMalformed,
+		sourceRejected, watchTimestamp,
+	}
+)
+
+func newFilterGauge(name, help string) *prometheus.GaugeVec {
+	return prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Subsystem: "filter",
+			Name:      name,
+			Help:      help,
+		}, []string{labelFilter},
+	)
+}
+
+func registerFilterMetrics() {
+	for _, gauge := range filterGauges {
+		prometheus.MustRegister(gauge)
+	}
+	prometheus.MustRegister(checkpointsTotal, filterOperations, watchFiles, watchRecords, tailLines, tailRotations, filterRejected)
+}
+
+// RunMetrics периодически обновляет метрики фильтров.
+func RunMetrics(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		refreshFilterMetrics()
+		<-ticker.C
+	}
+}
+
+func refreshFilterMetrics() {
+	if !isReady {
+		return
+	}
+
+	names, snapshot := registeredFilters()
+	configs := registeredConfigs()
+	for _, name := range names {
+		filter := snapshot[name]
+		stats := filter.Stats()
+		checkSaturation(name, configs[name].Saturation, stats)
+
+		CurrentConfig.WithLabelValues(
+			name,
+			stats.Engine,
+			strconv.FormatUint(stats.Cells, 10),
+			strconv.FormatUint(stats.K, 10),
+			strconv.FormatFloat(stats.TargetFpRate, 'g', -1, 64),
+			strconv.FormatFloat(stats.StablePoint, 'g', -1, 64),
+			build.Version,
+		).Set(1)
+
+		filterFillRatio.WithLabelValues(name).Set(stats.FillRatio)
+		filterFpRate.WithLabelValues(name).Set(stats.FpRate)
+		if stats.Cardinality > 0 && !math.IsInf(stats.Cardinality, 0) {
+			filterCardinality.WithLabelValues(name).Set(stats.Cardinality)
+		}
+		if stats.Distinct != nil {
+			filterDistinct.WithLabelValues(name).Set(float64(*stats.Distinct))
+		}
+		filterMemory.WithLabelValues(name).Set(float64(stats.MemoryBytes))
+		filterDumpBytes.WithLabelValues(name).Set(float64(filter.GetDumpSize()))
+		if progress, ok := bloom.BootstrapProgress(configs[name].CheckpointPath); ok {
+			sourceMalformed.WithLabelValues(name, progress.Format).Set(float64(progress.Malformed))
+			sourceRejected.WithLabelValues(name).Set(float64(progress.Rejected))
+		}
+	}
+}
+
+func observeCheckpoint(name string, status CheckpointStatus) {
+	result, success := checkpointError, 0.0
+	if status.Success {
+		result, success = checkpointSuccess, 1.0
+	}
+
+	checkpointsTotal.WithLabelValues(name, result).Inc()
+	checkpointTimestamp.WithLabelValues(name).Set(float64(status.Time.Unix()))
+	checkpointDuration.WithLabelValues(name).Set(status.DurationSeconds)
+	checkpointLastOK.WithLabelValues(name).Set(success)
+}
+
+func observeCheck(name string, present bool) {
+	result := resultAbsent
+	if present {
+		result = resultPresent
+	}
+	filterOperations.WithLabelValues(name, opCheck, result).Inc()
+}
+
+func observeAdd(name string, added bool) {
+	result := resultExists
+	if added {
+		result = resultAdded
+	}
+	filterOperations.WithLabelValues(name, opAdd, result).Inc()
+}
+
+// observeIncr счётчик значения увеличен (countmin), result всегда added.
+func observeIncr(name string) {
+	filterOperations.WithLabelValues(name, opIncr, resultAdded).Inc()
+}
+
+// observeAdds учитывает результат пакетной загрузки.
+func observeAdds(name string, added, exists int) {
+	filterOperations.WithLabelValues(name, opAdd, resultAdded).Add(float64(added))
+	filterOperations.WithLabelValues(name, opAdd, resultExists).Add(float64(exists))
+}
+
+// observeWatch учитывает файл директории watch: добавления попадают и в operations_total, как у /api/bulk.
+func observeWatch(name string, result bloom.IngestResult, err error) {
+	exists := result.Records - result.Malformed - result.Rejected - result.Added
+	watchRecords.WithLabelValues(name, resultAdded).Add(float64(result.Added))
+	watchRecords.WithLabelValues(name, resultExists).Add(float64(exists))
+	watchRecords.WithLabelValues(name, resultMalformed).Add(float64(result.Malformed))
+	watchRecords.WithLabelValues(name, resultRejected).Add(float64(result.Rejected))
+	observeAdds(name, int(result.Added), int(exists))
+	if err != nil {
+		watchFiles.WithLabelValues(name, watchFailed).Inc()
+		return
+	}
+	watchFiles.WithLabelValues(name, watchIngested).Inc()
+	watchTimestamp.WithLabelValues(name).SetToCurrentTime()
+}
+
+func observeTail(name string, added, exists, rejected int) {
+	tailLines.WithLabelValues(name, resultAdded).Add(float64(added))
+	tailLines.WithLabelValues(name, resultExists).Add(float64(exists))
+	tailLines.WithLabelValues(name, resultRejected).Add(float64(rejected))
+}
+
+// observeRejected учитывает значение, которое не прошло правило проверки.
+func observeRejected(name string, err error) {
+	filterRejected.WithLabelValues(name, asValidationError(err).Rule).Inc()
+}
+
+func observeTailRotation(name, reason string) {
+	tailRotations.WithLabelValues(name, reason).Inc()
+}
+
+// deleteFilterMetrics убирает серии фильтра, выведенного из работы.
+func deleteFilterMetrics(name string) {
+	labels := prometheus.Labels{labelFilter: name}
+	for _, gauge := range filterGauges {
+		gauge.DeletePartialMatch(labels)
+	}
+	checkpointsTotal.DeletePartialMatch(labels)
+	filterOperations.DeletePartialMatch(labels)
+	watchFiles.DeletePartialMatch(labels)
+	watchRecords.DeletePartialMatch(labels)
+	tailLines.DeletePartialMatch(labels)
+	tailRotations.DeletePartialMatch(labels)
+	filterRejected.DeletePartialMatch(labels)
+	forgetSaturation(name)
+	CurrentConfig.DeletePartialMatch(labels)
+}
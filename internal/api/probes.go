@@ -0,0 +1,193 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"bloom-du/internal/bloom"
+)
+
+const (
+	probeOK   = "ok"
+	probeWarn = "warn"
+	probeFail = "fail"
+)
+
+// ProbeResult состояние одного компонента или фильтра. warn не снимает готовность.
+type ProbeResult struct {
+	Status  string `json:"status"`
+	Message string `json:"message,omitempty"`
+}
+
+// LivenessReport ответ /livez.
+type LivenessReport struct {
+	Status        string  `json:"status"`
+	Pid           int     `json:"pid"`
+	UptimeSeconds float64 `json:"uptime_seconds"`
+}
+
+// ReadinessReport ответ /readyz: общий статус, компоненты и фильтры.
+type ReadinessReport struct {
+	Status     string                 `json:"status"`
+	Components map[string]ProbeResult `json:"components"`
+	Filters    map[string]ProbeResult `json:"filters"`
+}
+
+// handleLivez процесс жив и обслуживает HTTP, даже если фильтры ещё загружаются.
+func handleLivez(w http.ResponseWriter, _ *http.Request) {
+	httpRespondJSON(w, http.StatusOK, LivenessReport{
+		Status:        probeOK,
+		Pid:           os.Getpid(),
+		UptimeSeconds: time.Since(startedAt).Seconds(),
+	})
+}
+
+// handleReadyz 200 если сервис готов принимать запросы, иначе 503 с причинами.
+func handleReadyz(w http.ResponseWriter, _ *http.Request) {
+	report := Readiness()
+	status := http.StatusOK
+	if report.Status == probeFail {
+		status = http.StatusServiceUnavailable
+	}
+	httpRespondJSON(w, status, report)
+}
+
+// Readiness проверяет загрузку фильтров, запись в директории чекпоинтов, свободное место
+// под дамп и насыщение фильтров. Заполненность не пересчитывается, берётся последняя оценка.
+func Readiness() ReadinessReport {
+	report := ReadinessReport{
+		Components: map[string]ProbeResult{},
+		Filters:    map[string]ProbeResult{},
+	}
+
+	report.Components["bootstrap"] = ProbeResult{Status: probeOK}
+	if !isReady {
+		report.Components["bootstrap"] = ProbeResult{Status: probeFail, Message: "filters are loading"}
+	}
+
+	names, snapshot := registeredFilters()
+	configs := registeredConfigs()
+	dumps := map[string]uint64{}
+	for _, name := range names {
+		dir := filepath.Dir(configs[name].CheckpointPath)
+		dumps[dir] = max(dumps[dir], snapshot[name].GetDumpSize())
+		report.Filters[name] = filterReadiness(name)
+	}
+	for _, cfg := range pendingConfigs() {
+		dir := filepath.Dir(cfg.CheckpointPath)
+		if _, ok := dumps[dir]; !ok {
+			dumps[dir] = 0
+		}
+		// после старта новый фильтр (перезагрузка конфигурации) не снимает готовность с остальных
+		status := probeFail
+		if isReady {
+			status = probeWarn
+		}
+		report.Filters[cfg.Name] = ProbeResult{Status: status, Message: bootstrapMessage(cfg.CheckpointPath)}
+	}
+
+	report.Components["checkpoint_dir"] = checkDirs(dumps, checkWritableDir)
+	report.Components["disk_space"] = checkDirs(dumps, checkFreeSpace)
+
+	report.Status = probeOK
+	for _, results := range []map[string]ProbeResult{report.Components, report.Filters} {
+		for _, result := range results {
+			switch {
+			case result.Status == probeFail:
+				report.Status = probeFail
+			case result.Status == probeWarn && report.Status == probeOK:
+				report.Status = probeWarn
+			}
+		}
+	}
+	return report
+}
+
+func filterReadiness(name string) ProbeResult {
+	saturationMux.Lock()
+	status := saturationStates[name]
+	saturationMux.Unlock()
+
+	switch status.level {
+	case saturationCritical:
+		return ProbeResult{Status: probeFail, Message: fmt.Sprintf("saturation critical: %s", strings.Join(status.reasons, ", "))}
+	case saturationWarning:
+		return ProbeResult{Status: probeWarn, Message: fmt.Sprintf("saturation warning: %s", strings.Join(status.reasons, ", "))}
+	}
+	return ProbeResult{Status: probeOK}
+}
+
+func bootstrapMessage(checkpointPath string) string {
+	progress, ok := bloom.BootstrapProgress(checkpointPath)
+	if !ok {
+		return "loading"
+	}
+	message := fmt.Sprintf("loading %d of %d lines", progress.Scanned, progress.Total)
+	if progress.Total == 0 {
+		// stdin: сколько строк будет, заранее неизвестно
+		message = fmt.Sprintf("loading %d lines", progress.Scanned)
+	}
+	if len(progress.Files) > 1 {
+		done := 0
+		for _, file := range progress.Files {
+			if file.Done {
+				done++
+			}
+		}
+		message += fmt.Sprintf(", %d of %d files done", done, len(progress.Files))
+	}
+	return message
+}
+
+// checkDirs выполняет проверку для каждой директории чекпоинтов, dumps - размер самого большого дампа в ней.
+func checkDirs(dumps map[string]uint64, check func(dir string, dumpBytes uint64) error) ProbeResult {
+	dirs := make([]string, 0, len(dumps))
+	for dir := range dumps {
+		dirs = append(dirs, dir)
+	}
+	slices.Sort(dirs)
+
+	var failed []string
+	for _, dir := range dirs {
+		if err := check(dir, dumps[dir]); err != nil {
+			failed = append(failed, err.Error())
+		}
+	}
+	if len(failed) > 0 {
+		return ProbeResult{Status: probeFail, Message: strings.Join(failed, "; ")}
+	}
+	return ProbeResult{Status: probeOK}
+}
+
+func checkWritableDir(dir string, _ uint64) error {
+	file, err := os.CreateTemp(dir, ".readyz-*")
+	if err != nil {
+		return fmt.Errorf("%s: %w", dir, err)
+	}
+	_ = file.Close()
+	return os.Remove(file.Name())
+}
+
+// checkFreeSpace чекпоинт пишет новый дамп рядом со старым, поэтому места нужно не меньше
+// размера текущего дампа плюс min_free_disk_bytes.
+func checkFreeSpace(dir string, dumpBytes uint64) error {
+	free, err := freeDiskBytes(dir)
+	if errors.Is(err, errors.ErrUnsupported) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %w", dir, err)
+	}
+
+	need := dumpBytes + uint64(currentSettings().minFreeDiskBytes)
+	if free < need {
+		return fmt.Errorf("%s: %d bytes free, need %d", dir, free, need)
+	}
+	return nil
+}
@@ -0,0 +1,372 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/viper"
+
+	"bloom-du/internal/utils"
+)
+
+// ReloadReport результат перечитывания конфигурации.
+type ReloadReport struct {
+	ConfigFile      string   `json:"config_file"`
+	Applied         []string `json:"applied"`
+	Created         []string `json:"created"`
+	Retired         []string `json:"retired"`
+	RestartRequired []string `json:"restart_required"`
+	Errors          []string `json:"errors"`
+}
+
+// settings значения, применённые при старте или последней перезагрузке. Reload перезаписывает
+// конфигурацию viper, поэтому вне reloadMux настройки читаются только отсюда.
+type settings struct {
+	logLevel           string
+	logFile            string
+	checkpointInterval time.Duration
+	adminToken         string
+	minFreeDiskBytes   int64
+	saturationWebhook  string
+	shutdownTimeout    time.Duration
+	checkpointTimeout  time.Duration
+	upgradeTimeout     time.Duration
+}
+
+var (
+	reloadMux            sync.Mutex
+	applied              atomic.Pointer[settings]
+	checkpointIntervalCh = make(chan time.Duration, 1)
+)
+
+func readSettings() *settings {
+	return &settings{
+		logLevel:           viper.GetString("log_level"),
+		logFile:            viper.GetString("log_file"),
+		checkpointInterval: viper.GetDuration("checkpoint_interval"),
+		adminToken:         viper.GetString("admin_token"),
+		minFreeDiskBytes:   viper.GetInt64("min_free_disk_bytes"),
+		saturationWebhook:  viper.GetString("saturation_webhook"),
+		shutdownTimeout:    viper.GetDuration("shutdown_timeout"),
+		checkpointTimeout:  viper.GetDuration("checkpoint_timeout"),
+		upgradeTimeout:     viper.GetDuration("upgrade_timeout"),
+	}
+}
+
+// ApplySettings запоминает прочитанную конфигурацию, вызывается до запуска серверов.
+func ApplySettings() {
+	applied.Store(readSettings())
+}
+
+func currentSettings() *settings {
+	if current := applied.Load(); current != nil {
+		return current
+	}
+	return &settings{}
+}
+
+// Timeouts таймауты остановки и обновления из применённой конфигурации.
+func Timeouts() (shutdown, checkpoint, upgrade time.Duration) {
+	current := currentSettings()
+	return current.shutdownTimeout, current.checkpointTimeout, current.upgradeTimeout
+}
+
+// RunCheckpoints периодически сохраняет все фильтры. Интервал можно поменять через Reload.
+func RunCheckpoints(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			_ = Checkpoint()
+		case interval = <-checkpointIntervalCh:
+			ticker.Reset(interval)
+		}
+	}
+}
+
+// Reload перечитывает файл конфигурации и применяет то, что можно применить без перезапуска:
+// уровень логирования, интервал чекпоинтов, адреса HTTP сервера и unix сокета, список фильтров
+// и их пороги насыщения.
+func Reload() ReloadReport {
+	reloadMux.Lock()
+	defer reloadMux.Unlock()
+
+	report := ReloadReport{
+		ConfigFile:      viper.ConfigFileUsed(),
+		Applied:         []string{},
+		Created:         []string{},
+		Retired:         []string{},
+		RestartRequired: []string{},
+		Errors:          []string{},
+	}
+	if !isReady {
+		report.addError(errors.New("filters are not ready yet, try again later"))
+		report.log()
+		return report
+	}
+	if report.ConfigFile == "" {
+		report.addError(errors.New("no config file, start with --config"))
+		report.log()
+		return report
+	}
+	if err := viper.ReadInConfig(); err != nil {
+		report.addError(err)
+		report.log()
+		return report
+	}
+
+	previous, next := currentSettings(), readSettings()
+	reloadLogging(&report, previous, next)
+	reloadCheckpointInterval(&report, previous, next)
+	reloadRuntime(&report, previous, next)
+	applied.Store(next)
+	reloadListeners(&report)
+	reloadFilters(&report)
+
+	report.log()
+	return report
+}
+
+func reloadLogging(report *ReloadReport, previous, next *settings) {
+	if next.logLevel != previous.logLevel {
+		zerolog.SetGlobalLevel(utils.ParseLogLevel(next.logLevel))
+		report.Applied = append(report.Applied, "log_level="+next.logLevel)
+	}
+	if next.logFile != previous.logFile {
+		report.RestartRequired = append(report.RestartRequired, "log_file")
+		next.logFile = previous.logFile
+	}
+}
+
+func reloadCheckpointInterval(report *ReloadReport, previous, next *settings) {
+	interval := next.checkpointInterval
+	if interval == previous.checkpointInterval {
+		return
+	}
+	if interval <= 0 {
+		report.addError(fmt.Errorf("checkpoint_interval must be > 0, got %s", interval))
+		next.checkpointInterval = previous.checkpointInterval
+		return
+	}
+
+	select {
+	case <-checkpointIntervalCh:
+	default:
+	}
+	checkpointIntervalCh <- interval
+	report.Applied = append(report.Applied, "checkpoint_interval="+interval.String())
+}
+
+// reloadRuntime настройки, которые читаются на каждый запрос или событие, применяются сразу.
+func reloadRuntime(report *ReloadReport, previous, next *settings) {
+	if next.adminToken != previous.adminToken {
+		report.Applied = append(report.Applied, "admin_token")
+	}
+	if next.minFreeDiskBytes != previous.minFreeDiskBytes {
+		report.Applied = append(report.Applied, fmt.Sprintf("min_free_disk_bytes=%d", next.minFreeDiskBytes))
+	}
+	if next.saturationWebhook != previous.saturationWebhook {
+		report.Applied = append(report.Applied, "saturation_webhook="+next.saturationWebhook)
+	}
+	if next.shutdownTimeout != previous.shutdownTimeout {
+		report.Applied = append(report.Applied, "shutdown_timeout="+next.shutdownTimeout.String())
+	}
+	if next.checkpointTimeout != previous.checkpointTimeout {
+		report.Applied = append(report.Applied, "checkpoint_timeout="+next.checkpointTimeout.String())
+	}
+	if next.upgradeTimeout != previous.upgradeTimeout {
+		report.Applied = append(report.Applied, "upgrade_timeout="+next.upgradeTimeout.String())
+	}
+}
+
+func reloadListeners(report *ReloadReport) {
+	addr := httpAddr()
+	if changed, err := reloadHTTPServer(addr); err != nil {
+		report.addError(fmt.Errorf("http listener: %w", err))
+	} else if changed {
+		report.Applied = append(report.Applied, "listen="+addr)
+	}
+
+	path := viper.GetString("socket_path")
+	if changed, err := reloadUnixSocket(path); err != nil {
+		report.addError(fmt.Errorf("unix socket: %w", err))
+	} else if changed {
+		report.Applied = append(report.Applied, "socket_path="+path)
+	}
+}
+
+// reloadFilters создаёт новые фильтры (в фоне, т.к. bootstrap может быть долгим)
+// и выводит из работы удалённые, предварительно сохранив их. Создаваемые фильтры сравниваются
+// с конфигурацией так же, как созданные: удалённый из конфигурации не будет зарегистрирован.
+func reloadFilters(report *ReloadReport) {
+	configs, err := FilterConfigs()
+	if err != nil {
+		report.addError(err)
+		return
+	}
+
+	current := registeredConfigs()
+	owners := checkpointOwners()
+	wanted := make(map[string]bool, len(configs))
+	for _, cfg := range configs {
+		wanted[cfg.Name] = true
+		old, ok := current[cfg.Name]
+		if !ok {
+			pending, retired, isPending := pendingConfig(cfg.Name)
+			if !isPending {
+				// дамп другого фильтра, в том числе выводимого из работы, пока он его пишет
+				if owner, used := owners[checkpointKey(cfg.CheckpointPath)]; used {
+					report.addError(fmt.Errorf("filters.%s: checkpoint_path `%s` is used by filter `%s`", cfg.Name, cfg.CheckpointPath, owner))
+					continue
+				}
+				if startCreating(cfg) {
+					report.Created = append(report.Created, cfg.Name)
+				}
+				continue
+			}
+			if retired {
+				// вернули в конфигурацию, пока он создавался: создание продолжается с прежней конфигурацией
+				setPendingRetired(cfg.Name, false)
+				report.Created = append(report.Created, cfg.Name)
+			}
+			old = pending
+		}
+
+		for field, changed := range map[string]bool{
+			"engine":               old.Engine != cfg.Engine,
+			"source":               !slices.Equal(old.Source, cfg.Source),
+			"source_format":        old.SourceFormat != cfg.SourceFormat,
+			"source_delimiter":     old.SourceDelimiter != cfg.SourceDelimiter,
+			"source_header":        old.SourceHeader != cfg.SourceHeader,
+			"source_key":           !slices.Equal(old.SourceKey, cfg.SourceKey),
+			"source_key_separator": old.SourceKeySeparator != cfg.SourceKeySeparator,
+			"source_parallel":      old.SourceParallel != cfg.SourceParallel,
+			"source_skip_ingested": old.SourceSkipIngested != cfg.SourceSkipIngested,
+			"watch":                old.Watch != cfg.Watch,
+			"watch_pattern":        old.WatchPattern != cfg.WatchPattern,
+			"watch_processed_dir":  old.WatchProcessedDir != cfg.WatchProcessedDir,
+			"watch_settle":         old.WatchSettle != cfg.WatchSettle,
+			"tail":                 old.Tail != cfg.Tail,
+			"normalize":            !slices.Equal(old.Normalize, cfg.Normalize),
+			"value_min_len":        old.validationRules().MinLen != cfg.validationRules().MinLen,
+			"value_max_len":        old.ValueMaxLen != cfg.ValueMaxLen,
+			"value_pattern":        old.ValuePattern != cfg.ValuePattern,
+			"value_charset":        old.ValueCharset != cfg.ValueCharset,
+			"max_bulk":             old.MaxBulk != cfg.MaxBulk,
+			"hmac_key_file":        old.HMACKeyFile != cfg.HMACKeyFile,
+			"hmac_key_env":         old.HMACKeyEnv != cfg.HMACKeyEnv,
+			"checkpoint_path":      old.CheckpointPath != cfg.CheckpointPath,
+			"window":               old.Window != cfg.Window,
+			"generations":          old.Generations != cfg.Generations,
+			"capacity":             old.Capacity != cfg.Capacity,
+			"fp_rate":              old.FpRate != cfg.FpRate,
+			"cell_bits":            old.CellBits != cfg.CellBits,
+			"cardinality":          old.Cardinality != cfg.Cardinality,
+			"topk":                 old.TopK != cfg.TopK,
+		} {
+			if changed {
+				report.RestartRequired = append(report.RestartRequired, fmt.Sprintf("filters.%s.%s", cfg.Name, field))
+			}
+		}
+		if old.Saturation != cfg.Saturation {
+			old.Saturation = cfg.Saturation
+			updateFilterConfig(old)
+			report.Applied = append(report.Applied, fmt.Sprintf("filters.%s.saturation", cfg.Name))
+		}
+	}
+	slices.Sort(report.RestartRequired)
+
+	for name := range current {
+		if !wanted[name] {
+			retireFilter(name)
+			report.Retired = append(report.Retired, name)
+		}
+	}
+	for _, cfg := range pendingConfigs() {
+		if !wanted[cfg.Name] {
+			setPendingRetired(cfg.Name, true)
+			report.Retired = append(report.Retired, cfg.Name)
+		}
+	}
+	slices.Sort(report.Retired)
+
+	setDefaultFilter(configs[0].Name)
+}
+
+// startCreating создаёт фильтр в фоне, false если он уже создаётся. Регистрация идёт под reloadMux:
+// если за время создания фильтр убрали из конфигурации, он только сохраняется.
+func startCreating(cfg FilterConfig) bool {
+	if !markPending(cfg) {
+		return false
+	}
+
+	checkpointInterval := viper.GetDuration("checkpoint_interval")
+	go func() {
+		filter, err := createFilter(cfg, checkpointInterval)
+
+		reloadMux.Lock()
+		defer reloadMux.Unlock()
+		latest, wanted := unmarkPending(cfg.Name)
+		if err != nil {
+			log.Error().Err(err).Str("filter", cfg.Name).Msg("[reload] filter is not created")
+			return
+		}
+		if !wanted {
+			_ = checkpointFilter(cfg.Name, filter)
+			deleteFilterMetrics(cfg.Name)
+			log.Info().Str("filter", cfg.Name).Msg("[reload] filter removed from config while creating, saved and retired")
+			return
+		}
+		registerFilter(latest, filter)
+		// процесс останавливается или передал работу новому: позиции watch и tail теперь его
+		if !writesBlocked.Load() {
+			startWatch(latest)
+			startTail(latest)
+		}
+		log.Info().Str("filter", cfg.Name).Str("engine", cfg.Engine).Msg("[reload] filter created")
+	}()
+	return true
+}
+
+// retireFilter убирает фильтр из API и сохраняет его последний чекпоинт. Сначала останавливаются
+// watch и tail: всё, что они успели добавить, попадает в чекпоинт и фиксируется после него.
+func retireFilter(name string) {
+	filter := unregisterFilter(name)
+	if filter == nil {
+		return
+	}
+	commitWatch, commitTail := stopWatch(name), stopTail(name)
+	if checkpointFilter(name, filter) == nil {
+		commitWatch()
+		commitTail()
+	}
+	deleteFilterMetrics(name)
+	log.Info().Str("filter", name).Msg("[reload] filter retired")
+}
+
+func (r *ReloadReport) addError(err error) {
+	r.Errors = append(r.Errors, err.Error())
+}
+
+func (r *ReloadReport) log() {
+	event := log.Info()
+	if len(r.Errors) > 0 {
+		event = log.Error()
+	}
+	event.
+		Str("config_file", r.ConfigFile).
+		Strs("applied", r.Applied).
+		Strs("created", r.Created).
+		Strs("retired", r.Retired).
+		Strs("restart_required", r.RestartRequired).
+		Strs("errors", r.Errors).
+		Msg("[reload] configuration reloaded")
+}
@@ -0,0 +1,147 @@
+package api
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+
+	"bloom-du/internal/bloom"
+)
+
+func TestReloadFilters(t *testing.T) {
+	logCh = make(chan bloom.LogEvent, 10)
+	go func(logCh chan bloom.LogEvent) {
+		for range logCh {
+		}
+	}(logCh)
+	dir := t.TempDir()
+	tailPath := filepath.Join(dir, "a.log")
+	if err := os.WriteFile(tailPath, []byte("first\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	filterConfig := func(name string, extra map[string]any) map[string]any {
+		cfg := map[string]any{
+			"name":            name,
+			"engine":          bloom.RotatingBloom.String(),
+			"checkpoint_path": filepath.Join(dir, name+".bloom"),
+			"window":          "1h",
+			"generations":     1,
+			"capacity":        1_000,
+		}
+		for key, value := range extra {
+			cfg[key] = value
+		}
+		return cfg
+	}
+	reload := func(configs ...map[string]any) ReloadReport {
+		t.Helper()
+		viper.Set("filters", configs)
+		report := ReloadReport{}
+		reloadFilters(&report)
+		if len(report.Errors) > 0 {
+			t.Fatalf("reload errors: %v", report.Errors)
+		}
+		return report
+	}
+	waitCreated := func() {
+		t.Helper()
+		for deadline := time.Now().Add(10 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+			pendingMux.Lock()
+			done := len(pendingFilters) == 0
+			pendingMux.Unlock()
+			if done {
+				return
+			}
+		}
+		t.Fatal("filters are still creating")
+	}
+	t.Cleanup(func() {
+		viper.Set("filters", nil)
+		for name := range registeredConfigs() {
+			retireFilter(name)
+		}
+		setDefaultFilter(DefaultFilter)
+	})
+
+	a := filterConfig("a", map[string]any{"tail": tailPath})
+	b := filterConfig("b", nil)
+
+	// фильтры создаются в фоне и регистрируются под reloadMux, как во время Reload
+	reloadMux.Lock()
+	report := reload(b, a)
+	if !slices.Equal(report.Created, []string{"b", "a"}) {
+		t.Fatalf("created = %v", report.Created)
+	}
+	// b убрали из конфигурации, пока он создаётся
+	report = reload(a)
+	if !slices.Equal(report.Retired, []string{"b"}) || len(report.Created) > 0 {
+		t.Fatalf("retired = %v, created = %v", report.Retired, report.Created)
+	}
+	if name, _, _ := getFilter(""); name == "a" {
+		t.Fatal("default filter must not point at a filter that is still creating")
+	}
+	reloadMux.Unlock()
+	waitCreated()
+
+	if _, _, err := getFilter("b"); err == nil {
+		t.Error("filter removed from config while creating must not be registered")
+	}
+	name, filter, err := getFilter("")
+	if err != nil || name != "a" {
+		t.Fatalf("default filter = %q, %v", name, err)
+	}
+	for deadline := time.Now().Add(5 * time.Second); !filter.Test("first"); time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("tail line is not added")
+		}
+	}
+
+	// пороги применяются сразу, остальное - после перезапуска
+	a["topk"] = 10
+	a["saturation"] = map[string]any{"warning": map[string]any{"fill_ratio": 0.5}}
+	report = reload(a)
+	if !slices.Equal(report.RestartRequired, []string{"filters.a.topk"}) ||
+		!slices.Equal(report.Applied, []string{"filters.a.saturation"}) {
+		t.Fatalf("restart_required = %v, applied = %v", report.RestartRequired, report.Applied)
+	}
+
+	// a выводится из работы: tail остановлен до последнего чекпоинта, позиция сохранена после него
+	report = reload(b)
+	if !slices.Equal(report.Retired, []string{"a"}) || !slices.Equal(report.Created, []string{"b"}) {
+		t.Fatalf("retired = %v, created = %v", report.Retired, report.Created)
+	}
+	if _, _, err = getFilter("a"); err == nil {
+		t.Error("retired filter must be unregistered")
+	}
+	if _, err = os.Stat(filepath.Join(dir, "a.bloom"+tailSuffix)); err != nil {
+		t.Errorf("tail position of retired filter is not saved: %v", err)
+	}
+	restored, err := bloom.MakeEngine(bloom.RotatingBloom, nil, false, logCh, filepath.Join(dir, "a.bloom"),
+		bloom.Options{Window: time.Hour, Generations: 1, Capacity: 1_000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !restored.Test("first") {
+		t.Error("last checkpoint of retired filter lost tail values")
+	}
+	waitCreated()
+
+	// дамп и файлы рядом с ним не могут быть общими у двух фильтров
+	for name, configs := range map[string][]map[string]any{
+		"duplicate in config": {b, filterConfig("c", map[string]any{"checkpoint_path": filepath.Join(dir, "x", "..", "b.bloom")})},
+		"used by running":     {filterConfig("c", map[string]any{"checkpoint_path": filepath.Join(dir, "b.bloom")})},
+	} {
+		viper.Set("filters", configs)
+		report = ReloadReport{}
+		reloadFilters(&report)
+		if len(report.Errors) != 1 || slices.Contains(report.Created, "c") {
+			t.Errorf("%s: errors = %v, created = %v", name, report.Errors, report.Created)
+		}
+		waitCreated()
+	}
+}
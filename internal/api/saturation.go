@@ -0,0 +1,222 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/viper"
+
+	"bloom-du/internal/bloom"
+)
+
+// saturationLevel уровень насыщения фильтра, значение метрики saturation_state.
+type saturationLevel int
+
+const (
+	saturationOK saturationLevel = iota
+	saturationWarning
+	saturationCritical
+)
+
+func (l saturationLevel) String() string {
+	switch l {
+	case saturationWarning:
+		return "warning"
+	case saturationCritical:
+		return "critical"
+	default:
+		return "ok"
+	}
+}
+
+// Thresholds пороги насыщения, 0 - порог выключен.
+type Thresholds struct {
+	FillRatio float64 `mapstructure:"fill_ratio" json:"fill_ratio,omitempty"`
+	FpRate    float64 `mapstructure:"fp_rate" json:"fp_rate,omitempty"`
+}
+
+// SaturationConfig пороги warning и critical для заполненности и оценки FP rate.
+// Задаются в секции saturation для всех фильтров и переопределяются в описании фильтра.
+type SaturationConfig struct {
+	Warning  Thresholds `mapstructure:"warning" json:"warning"`
+	Critical Thresholds `mapstructure:"critical" json:"critical"`
+}
+
+// SaturationEvent тело запроса к saturation_webhook при смене уровня насыщения.
+type SaturationEvent struct {
+	Filter        string    `json:"filter"`
+	State         string    `json:"state"`
+	PreviousState string    `json:"previous_state"`
+	FillRatio     float64   `json:"fill_ratio"`
+	FpRate        float64   `json:"fp_rate"`
+	Reasons       []string  `json:"reasons"`
+	Time          time.Time `json:"time"`
+}
+
+type saturationStatus struct {
+	level   saturationLevel
+	reasons []string
+}
+
+var (
+	saturationMux    sync.Mutex
+	saturationStates = map[string]saturationStatus{}
+)
+
+// saturationDefaults пороги из общей секции saturation.
+func saturationDefaults() (SaturationConfig, error) {
+	var cfg SaturationConfig
+	if err := viper.UnmarshalKey("saturation", &cfg); err != nil {
+		return cfg, fmt.Errorf("saturation: %w", err)
+	}
+	return cfg, nil
+}
+
+// withDefaults дополняет незаданные пороги фильтра общими.
+func (c SaturationConfig) withDefaults(defaults SaturationConfig) SaturationConfig {
+	c.Warning = c.Warning.withDefaults(defaults.Warning)
+	c.Critical = c.Critical.withDefaults(defaults.Critical)
+	return c
+}
+
+func (t Thresholds) withDefaults(defaults Thresholds) Thresholds {
+	if t.FillRatio == 0 {
+		t.FillRatio = defaults.FillRatio
+	}
+	if t.FpRate == 0 {
+		t.FpRate = defaults.FpRate
+	}
+	return t
+}
+
+func (c SaturationConfig) evaluate(stats bloom.Stats) (saturationLevel, []string) {
+	if reasons := c.Critical.exceeded(stats); len(reasons) > 0 {
+		return saturationCritical, reasons
+	}
+	if reasons := c.Warning.exceeded(stats); len(reasons) > 0 {
+		return saturationWarning, reasons
+	}
+	return saturationOK, nil
+}
+
+func (t Thresholds) exceeded(stats bloom.Stats) []string {
+	var reasons []string
+	if t.FillRatio > 0 && stats.FillRatio >= t.FillRatio {
+		reasons = append(reasons, fmt.Sprintf("fill_ratio %.4g >= %g", stats.FillRatio, t.FillRatio))
+	}
+	if t.FpRate > 0 && stats.FpRate >= t.FpRate {
+		reasons = append(reasons, fmt.Sprintf("fp_rate %.4g >= %g", stats.FpRate, t.FpRate))
+	}
+	return reasons
+}
+
+// checkSaturation сравнивает статистику фильтра с порогами. При смене уровня пишет в лог
+// и отправляет событие в saturation_webhook.
+func checkSaturation(name string, cfg SaturationConfig, stats bloom.Stats) {
+	level, reasons := cfg.evaluate(stats)
+	saturationState.WithLabelValues(name).Set(float64(level))
+
+	saturationMux.Lock()
+	previous := saturationStates[name]
+	saturationStates[name] = saturationStatus{level: level, reasons: reasons}
+	saturationMux.Unlock()
+
+	if level == previous.level {
+		return
+	}
+
+	event := SaturationEvent{
+		Filter:        name,
+		State:         level.String(),
+		PreviousState: previous.level.String(),
+		FillRatio:     stats.FillRatio,
+		FpRate:        stats.FpRate,
+		Reasons:       reasons,
+		Time:          time.Now(),
+	}
+
+	logLevel := zerolog.InfoLevel
+	switch level {
+	case saturationWarning:
+		logLevel = zerolog.WarnLevel
+	case saturationCritical:
+		logLevel = zerolog.ErrorLevel
+	}
+	log.WithLevel(logLevel).
+		Str("filter", name).
+		Str("state", event.State).
+		Str("previous_state", event.PreviousState).
+		Strs("reasons", reasons).
+		Msg("[saturation] state changed")
+
+	if url := currentSettings().saturationWebhook; url != "" {
+		go func() {
+			if err := postSaturationEvent(url, event); err != nil {
+				log.Error().Err(err).Str("filter", name).Msg("[saturation] webhook failed")
+			}
+		}()
+	}
+}
+
+func postSaturationEvent(url string, event SaturationEvent) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= http.StatusMultipleChoices {
+		return fmt.Errorf("webhook responded %s", resp.Status)
+	}
+	return nil
+}
+
+// degradedFilters фильтры с превышенными порогами, отсортированные по имени.
+func degradedFilters() []string {
+	saturationMux.Lock()
+	defer saturationMux.Unlock()
+	var degraded []string
+	for name, status := range saturationStates {
+		if status.level != saturationOK {
+			degraded = append(degraded, fmt.Sprintf("%s: %s %v", name, status.level, status.reasons))
+		}
+	}
+	slices.Sort(degraded)
+	return degraded
+}
+
+func saturationOf(name string) string {
+	saturationMux.Lock()
+	defer saturationMux.Unlock()
+	status, ok := saturationStates[name]
+	if !ok {
+		return ""
+	}
+	return status.level.String()
+}
+
+func forgetSaturation(name string) {
+	saturationMux.Lock()
+	defer saturationMux.Unlock()
+	delete(saturationStates, name)
+}
@@ -0,0 +1,66 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"bloom-du/internal/bloom"
+)
+
+func TestCheckSaturationWebhook(t *testing.T) {
+	events := make(chan SaturationEvent, 4)
+	stub := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var event SaturationEvent
+		if err := json.NewDecoder(r.Body).Decode(&event); err != nil {
+			t.Errorf("decode webhook body: %v", err)
+		}
+		events <- event
+	}))
+	defer stub.Close()
+
+	applied.Store(&settings{saturationWebhook: stub.URL})
+	defer applied.Store(nil)
+	defer forgetSaturation("test")
+
+	cfg := SaturationConfig{
+		Warning:  Thresholds{FillRatio: 0.5},
+		Critical: Thresholds{FillRatio: 0.9, FpRate: 0.01},
+	}
+	steps := []struct {
+		stats bloom.Stats
+		state string // пусто - уровень не меняется, события нет
+	}{
+		{bloom.Stats{FillRatio: 0.1}, ""},
+		{bloom.Stats{FillRatio: 0.6}, "warning"},
+		{bloom.Stats{FillRatio: 0.7}, ""},
+		{bloom.Stats{FillRatio: 0.7, FpRate: 0.02}, "critical"},
+		{bloom.Stats{FillRatio: 0.2}, "ok"},
+	}
+
+	for i, step := range steps {
+		checkSaturation("test", cfg, step.stats)
+		if step.state == "" {
+			select {
+			case event := <-events:
+				t.Fatalf("step %d: unexpected event %+v", i, event)
+			case <-time.After(50 * time.Millisecond):
+			}
+			continue
+		}
+
+		select {
+		case event := <-events:
+			if event.Filter != "test" || event.State != step.state {
+				t.Errorf("step %d: event = %+v, want state %s", i, event, step.state)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("step %d: no webhook for state %s", i, step.state)
+		}
+		if degraded := len(degradedFilters()) > 0; degraded != (step.state != "ok") {
+			t.Errorf("step %d: degraded = %v", i, degraded)
+		}
+	}
+}
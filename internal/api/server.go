@@ -1,12 +1,15 @@
 package api
 
 import (
+	"context"
 	"errors"
-	"io"
+	"fmt"
 	"net"
 	"net/http"
 	"net/http/pprof"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
@@ -22,15 +25,32 @@ const (
 	labelQuery         = "type"
 	metricsPath        = "/metrics"
 	healthPath         = "/health"
+	livezPath          = "/livez"
+	readyzPath         = "/readyz"
+	// acceptGrace время, за которое уже принятые соединения успевают прислать запрос:
+	// http.Server.Shutdown закрывает соединения, по которым запрос ещё не прочитан
+	acceptGrace = 100 * time.Millisecond
+	// slowResponseTimeout для ответов, которые считают статистику по всему фильтру или ждут чекпоинт
+	slowResponseTimeout = 5 * time.Minute
 )
 
 var apiHandlersFunc = map[string]http.HandlerFunc{
-	"/api/check":      handleCheck,
-	"/api/fcheck":     handleFastCheck,
-	"/api/add":        handleAdd,
-	"/api/bulk":       handleBulkLoad,
-	"/api/checkpoint": handleCheckpoint,
-	"/health":         healthHandler,
+	"/api/check":        handleCheck,
+	"/api/fcheck":       handleFastCheck,
+	"/api/add":          handleAdd,
+	"/api/bulk":         handleBulkLoad,
+	"/api/checkpoint":   handleCheckpoint,
+	"/api/stats":        handleStats,
+	"/api/incr":         handleIncr,
+	"/api/count":        handleCount,
+	"/api/filters":      handleFilters,
+	"/api/topk":         handleTopK,
+	healthPath:          healthHandler,
+	livezPath:           handleLivez,
+	readyzPath:          handleReadyz,
+	"/admin/reload":     adminAuth(handleReload),
+	"/admin/status":     adminAuth(handleStatus),
+	"/admin/checkpoint": adminAuth(handleAdminCheckpoint),
 }
 
 var (
@@ -45,7 +65,7 @@ var (
 			Namespace: metricsNamespace,
 			Name:      "config_info",
 			Help:      "config",
-		}, []string{"cells", "k", "fpRate", "stablePoint", "build"},
+		}, []string{labelFilter, "engine", "cells", "k", "fpRate", "stablePoint", "build"},
 	)
 	Elements = prometheus.NewCounterVec(
 		prometheus.CounterOpts{
@@ -93,72 +113,122 @@ func (rw *responseWriter) WriteHeader(code int) {
 	rw.ResponseWriter.WriteHeader(code)
 }
 
+// Unwrap нужен http.ResponseController.
+func (rw *responseWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
+
+// allowSlowResponse продлевает WriteTimeout сервера для долгого запроса.
+func allowSlowResponse(w http.ResponseWriter) {
+	_ = http.NewResponseController(w).SetWriteDeadline(time.Now().Add(slowResponseTimeout))
+}
+
+var (
+	serverMux    sync.Mutex
+	httpServer   *http.Server
+	httpListener net.Listener
+	handler      http.Handler
+	handleOnce   sync.Once
+)
+
 // RunHTTPServers Возвращает список серверов, чтобы потом мы могли корректно остановить их по сигналу
 func RunHTTPServers() (*http.Server, error) {
-	httpAddress := viper.GetString("address")
-	httpPort := viper.GetString("port")
+	handleOnce.Do(func() {
+		handler = measureHandler(getMux())
+	})
 
-	mux := getMux()
+	server, listener, err := startHTTPServer(httpAddr())
+	if err != nil {
+		return nil, err
+	}
+
+	serverMux.Lock()
+	httpServer, httpListener = server, listener
+	serverMux.Unlock()
+
+	return server, nil
+}
 
+func httpAddr() string {
+	return net.JoinHostPort(viper.GetString("address"), viper.GetString("port"))
+}
+
+func startHTTPServer(addr string) (*http.Server, net.Listener, error) {
 	server := &http.Server{
-		Addr:         net.JoinHostPort(httpAddress, httpPort),
-		Handler:      measureHandler(mux),
+		Addr:         addr,
+		Handler:      handler,
 		ReadTimeout:  defaultTimeout,
 		WriteTimeout: defaultTimeout,
 		IdleTimeout:  defaultIdleTimeout,
 	}
 
+	listener, err := listen(inheritedHTTP, "tcp", addr)
+	if err != nil {
+		return nil, nil, err
+	}
+
 	go func() {
-		if err := server.ListenAndServe(); err != nil {
-			if !errors.Is(err, http.ErrServerClosed) {
+		if err := server.Serve(listener); err != nil {
+			if !errors.Is(err, http.ErrServerClosed) && !errors.Is(err, net.ErrClosed) {
 				log.Fatal().Msgf("ListenAndServe: %v", err)
 			}
 		}
 	}()
 
-	return server, nil
+	return server, listener, nil
 }
 
-func RunUnixSocket(path string) (net.Listener, error) {
-	listener, err := net.Listen("unix", path)
+// reloadHTTPServer запускает сервер на новом адресе, старый останавливается после обработки текущих запросов.
+func reloadHTTPServer(addr string) (bool, error) {
+	serverMux.Lock()
+	defer serverMux.Unlock()
+	if httpServer != nil && httpServer.Addr == addr {
+		return false, nil
+	}
+
+	server, listener, err := startHTTPServer(addr)
 	if err != nil {
-		return nil, err
+		return false, err
 	}
-	go func() {
-		for {
-			conn, errs := listener.Accept()
-			if errs != nil {
-				log.Fatal().Err(errs).Send()
-				break
-			}
-			go handleSocket(conn)
-		}
-	}()
-	return listener, nil
+	if old := httpServer; old != nil {
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), defaultIdleTimeout)
+			defer cancel()
+			_ = old.Shutdown(ctx)
+		}()
+	}
+	httpServer, httpListener = server, listener
+	return true, nil
 }
 
-// TODO add metrics
-func handleSocket(conn net.Conn) {
-	defer conn.Close()
-	buf := make([]byte, 1024)
-	for {
-		// Читаем данные из соединения.
-		data, err := conn.Read(buf)
-		if err != nil {
-			if !errors.Is(err, io.EOF) {
-				log.Error().Err(err).Send()
-			}
-			return
-		}
+// ShutdownServers перестаёт принимать соединения на всех сокетах и ждёт
+// обработки текущих запросов, но не дольше ctx.
+func ShutdownServers(ctx context.Context) error {
+	serverMux.Lock()
+	server, listener := httpServer, httpListener
+	serverMux.Unlock()
 
-		log.Info().Msg(string(buf[:data]))
+	socketMux.Lock()
+	if socketListener != nil {
+		_ = socketListener.Close()
+	}
+	socketMux.Unlock()
 
-		// Отправляем данные обратно в соединение.
-		_, err = conn.Write(buf[:data])
-		if err != nil {
-			log.Error().Err(err).Send()
+	if listener != nil {
+		_ = listener.Close()
+	}
+	time.Sleep(acceptGrace)
+
+	var errs []error
+	if server != nil {
+		if err := server.Shutdown(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("http: %w", err))
 		}
 	}
+	if err := drainUnixSocket(ctx); err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
 }
 
 func initMetrics() {
@@ -167,6 +237,7 @@ func initMetrics() {
 	prometheus.MustRegister(requestDurationSummary)
 	prometheus.MustRegister(requestDurationHistogram)
 	prometheus.MustRegister(responseCounter)
+	registerFilterMetrics()
 }
 
 func measureHandler(next http.Handler) http.Handler {
@@ -175,7 +246,7 @@ func measureHandler(next http.Handler) http.Handler {
 		path := r.URL.Path
 		writer := &responseWriter{ResponseWriter: w}
 
-		if path == metricsPath || path == healthPath {
+		if path == metricsPath || path == healthPath || path == livezPath || path == readyzPath {
 			next.ServeHTTP(writer, r)
 			return
 		}
@@ -199,9 +270,15 @@ func observe(started time.Time, path string) {
 	requestDurationHistogram.WithLabelValues(path).Observe(duration)
 }
 
+// healthHandler отвечает OK или DEGRADED со списком фильтров, превысивших пороги насыщения.
+// Фильтры при этом продолжают работать, поэтому код ответа 200.
 func healthHandler(w http.ResponseWriter, _ *http.Request) {
-	w.Header().Set("Content-Type", "text/html; charset=utf-8")
-	_, err := w.Write([]byte("OK"))
+	w.Header().Set(ContentType, "text/plain; charset=utf-8")
+	body := "OK"
+	if degraded := degradedFilters(); len(degraded) > 0 {
+		body = "DEGRADED\n" + strings.Join(degraded, "\n")
+	}
+	_, err := w.Write([]byte(body))
 	if err != nil {
 		return
 	}
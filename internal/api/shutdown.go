@@ -0,0 +1,60 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Shutdown останавливает сервис: перестаёт принимать соединения, дожидается текущих запросов
+// (не дольше drainTimeout), блокирует запись, делает финальный чекпоинт (не дольше checkpointTimeout)
+// и удаляет файл unix сокета. Ошибка означает, что какой-то шаг не уложился в таймаут
+// или какой-то фильтр не сохранился.
+func Shutdown(drainTimeout, checkpointTimeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), drainTimeout)
+	defer cancel()
+
+	start := time.Now()
+	drainErr := ShutdownServers(ctx)
+	if drainErr != nil {
+		log.Error().Err(drainErr).Msg("[shutdown] not all requests are finished")
+	} else {
+		log.Info().Str("took", time.Since(start).String()).Msg("[shutdown] listeners are drained")
+	}
+
+	writesBlocked.Store(true)
+
+	checkpointErr := finalCheckpoint(checkpointTimeout)
+	removeSocketFile()
+
+	if checkpointErr != nil {
+		return checkpointErr
+	}
+	return drainErr
+}
+
+func finalCheckpoint(timeout time.Duration) error {
+	if !isReady {
+		// загрузка продолжится с последнего чекпоинта загрузки (.resume)
+		log.Warn().Msg("[shutdown] filters are loading, final checkpoint skipped")
+		return nil
+	}
+	start := time.Now()
+	done := make(chan error, 1)
+	go func() {
+		done <- Checkpoint()
+	}()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			return fmt.Errorf("final checkpoint: %w", err)
+		}
+		log.Info().Str("took", time.Since(start).String()).Msg("[shutdown] final checkpoint done")
+		return nil
+	case <-time.After(timeout):
+		return fmt.Errorf("final checkpoint is not finished in %s", timeout)
+	}
+}
@@ -0,0 +1,47 @@
+package api
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"bloom-du/internal/bloom"
+)
+
+func TestFinalCheckpointError(t *testing.T) {
+	logCh := make(chan bloom.LogEvent)
+	go func() {
+		for range logCh {
+		}
+	}()
+	dir := filepath.Join(t.TempDir(), "dumps")
+	if err := os.Mkdir(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	cfg := FilterConfig{Name: "shutdown-test", CheckpointPath: filepath.Join(dir, "filter.cms")}
+	filter, err := bloom.MakeEngine(bloom.CountMinSketch, nil, false, logCh, cfg.CheckpointPath, bloom.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	registerFilter(cfg, filter)
+	defer unregisterFilter(cfg.Name)
+	defer func(ready bool) { isReady = ready }(isReady)
+
+	isReady = false
+	if err = finalCheckpoint(time.Minute); err != nil {
+		t.Errorf("checkpoint during bootstrap must be skipped, got %v", err)
+	}
+
+	isReady = true
+	filter.Add("value")
+	// дамп некуда записать
+	if err = os.Remove(dir); err != nil {
+		t.Fatal(err)
+	}
+	err = finalCheckpoint(time.Minute)
+	if err == nil || !strings.Contains(err.Error(), cfg.Name) {
+		t.Errorf("failed final checkpoint must name the filter, got %v", err)
+	}
+}
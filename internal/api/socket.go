@@ -0,0 +1,229 @@
+package api
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"bloom-du/internal/bloom"
+)
+
+// Текстовый протокол unix сокета: одна команда на строку `<CMD> <value>\n`.
+// Ответ: `1\n` (элемент, возможно, есть / добавлен), `0\n` (нет / не добавлен) или `ERR <msg>\n`.
+// `USE <filter>` переключает фильтр для последующих команд соединения.
+const (
+	socketCmdCheck = "CHECK"
+	socketCmdAdd   = "ADD"
+	socketCmdUse   = "USE"
+	socketTrue     = "1\n"
+	socketFalse    = "0\n"
+	socketErr      = "ERR "
+)
+
+var (
+	socketMux      sync.Mutex
+	socketListener net.Listener
+	socketPath     string
+
+	// активные соединения, чтобы при остановке дождаться их команд
+	socketConnsMux sync.Mutex
+	socketConns    = map[net.Conn]struct{}{}
+	socketWG       sync.WaitGroup
+	socketClosing  atomic.Bool
+)
+
+func RunUnixSocket(path string) (net.Listener, error) {
+	listener, err := listenUnixSocket(path)
+	if err != nil {
+		return nil, err
+	}
+
+	socketMux.Lock()
+	socketListener, socketPath = listener, path
+	socketMux.Unlock()
+
+	return listener, nil
+}
+
+func listenUnixSocket(path string) (net.Listener, error) {
+	listener, err := listen(inheritedUnix, "unix", path)
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		for {
+			conn, errs := listener.Accept()
+			if errors.Is(errs, net.ErrClosed) {
+				return
+			}
+			if errs != nil {
+				log.Fatal().Err(errs).Send()
+				break
+			}
+			trackSocketConn(conn)
+			go handleSocket(conn)
+		}
+	}()
+	return listener, nil
+}
+
+// reloadUnixSocket открывает сокет по новому пути и закрывает старый.
+func reloadUnixSocket(path string) (bool, error) {
+	socketMux.Lock()
+	defer socketMux.Unlock()
+	if path == socketPath {
+		return false, nil
+	}
+
+	listener, err := listenUnixSocket(path)
+	if err != nil {
+		return false, err
+	}
+	if socketListener != nil {
+		_ = socketListener.Close()
+		_ = os.Remove(socketPath)
+	}
+	socketListener, socketPath = listener, path
+	return true, nil
+}
+
+func trackSocketConn(conn net.Conn) {
+	socketConnsMux.Lock()
+	defer socketConnsMux.Unlock()
+	socketConns[conn] = struct{}{}
+	socketWG.Add(1)
+}
+
+func untrackSocketConn(conn net.Conn) {
+	socketConnsMux.Lock()
+	defer socketConnsMux.Unlock()
+	delete(socketConns, conn)
+	socketWG.Done()
+}
+
+// drainUnixSocket ждёт, пока соединения закончат текущие команды. Соединения, ожидающие
+// новую команду, закрываются сразу, оставшиеся - по истечении ctx.
+func drainUnixSocket(ctx context.Context) error {
+	socketClosing.Store(true)
+
+	socketConnsMux.Lock()
+	for conn := range socketConns {
+		_ = conn.SetReadDeadline(time.Now())
+	}
+	socketConnsMux.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		socketWG.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		socketConnsMux.Lock()
+		for conn := range socketConns {
+			_ = conn.Close()
+		}
+		socketConnsMux.Unlock()
+		return fmt.Errorf("unix socket: %w", ctx.Err())
+	}
+}
+
+// removeSocketFile удаляет файл сокета, если он остался после закрытия.
+func removeSocketFile() {
+	socketMux.Lock()
+	defer socketMux.Unlock()
+	if socketPath == "" {
+		return
+	}
+	if err := os.Remove(socketPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.Error().Err(err).Send()
+	}
+}
+
+func handleSocket(conn net.Conn) {
+	defer untrackSocketConn(conn)
+	defer conn.Close()
+	scanner := bufio.NewScanner(conn)
+	writer := bufio.NewWriter(conn)
+	filterName := ""
+
+	for scanner.Scan() {
+		_, err := writer.WriteString(socketCommand(scanner.Text(), &filterName))
+		if err == nil {
+			err = writer.Flush()
+		}
+		if err != nil {
+			log.Error().Err(err).Send()
+			return
+		}
+		if socketClosing.Load() {
+			return
+		}
+	}
+
+	if err := scanner.Err(); err != nil && !errors.Is(err, io.EOF) && !socketClosing.Load() {
+		log.Error().Err(err).Send()
+	}
+}
+
+func socketCommand(line string, filterName *string) string {
+	cmd, value, _ := strings.Cut(strings.TrimRight(line, "\r"), " ")
+
+	if !isReady {
+		return socketErr + "filter is not ready now, please wait\n"
+	}
+
+	name, filter, err := getFilter(*filterName)
+	if strings.EqualFold(cmd, socketCmdUse) {
+		name, filter, err = getFilter(value)
+		if err == nil {
+			*filterName = value
+		}
+	}
+	if err != nil {
+		return socketErr + err.Error() + "\n"
+	}
+
+	var result bool
+	switch strings.ToUpper(cmd) {
+	case socketCmdUse:
+		return socketTrue
+	case socketCmdCheck:
+		if err = bloom.ValidateValue(filter, value); err != nil {
+			observeRejected(name, err)
+			return socketErr + err.Error() + "\n"
+		}
+		result = filter.Test(value)
+		observeCheck(name, result)
+	case socketCmdAdd:
+		if err = bloom.ValidateValue(filter, value); err != nil {
+			observeRejected(name, err)
+			return socketErr + err.Error() + "\n"
+		}
+		if writesBlocked.Load() {
+			return socketErr + msgWritesBlocked + "\n"
+		}
+		result = filter.TestAndAdd(value)
+		observeAdd(name, result)
+	default:
+		return socketErr + "unknown command: " + cmd + "\n"
+	}
+
+	if result {
+		return socketTrue
+	}
+	return socketFalse
+}
@@ -0,0 +1,188 @@
+package api
+
+import (
+	"os"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"bloom-du/internal/bloom"
+	"bloom-du/internal/build"
+)
+
+const (
+	filterStateReady     = "ready"
+	filterStateBootstrap = "bootstrap"
+)
+
+// CheckpointStatus результат последнего чекпоинта фильтра, который что-то сохранял.
+type CheckpointStatus struct {
+	Time            time.Time `json:"time"`
+	DurationSeconds float64   `json:"duration_seconds"`
+	Success         bool      `json:"success"`
+	Error           string    `json:"error,omitempty"`
+}
+
+// FilterStatus состояние одного фильтра.
+type FilterStatus struct {
+	Name       string            `json:"name"`
+	Default    bool              `json:"default"`
+	State      string            `json:"state"`
+	Engine     string            `json:"engine"`
+	Stats      *bloom.Stats      `json:"stats,omitempty"`
+	Saturation string            `json:"saturation,omitempty"`
+	DumpBytes  uint64            `json:"dump_bytes"`
+	Checkpoint *CheckpointStatus `json:"checkpoint,omitempty"`
+	Bootstrap  *bloom.Progress   `json:"bootstrap,omitempty"`
+}
+
+// StatusReport состояние сервиса для SIGUSR2 и /admin/status.
+type StatusReport struct {
+	Version       string         `json:"version"`
+	Pid           int            `json:"pid"`
+	UptimeSeconds float64        `json:"uptime_seconds"`
+	Ready         bool           `json:"ready"`
+	WritesBlocked bool           `json:"writes_blocked"`
+	Goroutines    int            `json:"goroutines"`
+	HeapBytes     uint64         `json:"heap_bytes"`
+	Filters       []FilterStatus `json:"filters"`
+}
+
+var (
+	startedAt = time.Now()
+
+	checkpointsMux sync.Mutex
+	checkpoints    = map[string]CheckpointStatus{}
+)
+
+func rememberCheckpoint(name string, start time.Time, err error) {
+	status := CheckpointStatus{
+		Time:            start,
+		DurationSeconds: time.Since(start).Seconds(),
+		Success:         err == nil,
+	}
+	if err != nil {
+		status.Error = err.Error()
+	}
+
+	checkpointsMux.Lock()
+	checkpoints[name] = status
+	checkpointsMux.Unlock()
+
+	observeCheckpoint(name, status)
+}
+
+func lastCheckpoint(name string) *CheckpointStatus {
+	checkpointsMux.Lock()
+	defer checkpointsMux.Unlock()
+	status, ok := checkpoints[name]
+	if !ok {
+		return nil
+	}
+	return &status
+}
+
+// Status собирает состояние сервиса и всех фильтров, включая те, что ещё загружаются.
+// Заполненность считается по битовому массиву, для больших фильтров это занимает время.
+func Status() StatusReport {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+
+	report := StatusReport{
+		Version:       build.Version,
+		Pid:           os.Getpid(),
+		UptimeSeconds: time.Since(startedAt).Seconds(),
+		Ready:         isReady,
+		WritesBlocked: writesBlocked.Load(),
+		Goroutines:    runtime.NumGoroutine(),
+		HeapBytes:     mem.HeapAlloc,
+		Filters:       []FilterStatus{},
+	}
+
+	filtersMux.RLock()
+	defaultName := defaultFilter
+	filtersMux.RUnlock()
+
+	names, snapshot := registeredFilters()
+	configs := registeredConfigs()
+	for _, name := range names {
+		filter := snapshot[name]
+		stats := filter.Stats()
+		status := FilterStatus{
+			Name:       name,
+			Default:    name == defaultName,
+			State:      filterStateReady,
+			Engine:     filter.Engine().String(),
+			Stats:      &stats,
+			Saturation: saturationOf(name),
+			DumpBytes:  filter.GetDumpSize(),
+			Checkpoint: lastCheckpoint(name),
+		}
+		if progress, ok := bloom.BootstrapProgress(configs[name].CheckpointPath); ok {
+			status.Bootstrap = &progress
+		}
+		report.Filters = append(report.Filters, status)
+	}
+
+	for _, cfg := range pendingConfigs() {
+		status := FilterStatus{
+			Name:    cfg.Name,
+			Default: cfg.Name == defaultName,
+			State:   filterStateBootstrap,
+			Engine:  cfg.Engine,
+		}
+		if progress, ok := bloom.BootstrapProgress(cfg.CheckpointPath); ok {
+			status.Bootstrap = &progress
+		}
+		report.Filters = append(report.Filters, status)
+	}
+
+	return report
+}
+
+// LogStatus пишет состояние сервиса в лог: одна строка на фильтр.
+func LogStatus() {
+	report := Status()
+	log.Info().
+		Str("version", report.Version).
+		Int("pid", report.Pid).
+		Float64("uptime_seconds", report.UptimeSeconds).
+		Bool("ready", report.Ready).
+		Bool("writes_blocked", report.WritesBlocked).
+		Int("goroutines", report.Goroutines).
+		Uint64("heap_bytes", report.HeapBytes).
+		Int("filters", len(report.Filters)).
+		Msg("[status]")
+
+	for _, filter := range report.Filters {
+		event := log.Info().
+			Str("filter", filter.Name).
+			Bool("default", filter.Default).
+			Str("state", filter.State).
+			Str("engine", filter.Engine)
+		if filter.Stats != nil {
+			event = event.
+				Uint64("cells", filter.Stats.Cells).
+				Uint64("count", filter.Stats.Count).
+				Float64("fill_ratio", filter.Stats.FillRatio).
+				Float64("fp_rate", filter.Stats.FpRate).
+				Interface("distinct", filter.Stats.Distinct).
+				Str("saturation", filter.Saturation).
+				Uint64("dump_bytes", filter.DumpBytes)
+		}
+		if filter.Checkpoint != nil {
+			event = event.
+				Time("checkpoint_time", filter.Checkpoint.Time).
+				Bool("checkpoint_success", filter.Checkpoint.Success)
+		}
+		if filter.Bootstrap != nil {
+			event = event.
+				Int64("bootstrap_scanned", filter.Bootstrap.Scanned).
+				Int64("bootstrap_total", filter.Bootstrap.Total).
+				Bool("bootstrap_done", filter.Bootstrap.Done)
+		}
+		event.Msg("[status] filter")
+	}
+}
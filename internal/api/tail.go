@@ -0,0 +1,301 @@
+package api
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"bloom-du/internal/bloom"
+	"bloom-du/internal/utils"
+)
+
+const (
+	// tailPoll как часто проверяются новые строки, ротация и обрезка файла.
+	tailPoll = 500 * time.Millisecond
+	// tailSuffix позиция в файле tail рядом с дампом фильтра.
+	tailSuffix = ".tail"
+	// tailHeadSize сколько первых байт файла запоминается, чтобы после перезапуска узнать тот же файл.
+	tailHeadSize = 64
+
+	tailRotated   = "rotated"
+	tailTruncated = "truncated"
+)
+
+// tailPosition сколько файла уже в фильтре. Head - начало файла: если после перезапуска начало другое,
+// файл заменили (ротация во время остановки), и он читается с начала.
+type tailPosition struct {
+	Path   string `json:"path"`
+	Offset int64  `json:"offset"`
+	Head   []byte `json:"head"`
+}
+
+// fileTailer добавляет в фильтр строки растущего файла, как tail -F: после ротации дочитывает старый файл
+// и переходит к новому, после обрезки читает с начала. Позиция сохраняется после чекпоинта фильтра.
+type fileTailer struct {
+	cfg  FilterConfig
+	stop chan struct{}
+	done chan struct{}
+
+	file   *os.File
+	reader *bufio.Reader
+
+	mux      sync.Mutex
+	position tailPosition
+}
+
+var (
+	tailersMux sync.Mutex
+	tailers    = map[string]*fileTailer{}
+)
+
+// startTail запускает чтение растущего файла фильтра, если он задан.
+func startTail(cfg FilterConfig) {
+	if cfg.Tail == "" {
+		return
+	}
+	t := &fileTailer{
+		cfg:      cfg,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+		position: tailPosition{Path: cfg.Tail},
+	}
+
+	tailersMux.Lock()
+	tailers[cfg.Name] = t
+	tailersMux.Unlock()
+	go t.run()
+	log.Info().Str("filter", cfg.Name).Str("file", cfg.Tail).Msg("[tail] started")
+}
+
+// stopTail останавливает чтение и возвращает сохранение позиции для последнего чекпоинта фильтра.
+// Строки после сохранённой позиции прочитаются снова после перезапуска.
+func stopTail(name string) func() {
+	tailersMux.Lock()
+	t, ok := tailers[name]
+	delete(tailers, name)
+	tailersMux.Unlock()
+	if !ok {
+		return func() {}
+	}
+	close(t.stop)
+	<-t.done
+	return t.snapshot()
+}
+
+// tailCommit снимок позиции до чекпоинта фильтра name: вызывается после удачного чекпоинта.
+func tailCommit(name string) func() {
+	tailersMux.Lock()
+	t, ok := tailers[name]
+	tailersMux.Unlock()
+	if !ok {
+		return func() {}
+	}
+	return t.snapshot()
+}
+
+// snapshot запоминает позицию, возвращённая функция сохраняет её.
+func (t *fileTailer) snapshot() func() {
+	t.mux.Lock()
+	data, err := json.Marshal(t.position)
+	t.mux.Unlock()
+	return func() {
+		if err == nil {
+			err = utils.WriteDump(t.statePath(), bytes.NewReader(data))
+		}
+		if err != nil {
+			log.Error().Err(err).Str("filter", t.cfg.Name).Msg("[tail] position is not saved")
+		}
+	}
+}
+
+func (t *fileTailer) statePath() string {
+	return t.cfg.CheckpointPath + tailSuffix
+}
+
+func (t *fileTailer) run() {
+	defer close(t.done)
+	defer t.close()
+
+	t.restore()
+	tick := time.NewTicker(tailPoll)
+	defer tick.Stop()
+	for {
+		t.poll()
+		select {
+		case <-t.stop:
+			return
+		case <-tick.C:
+		}
+	}
+}
+
+// restore открывает файл с сохранённой позиции, если это тот же файл и он не стал короче.
+func (t *fileTailer) restore() {
+	data, err := os.ReadFile(t.statePath())
+	if err != nil {
+		return
+	}
+	var saved tailPosition
+	if err = json.Unmarshal(data, &saved); err != nil {
+		log.Error().Err(err).Str("filter", t.cfg.Name).Msg("[tail] position is not loaded")
+		return
+	}
+	if saved.Path != t.cfg.Tail || !t.open() {
+		return
+	}
+
+	head, err := t.readHead(int64(len(saved.Head)))
+	stat, statErr := t.file.Stat()
+	if err != nil || statErr != nil || !bytes.Equal(head, saved.Head) || stat.Size() < saved.Offset {
+		log.Info().Str("filter", t.cfg.Name).Str("file", t.cfg.Tail).Msg("[tail] file replaced, reading from start")
+		return
+	}
+	if t.seek(saved.Offset) == nil {
+		t.setOffset(saved.Offset)
+		log.Info().Str("filter", t.cfg.Name).Int64("offset", saved.Offset).Msg("[tail] position restored")
+	}
+}
+
+// poll дочитывает новые строки и проверяет ротацию: путь указывает на другой файл или файл стал короче.
+// Пока запись заблокирована, строки ждут.
+func (t *fileTailer) poll() {
+	if writesBlocked.Load() {
+		return
+	}
+	if t.file == nil && !t.open() {
+		return
+	}
+
+	if stat, err := t.file.Stat(); err == nil && stat.Size() < t.offset() && t.seek(0) == nil {
+		t.setOffset(0)
+		observeTailRotation(t.cfg.Name, tailTruncated)
+		log.Info().Str("filter", t.cfg.Name).Str("file", t.cfg.Tail).Msg("[tail] file truncated, reading from start")
+	}
+	t.readLines()
+
+	current, err := os.Stat(t.cfg.Tail)
+	if err != nil {
+		// старый файл переименован, новый ещё не создан: продолжаем читать старый
+		return
+	}
+	if opened, err := t.file.Stat(); err == nil && !os.SameFile(opened, current) {
+		t.readLines()
+		t.close()
+		if t.open() {
+			observeTailRotation(t.cfg.Name, tailRotated)
+			log.Info().Str("filter", t.cfg.Name).Str("file", t.cfg.Tail).Msg("[tail] file rotated")
+			t.readLines()
+		}
+	}
+}
+
+// readLines добавляет в фильтр целые строки после позиции, недописанная строка ждёт следующего раза.
+func (t *fileTailer) readLines() {
+	name, filter, err := getFilter(t.cfg.Name)
+	if err != nil {
+		return
+	}
+
+	offset := t.offset()
+	added, exists, rejected := 0, 0, 0
+	for {
+		line, err := t.reader.ReadBytes('\n')
+		if err != nil {
+			if !errors.Is(err, io.EOF) {
+				log.Error().Err(err).Str("filter", name).Str("file", t.cfg.Tail).Msg("[tail] read")
+			}
+			// вернуться к началу недописанной строки
+			_ = t.seek(offset)
+			break
+		}
+		offset += int64(len(line))
+		value := bytes.TrimSuffix(bytes.TrimSuffix(line, []byte("\n")), []byte("\r"))
+		if len(value) == 0 {
+			continue
+		}
+		if err = bloom.ValidateValue(filter, string(value)); err != nil {
+			observeRejected(name, err)
+			rejected++
+			continue
+		}
+		if filter.TestAndAdd(string(value)) {
+			added++
+		} else {
+			exists++
+		}
+	}
+	t.setOffset(offset)
+	observeAdds(name, added, exists)
+	observeTail(name, added, exists, rejected)
+}
+
+func (t *fileTailer) open() bool {
+	file, err := os.Open(t.cfg.Tail)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			log.Error().Err(err).Str("filter", t.cfg.Name).Msg("[tail] open")
+		}
+		return false
+	}
+	t.file = file
+	t.reader = bufio.NewReader(file)
+	t.mux.Lock()
+	t.position = tailPosition{Path: t.cfg.Tail}
+	t.mux.Unlock()
+	return true
+}
+
+func (t *fileTailer) close() {
+	if t.file != nil {
+		_ = t.file.Close()
+		t.file, t.reader = nil, nil
+	}
+}
+
+// seek переходит к offset, позицию для сохранения меняет setOffset.
+func (t *fileTailer) seek(offset int64) error {
+	if _, err := t.file.Seek(offset, io.SeekStart); err != nil {
+		log.Error().Err(err).Str("filter", t.cfg.Name).Msg("[tail] seek")
+		return err
+	}
+	t.reader.Reset(t.file)
+	return nil
+}
+
+// setOffset запоминает позицию, пока файл короче tailHeadSize - и его начало.
+func (t *fileTailer) setOffset(offset int64) {
+	var head []byte
+	if t.offset() < tailHeadSize || offset < tailHeadSize {
+		head, _ = t.readHead(min(offset, tailHeadSize))
+	}
+
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	if head != nil || offset == 0 {
+		t.position.Head = head
+	}
+	t.position.Offset = offset
+}
+
+func (t *fileTailer) offset() int64 {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	return t.position.Offset
+}
+
+// readHead первые size байт открытого файла.
+func (t *fileTailer) readHead(size int64) ([]byte, error) {
+	head := make([]byte, size)
+	n, err := t.file.ReadAt(head, 0)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	return head[:n], nil
+}
@@ -0,0 +1,68 @@
+package api
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"bloom-du/internal/bloom"
+)
+
+func TestFileTailerRotation(t *testing.T) {
+	logCh := make(chan bloom.LogEvent)
+	go func() {
+		for range logCh {
+		}
+	}()
+	dir := t.TempDir()
+	path := filepath.Join(dir, "app.log")
+	cfg := FilterConfig{Name: "tail-test", Tail: path, CheckpointPath: filepath.Join(dir, "filter.cms")}
+	filter, err := bloom.MakeEngine(bloom.CountMinSketch, nil, false, logCh, cfg.CheckpointPath, bloom.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	registerFilter(cfg, filter)
+	defer unregisterFilter(cfg.Name)
+	counter, _ := bloom.AsCounter(filter)
+
+	appendLines := func(path, lines string) {
+		t.Helper()
+		file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, _ = file.WriteString(lines)
+		_ = file.Close()
+	}
+	tailer := &fileTailer{cfg: cfg, position: tailPosition{Path: path}}
+	defer tailer.close()
+
+	appendLines(path, "first\nsecond\nthi")
+	tailer.poll()
+	appendLines(path, "rd\n")
+	tailer.poll()
+
+	// ротация: старый файл дочитывается, новый читается с начала
+	if err = os.Rename(path, path+".1"); err != nil {
+		t.Fatal(err)
+	}
+	appendLines(path+".1", "old\n")
+	appendLines(path, "rotated\n")
+	tailer.poll()
+
+	// обрезка: файл читается с начала
+	if err = os.Truncate(path, 0); err != nil {
+		t.Fatal(err)
+	}
+	appendLines(path, "cut\n")
+	tailer.poll()
+
+	for value, want := range map[string]uint64{"first": 1, "third": 1, "thi": 0, "old": 1, "rotated": 1, "cut": 1} {
+		if got := counter.Count(value); got != want {
+			t.Errorf("Count(%s) = %d, want %d", value, got, want)
+		}
+	}
+	if position := tailer.position; position.Offset != int64(len("cut\n")) || string(position.Head) != "cut\n" {
+		t.Errorf("position = %+v", position)
+	}
+}
@@ -0,0 +1,273 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Обновление бинарного файла без простоя: текущий процесс сохраняет фильтры, запускает новый бинарный файл,
+// передавая ему слушающие сокеты (HTTP и unix) как унаследованные файловые дескрипторы, и ждёт,
+// пока новый процесс загрузит дамп и сообщит о готовности через pipe. После этого старый процесс
+// дообрабатывает текущие запросы и завершается.
+const (
+	// envInheritedFDs имена унаследованных дескрипторов через запятую, по порядку начиная с fd 3.
+	envInheritedFDs  = "BLOOM_DU_INHERITED_FDS"
+	firstInheritedFD = 3
+
+	inheritedHTTP  = "http"
+	inheritedUnix  = "unix"
+	inheritedReady = "ready"
+)
+
+var (
+	inheritedMux   sync.Mutex
+	inheritedFiles = map[string]*os.File{}
+	upgraded       bool
+
+	upgradeMux    sync.Mutex
+	writesBlocked atomic.Bool
+)
+
+func init() {
+	names := os.Getenv(envInheritedFDs)
+	if names == "" {
+		return
+	}
+	// следующее обновление передаст свой список
+	_ = os.Unsetenv(envInheritedFDs)
+
+	upgraded = true
+	for i, name := range strings.Split(names, ",") {
+		inheritedFiles[name] = os.NewFile(uintptr(firstInheritedFD+i), name)
+	}
+}
+
+// IsUpgrade true, если процесс запущен через Upgrade предыдущего процесса.
+func IsUpgrade() bool {
+	return upgraded
+}
+
+func takeInherited(name string) *os.File {
+	inheritedMux.Lock()
+	defer inheritedMux.Unlock()
+	file := inheritedFiles[name]
+	delete(inheritedFiles, name)
+	return file
+}
+
+// listen использует унаследованный сокет, если он слушает тот же адрес, иначе открывает новый.
+func listen(name, network, addr string) (net.Listener, error) {
+	file := takeInherited(name)
+	if file == nil {
+		return net.Listen(network, addr)
+	}
+	defer file.Close()
+
+	listener, err := net.FileListener(file)
+	if err != nil {
+		log.Error().Err(err).Str("listener", name).Msg("[upgrade] inherited listener is broken")
+		return net.Listen(network, addr)
+	}
+	if !sameAddr(network, listener.Addr().String(), addr) {
+		log.Warn().Str("inherited", listener.Addr().String()).Str("addr", addr).Msg("[upgrade] address changed")
+		_ = listener.Close()
+		return net.Listen(network, addr)
+	}
+
+	log.Info().Str("listener", name).Str("addr", addr).Msg("[upgrade] inherited listener")
+	return listener, nil
+}
+
+// sameAddr сравнивает адреса с учётом того, что 0.0.0.0 и [::] - один и тот же адрес.
+func sameAddr(network, a, b string) bool {
+	if a == b || network != "tcp" {
+		return a == b
+	}
+	addrA, errA := net.ResolveTCPAddr(network, a)
+	addrB, errB := net.ResolveTCPAddr(network, b)
+	if errA != nil || errB != nil || addrA.Port != addrB.Port {
+		return false
+	}
+	return addrA.IP.Equal(addrB.IP) || addrA.IP.IsUnspecified() && addrB.IP.IsUnspecified()
+}
+
+// NotifyUpgraded сообщает предыдущему процессу, что новый процесс готов принимать запросы.
+func NotifyUpgraded() {
+	ready := takeInherited(inheritedReady)
+	if ready == nil {
+		return
+	}
+	defer ready.Close()
+	if _, err := ready.Write([]byte{1}); err != nil {
+		log.Error().Err(err).Msg("[upgrade] notify parent")
+	}
+}
+
+// Upgrade запускает новый бинарный файл и передаёт ему слушающие сокеты. Запись в фильтры
+// блокируется, пока новый процесс загружается. nil означает, что новый процесс готов
+// и текущий нужно остановить; при ошибке текущий процесс продолжает работу.
+func Upgrade(timeout time.Duration) error {
+	if !upgradeMux.TryLock() {
+		return errors.New("upgrade is already in progress")
+	}
+	defer upgradeMux.Unlock()
+
+	if !isReady {
+		return errors.New("filters are not ready yet")
+	}
+	// создаваемые фильтры не регистрируются и не запускают watch и tail, пока идёт передача
+	reloadMux.Lock()
+	defer reloadMux.Unlock()
+
+	writesBlocked.Store(true)
+	// новый процесс продолжит watch и tail с позиций, сохранённых после этого чекпоинта:
+	// старый больше ничего не читает и не пишет в файлы состояния
+	commits := stopIngest()
+	// новый процесс загрузится из дампов: без них записанное после прошлого чекпоинта пропадёт
+	if err := Checkpoint(); err != nil {
+		resumeIngest()
+		return err
+	}
+	for _, commit := range commits {
+		commit()
+	}
+
+	cmd, ready, err := startUpgraded()
+	if err != nil {
+		resumeIngest()
+		return err
+	}
+	defer ready.Close()
+
+	done := make(chan error, 1)
+	go func() {
+		buf := make([]byte, 1)
+		_, errs := ready.Read(buf)
+		done <- errs
+	}()
+
+	select {
+	case err = <-done:
+		if err != nil {
+			err = fmt.Errorf("new process pid %d exited before ready: %w", cmd.Process.Pid, err)
+		}
+	case <-time.After(timeout):
+		err = fmt.Errorf("new process pid %d is not ready after %s", cmd.Process.Pid, timeout)
+		_ = cmd.Process.Kill()
+	}
+	if err != nil {
+		_ = cmd.Wait()
+		resumeIngest()
+		return err
+	}
+
+	keepSocketFile()
+	log.Info().Int("pid", cmd.Process.Pid).Msg("[upgrade] new process is ready")
+	return nil
+}
+
+// stopIngest останавливает watch и tail всех фильтров и возвращает фиксацию прочитанного,
+// которую нужно выполнить после удачного чекпоинта.
+func stopIngest() []func() {
+	var commits []func()
+	for name := range registeredConfigs() {
+		commits = append(commits, stopWatch(name), stopTail(name))
+	}
+	return commits
+}
+
+// resumeIngest обновление не удалось: запись разблокируется, watch и tail продолжают с сохранённых позиций.
+func resumeIngest() {
+	for _, cfg := range registeredConfigs() {
+		startWatch(cfg)
+		startTail(cfg)
+	}
+	writesBlocked.Store(false)
+}
+
+func startUpgraded() (*exec.Cmd, *os.File, error) {
+	names, files, err := listenerFiles()
+	defer func() {
+		for _, file := range files {
+			_ = file.Close()
+		}
+	}()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ready, readyW, err := os.Pipe()
+	if err != nil {
+		return nil, nil, err
+	}
+	names = append(names, inheritedReady)
+	files = append(files, readyW)
+
+	executable, err := os.Executable()
+	if err != nil {
+		_ = ready.Close()
+		return nil, nil, err
+	}
+
+	cmd := exec.Command(executable, os.Args[1:]...)
+	cmd.Env = append(os.Environ(), envInheritedFDs+"="+strings.Join(names, ","))
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	cmd.ExtraFiles = files
+	if err = cmd.Start(); err != nil {
+		_ = ready.Close()
+		return nil, nil, err
+	}
+
+	log.Info().Int("pid", cmd.Process.Pid).Str("executable", executable).Msg("[upgrade] new process started")
+	return cmd, ready, nil
+}
+
+type fileListener interface {
+	File() (*os.File, error)
+}
+
+// listenerFiles дубликаты дескрипторов слушающих сокетов для передачи новому процессу.
+func listenerFiles() ([]string, []*os.File, error) {
+	serverMux.Lock()
+	listeners := map[string]net.Listener{inheritedHTTP: httpListener}
+	serverMux.Unlock()
+	socketMux.Lock()
+	listeners[inheritedUnix] = socketListener
+	socketMux.Unlock()
+
+	var (
+		names []string
+		files []*os.File
+	)
+	for _, name := range []string{inheritedHTTP, inheritedUnix} {
+		listener, ok := listeners[name].(fileListener)
+		if !ok {
+			continue
+		}
+		file, err := listener.File()
+		if err != nil {
+			return names, files, fmt.Errorf("%s listener: %w", name, err)
+		}
+		names = append(names, name)
+		files = append(files, file)
+	}
+	return names, files, nil
+}
+
+// keepSocketFile не даёт удалить файл unix сокета при закрытии: им уже пользуется новый процесс.
+func keepSocketFile() {
+	socketMux.Lock()
+	defer socketMux.Unlock()
+	if listener, ok := socketListener.(*net.UnixListener); ok {
+		listener.SetUnlinkOnClose(false)
+	}
+}
@@ -0,0 +1,71 @@
+package api
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"bloom-du/internal/bloom"
+)
+
+// TestStopIngest на время передачи работы новому процессу tail не читает и не пишет позицию.
+func TestStopIngest(t *testing.T) {
+	logCh := make(chan bloom.LogEvent)
+	go func() {
+		for range logCh {
+		}
+	}()
+	dir := t.TempDir()
+	path := filepath.Join(dir, "app.log")
+	if err := os.WriteFile(path, []byte("first\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg := FilterConfig{Name: "upgrade-test", Tail: path, CheckpointPath: filepath.Join(dir, "filter.cms")}
+	filter, err := bloom.MakeEngine(bloom.CountMinSketch, nil, false, logCh, cfg.CheckpointPath, bloom.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	registerFilter(cfg, filter)
+	defer unregisterFilter(cfg.Name)
+	waitAdded := func(value string) {
+		t.Helper()
+		for deadline := time.Now().Add(5 * time.Second); !filter.Test(value); time.Sleep(10 * time.Millisecond) {
+			if time.Now().After(deadline) {
+				t.Fatalf("%s is not added", value)
+			}
+		}
+	}
+
+	startTail(cfg)
+	defer stopTail(cfg.Name)
+	waitAdded("first")
+
+	writesBlocked.Store(true)
+	commits := stopIngest()
+	tailersMux.Lock()
+	_, running := tailers[cfg.Name]
+	tailersMux.Unlock()
+	if running {
+		t.Fatal("tail must be stopped before handoff")
+	}
+	for _, commit := range commits {
+		commit()
+	}
+	if _, err = os.Stat(cfg.CheckpointPath + tailSuffix); err != nil {
+		t.Fatalf("tail position is not saved: %v", err)
+	}
+
+	// обновление не удалось: tail продолжает с сохранённой позиции
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = file.WriteString("second\n")
+	_ = file.Close()
+	resumeIngest()
+	waitAdded("second")
+	if counter, _ := bloom.AsCounter(filter); counter.Count("first") != 1 {
+		t.Errorf("first is read again after resume: %d", counter.Count("first"))
+	}
+}
@@ -0,0 +1,394 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/rs/zerolog/log"
+
+	"bloom-du/internal/bloom"
+	"bloom-du/internal/utils"
+)
+
+const (
+	// watchSettle файл читается, когда его не меняли столько времени: запись выгрузки закончена.
+	watchSettle = 5 * time.Second
+	// watchRescan полный просмотр директории на случай пропущенных событий.
+	watchRescan = time.Minute
+	// watchSuffix состояние прочитанных файлов рядом с дампом фильтра (без watch_processed_dir).
+	watchSuffix = ".watch"
+	// watchFingerprintSize сколько байт в начале файла и перед Offset сравнивается перед дочитыванием.
+	watchFingerprintSize = 64
+
+	watchIngested = "ingested"
+	watchFailed   = "error"
+)
+
+// watchedFile сколько файла уже в фильтре. Файл больше записанного и с тем же отпечатком - дописан,
+// читается с Offset, иначе - перезаписан, читается с начала. Отпечаток - первые байты файла (Head)
+// и байты перед Offset (Last): у выгрузок с одинаковым заголовком CSV различается хотя бы второй.
+type watchedFile struct {
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+	Offset  int64     `json:"offset"`
+	Head    []byte    `json:"head,omitempty"`
+	Last    []byte    `json:"last,omitempty"`
+}
+
+// dirWatcher следит за директорией watch фильтра и добавляет в него новые и дописанные файлы.
+// Прочитанное фиксируется (перенос в watch_processed_dir или запись состояния) только после чекпоинта фильтра,
+// чтобы файл не считался загруженным, если дамп с ним не сохранился.
+type dirWatcher struct {
+	cfg     FilterConfig
+	watcher *fsnotify.Watcher
+	stop    chan struct{}
+	done    chan struct{}
+
+	mux sync.Mutex
+	// files прочитанные файлы, pending - прочитанные целиком и ждущие переноса после чекпоинта
+	files   map[string]watchedFile
+	pending []string
+	// failed файлы с ошибкой чтения: повторно читаются, только когда изменятся
+	failed map[string]watchedFile
+	// dirty файлы с событиями, которые ещё не прочитаны
+	dirty map[string]bool
+}
+
+var (
+	watchersMux sync.Mutex
+	watchers    = map[string]*dirWatcher{}
+)
+
+// startWatch запускает наблюдение за директорией фильтра, если она задана.
+func startWatch(cfg FilterConfig) {
+	if cfg.Watch == "" {
+		return
+	}
+	w, err := newDirWatcher(cfg)
+	if err != nil {
+		log.Error().Err(err).Str("filter", cfg.Name).Msg("[watch] not started")
+		return
+	}
+
+	watchersMux.Lock()
+	watchers[cfg.Name] = w
+	watchersMux.Unlock()
+	go w.run()
+	log.Info().Str("filter", cfg.Name).Str("dir", cfg.Watch).Msg("[watch] started")
+}
+
+// stopWatch останавливает наблюдение и возвращает фиксацию прочитанного для последнего чекпоинта фильтра.
+// Прочитанное, но не зафиксированное, прочитается снова после перезапуска.
+func stopWatch(name string) func() {
+	watchersMux.Lock()
+	w, ok := watchers[name]
+	delete(watchers, name)
+	watchersMux.Unlock()
+	if !ok {
+		return func() {}
+	}
+	close(w.stop)
+	<-w.done
+	return w.snapshot()
+}
+
+// watchCommit снимок прочитанного до чекпоинта фильтра name: вызывается после удачного чекпоинта.
+func watchCommit(name string) func() {
+	watchersMux.Lock()
+	w, ok := watchers[name]
+	watchersMux.Unlock()
+	if !ok {
+		return func() {}
+	}
+	return w.snapshot()
+}
+
+func newDirWatcher(cfg FilterConfig) (*dirWatcher, error) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	if err = watcher.Add(cfg.Watch); err != nil {
+		_ = watcher.Close()
+		return nil, fmt.Errorf("watch %s: %w", cfg.Watch, err)
+	}
+
+	w := &dirWatcher{
+		cfg:     cfg,
+		watcher: watcher,
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+		files:   map[string]watchedFile{},
+		failed:  map[string]watchedFile{},
+		dirty:   map[string]bool{},
+	}
+	if cfg.WatchProcessedDir == "" {
+		if data, err := os.ReadFile(w.statePath()); err == nil {
+			if err = json.Unmarshal(data, &w.files); err != nil {
+				log.Error().Err(err).Str("filter", cfg.Name).Msg("[watch] state is not loaded")
+			}
+		}
+	}
+	return w, nil
+}
+
+func (w *dirWatcher) statePath() string {
+	return w.cfg.CheckpointPath + watchSuffix
+}
+
+func (w *dirWatcher) settle() time.Duration {
+	if w.cfg.WatchSettle > 0 {
+		return w.cfg.WatchSettle
+	}
+	return watchSettle
+}
+
+func (w *dirWatcher) run() {
+	defer close(w.done)
+	defer func() { _ = w.watcher.Close() }()
+
+	tick := time.NewTicker(min(w.settle(), time.Second))
+	defer tick.Stop()
+	rescan := time.NewTicker(watchRescan)
+	defer rescan.Stop()
+
+	w.scan()
+	for {
+		select {
+		case <-w.stop:
+			return
+		case event, ok := <-w.watcher.Events:
+			if !ok {
+				return
+			}
+			if event.Has(fsnotify.Create) || event.Has(fsnotify.Write) {
+				if w.matches(event.Name) {
+					w.dirty[event.Name] = true
+				}
+			}
+		case err, ok := <-w.watcher.Errors:
+			if !ok {
+				return
+			}
+			log.Error().Err(err).Str("filter", w.cfg.Name).Msg("[watch] events error")
+		case <-rescan.C:
+			w.scan()
+		case <-tick.C:
+			w.ingestDirty()
+		}
+	}
+}
+
+// matches обычный файл директории под watch_pattern, скрытые файлы (в процессе записи) пропускаются.
+func (w *dirWatcher) matches(path string) bool {
+	name := filepath.Base(path)
+	if strings.HasPrefix(name, ".") {
+		return false
+	}
+	pattern := w.cfg.WatchPattern
+	if pattern == "" {
+		return true
+	}
+	ok, _ := filepath.Match(pattern, name)
+	return ok
+}
+
+func (w *dirWatcher) scan() {
+	entries, err := os.ReadDir(w.cfg.Watch)
+	if err != nil {
+		log.Error().Err(err).Str("filter", w.cfg.Name).Msg("[watch] scan")
+		return
+	}
+	present := map[string]bool{}
+	for _, entry := range entries {
+		path := filepath.Join(w.cfg.Watch, entry.Name())
+		if entry.Type().IsRegular() && w.matches(path) {
+			w.dirty[path] = true
+			present[path] = true
+		}
+	}
+	// удалённые из директории файлы больше не нужно помнить
+	w.mux.Lock()
+	for path := range w.files {
+		if !present[path] && !slices.Contains(w.pending, path) {
+			delete(w.files, path)
+		}
+	}
+	w.mux.Unlock()
+	w.ingestDirty()
+}
+
+// ingestDirty читает файлы, которые перестали меняться. Пока запись заблокирована, файлы ждут.
+func (w *dirWatcher) ingestDirty() {
+	if writesBlocked.Load() {
+		return
+	}
+	paths := make([]string, 0, len(w.dirty))
+	for path := range w.dirty {
+		paths = append(paths, path)
+	}
+	slices.Sort(paths)
+
+	for _, path := range paths {
+		stat, err := os.Stat(path)
+		if err != nil || !stat.Mode().IsRegular() {
+			// удалён или перенесён
+			delete(w.dirty, path)
+			continue
+		}
+		if time.Since(stat.ModTime()) < w.settle() {
+			continue
+		}
+		delete(w.dirty, path)
+		w.ingest(path, watchedFile{Size: stat.Size(), ModTime: stat.ModTime().UTC()})
+	}
+}
+
+func (w *dirWatcher) ingest(path string, current watchedFile) {
+	w.mux.Lock()
+	previous, seen := w.files[path]
+	failed, isFailed := w.failed[path]
+	pending := slices.Contains(w.pending, path)
+	w.mux.Unlock()
+
+	switch {
+	case pending, isFailed && failed.Size == current.Size && failed.ModTime.Equal(current.ModTime):
+		return
+	case seen && previous.Size == current.Size && previous.ModTime.Equal(current.ModTime):
+		return
+	case seen && current.Size > previous.Size && previous.appended(path):
+		current.Offset = previous.Offset
+	}
+
+	name, filter, err := getFilter(w.cfg.Name)
+	if err != nil {
+		return
+	}
+	start := time.Now()
+	result, err := bloom.IngestFile(filter, path, w.cfg.sourceOptions(), current.Offset)
+	observeWatch(name, result, err)
+	if err != nil {
+		w.mux.Lock()
+		w.failed[path] = current
+		w.mux.Unlock()
+		log.Error().Err(err).Str("filter", name).Str("file", path).Msg("[watch] ingest")
+		return
+	}
+
+	current.Offset = result.Offset
+	current.Head, current.Last = fingerprint(path, current.fingerprintAt())
+	w.mux.Lock()
+	delete(w.failed, path)
+	w.files[path] = current
+	if w.cfg.WatchProcessedDir != "" {
+		w.pending = append(w.pending, path)
+	}
+	w.mux.Unlock()
+	log.Info().
+		Str("filter", name).
+		Str("file", path).
+		Int64("records", result.Records).
+		Int64("added", result.Added).
+		Int64("malformed", result.Malformed).
+		Int64("rejected", result.Rejected).
+		Str("took", time.Since(start).String()).
+		Msg("[watch] file ingested")
+}
+
+// appended файл начинается так же, как прочитанный: он дописан, а не заменён файлом большего размера.
+func (f watchedFile) appended(path string) bool {
+	head, last := fingerprint(path, f.fingerprintAt())
+	return bytes.Equal(head, f.Head) && bytes.Equal(last, f.Last)
+}
+
+// fingerprintAt Offset сжатого файла считается в распакованных байтах, отпечаток берётся в пределах файла.
+func (f watchedFile) fingerprintAt() int64 {
+	return min(f.Offset, f.Size)
+}
+
+// fingerprint первые watchFingerprintSize байт файла и столько же перед offset, nil - файл не прочитан.
+func fingerprint(path string, offset int64) (head, last []byte) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, nil
+	}
+	defer file.Close()
+
+	read := func(from, size int64) []byte {
+		data := make([]byte, size)
+		n, err := file.ReadAt(data, from)
+		if err != nil && !errors.Is(err, io.EOF) {
+			return nil
+		}
+		return data[:n]
+	}
+	size := min(offset, watchFingerprintSize)
+	return read(0, size), read(offset-size, size)
+}
+
+// snapshot запоминает прочитанное до чекпоинта, возвращённая функция фиксирует его после чекпоинта.
+func (w *dirWatcher) snapshot() func() {
+	w.mux.Lock()
+	defer w.mux.Unlock()
+
+	if w.cfg.WatchProcessedDir != "" {
+		pending := slices.Clone(w.pending)
+		return func() { w.moveProcessed(pending) }
+	}
+	data, err := json.Marshal(w.files)
+	return func() {
+		if err == nil {
+			err = utils.WriteDump(w.statePath(), bytes.NewReader(data))
+		}
+		if err != nil {
+			log.Error().Err(err).Str("filter", w.cfg.Name).Msg("[watch] state is not saved")
+		}
+	}
+}
+
+func (w *dirWatcher) moveProcessed(paths []string) {
+	if len(paths) == 0 {
+		return
+	}
+	if err := os.MkdirAll(w.cfg.WatchProcessedDir, 0755); err != nil {
+		log.Error().Err(err).Str("filter", w.cfg.Name).Msg("[watch] processed dir")
+		return
+	}
+
+	for _, path := range paths {
+		err := os.Rename(path, filepath.Join(w.cfg.WatchProcessedDir, filepath.Base(path)))
+		if err != nil {
+			log.Error().Err(err).Str("filter", w.cfg.Name).Str("file", path).Msg("[watch] file is not moved")
+		}
+		w.mux.Lock()
+		w.pending = slices.DeleteFunc(w.pending, func(pending string) bool { return pending == path })
+		if err == nil {
+			delete(w.files, path)
+		}
+		w.mux.Unlock()
+	}
+}
+
+// validateWatch шаблон имени должен разбираться, перенесённые файлы - уходить из директории наблюдения.
+func (cfg FilterConfig) validateWatch() error {
+	if cfg.Watch == "" {
+		return nil
+	}
+	if _, err := filepath.Match(cfg.WatchPattern, ""); err != nil {
+		return fmt.Errorf("watch_pattern: %w", err)
+	}
+	if cfg.WatchProcessedDir != "" && filepath.Clean(cfg.WatchProcessedDir) == filepath.Clean(cfg.Watch) {
+		return fmt.Errorf("watch_processed_dir must differ from watch")
+	}
+	return nil
+}
@@ -0,0 +1,149 @@
+package api
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"bloom-du/internal/bloom"
+)
+
+func TestDirWatcher(t *testing.T) {
+	logCh := make(chan bloom.LogEvent)
+	go func() {
+		for range logCh {
+		}
+	}()
+	dir := t.TempDir()
+	watchDir, processedDir := filepath.Join(dir, "incoming"), filepath.Join(dir, "processed")
+	if err := os.Mkdir(watchDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	cfg := FilterConfig{Name: "watch-test", Watch: watchDir, WatchSettle: time.Hour, CheckpointPath: filepath.Join(dir, "filter.cms")}
+	filter, err := bloom.MakeEngine(bloom.CountMinSketch, nil, false, logCh, cfg.CheckpointPath, bloom.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	registerFilter(cfg, filter)
+	defer unregisterFilter(cfg.Name)
+	counter, _ := bloom.AsCounter(filter)
+
+	// settled файл не менялся дольше watch_settle
+	past := time.Now().Add(-2 * time.Hour)
+	writeFile := func(name, lines string, modTime time.Time) string {
+		t.Helper()
+		path := filepath.Join(watchDir, name)
+		if err := os.WriteFile(path, []byte(lines), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	w, err := newDirWatcher(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.watcher.Close()
+
+	export := writeFile("export.csv", "header\nfirst\n", past)
+	writeFile(".export.tmp", "hidden\n", past)
+	fresh := writeFile("fresh.txt", "fresh\n", time.Now())
+	w.scan()
+	if !filter.Test("first") || filter.Test("hidden") {
+		t.Fatal("settled file must be ingested, hidden file skipped")
+	}
+	if filter.Test("fresh") || !w.dirty[fresh] {
+		t.Fatal("file that is still changing must wait")
+	}
+	writeFile("fresh.txt", "fresh\n", past)
+	w.ingestDirty()
+	if !filter.Test("fresh") {
+		t.Error("file must be ingested once it settles")
+	}
+
+	// дописанный файл читается с места, где остановились
+	writeFile("export.csv", "header\nfirst\nsecond\n", past.Add(time.Minute))
+	w.scan()
+	if !filter.Test("second") || counter.Count("first") != 1 {
+		t.Error("appended file must be read from the saved offset")
+	}
+	// перезаписан файлом большего размера с тем же заголовком - читается с начала
+	writeFile("export.csv", "header\nzero\nthird\nfourth\n", past.Add(2*time.Minute))
+	w.scan()
+	if !filter.Test("zero") || counter.Count("header") != 2 {
+		t.Error("file replaced by a larger one must be read from start")
+	}
+
+	// ошибка чтения: тот же файл повторно не читается, изменённый - читается
+	broken := writeFile("broken.gz", "\x1f\x8b\x08garbage", past)
+	w.scan()
+	if _, failed := w.failed[broken]; !failed {
+		t.Fatal("broken file must be remembered as failed")
+	}
+	writeFile("broken.gz", "fixed\n", past.Add(time.Minute))
+	w.scan()
+	if _, failed := w.failed[broken]; failed || !filter.Test("fixed") {
+		t.Error("changed failed file must be retried")
+	}
+
+	// состояние сохраняется после чекпоинта и загружается новым наблюдателем
+	w.snapshot()()
+	restored, err := newDirWatcher(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer restored.watcher.Close()
+	if restored.files[export].Offset != w.files[export].Offset {
+		t.Errorf("restored offset = %d, want %d", restored.files[export].Offset, w.files[export].Offset)
+	}
+
+	// с watch_processed_dir файл переносится только после чекпоинта
+	cfg.WatchProcessedDir = processedDir
+	moving, err := newDirWatcher(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer moving.watcher.Close()
+	moving.scan()
+	if _, err = os.Stat(export); err != nil {
+		t.Fatal("file must stay until checkpoint")
+	}
+	moving.snapshot()()
+	if _, err = os.Stat(filepath.Join(processedDir, "export.csv")); err != nil {
+		t.Errorf("file must be moved after checkpoint: %v", err)
+	}
+	if len(moving.pending) != 0 || len(moving.files) != 0 {
+		t.Errorf("moved files must be forgotten, pending %v, files %v", moving.pending, moving.files)
+	}
+}
+
+func TestDirWatcherEvents(t *testing.T) {
+	logCh := make(chan bloom.LogEvent)
+	go func() {
+		for range logCh {
+		}
+	}()
+	dir := t.TempDir()
+	cfg := FilterConfig{Name: "watch-events-test", Watch: dir, WatchSettle: 50 * time.Millisecond,
+		CheckpointPath: filepath.Join(t.TempDir(), "filter.cms")}
+	filter, err := bloom.MakeEngine(bloom.CountMinSketch, nil, false, logCh, cfg.CheckpointPath, bloom.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	registerFilter(cfg, filter)
+	defer unregisterFilter(cfg.Name)
+
+	startWatch(cfg)
+	defer stopWatch(cfg.Name)
+	if err = os.WriteFile(filepath.Join(dir, "new.txt"), []byte("created\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for deadline := time.Now().Add(5 * time.Second); !filter.Test("created"); time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("created file is not ingested")
+		}
+	}
+}
@@ -0,0 +1,269 @@
+// Package bench нагрузочное тестирование запущенного сервера bloom-du (команда `bloom-du bench`).
+package bench
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"slices"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"text/tabwriter"
+	"time"
+)
+
+const (
+	DistUniform    = "uniform"
+	DistZipf       = "zipf"
+	DistSequential = "sequential"
+	DistUnique     = "unique"
+
+	zipfS = 1.1
+	zipfV = 1
+)
+
+const (
+	OpCheck = "check"
+	OpAdd   = "add"
+)
+
+var percentiles = []float64{50, 90, 99, 99.9}
+
+type Config struct {
+	Protocol string
+	URL      string
+	Socket   string
+	Timeout  time.Duration
+
+	Concurrency int
+	// Requests общее количество запросов. Игнорируется, если задан Duration.
+	Requests int
+	Duration time.Duration
+
+	// AddRatio доля запросов на добавление (0 - только проверки, 1 - только добавления).
+	AddRatio     float64
+	Distribution string
+	// Keys размер пространства ключей для uniform, zipf и sequential.
+	Keys   uint64
+	Prefix string
+	Seed   uint64
+}
+
+func (c Config) validate() error {
+	if c.Concurrency < 1 {
+		return errors.New("concurrency must be >= 1")
+	}
+	if c.Requests < 1 && c.Duration <= 0 {
+		return errors.New("requests or duration must be set")
+	}
+	if c.AddRatio < 0 || c.AddRatio > 1 {
+		return fmt.Errorf("add ratio must be in [0, 1], got %v", c.AddRatio)
+	}
+	if c.Keys == 0 && c.Distribution != DistUnique {
+		return errors.New("keys must be > 0")
+	}
+	switch c.Distribution {
+	case DistUniform, DistZipf, DistSequential, DistUnique:
+	default:
+		return fmt.Errorf("unknown distribution: `%s`", c.Distribution)
+	}
+	return nil
+}
+
+type OpStats struct {
+	Name      string
+	Count     int
+	Errors    int
+	Positive  int // check: возможно есть, add: добавлено
+	Latencies []time.Duration
+}
+
+func (s *OpStats) Percentile(p float64) time.Duration {
+	if len(s.Latencies) == 0 {
+		return 0
+	}
+	idx := int(float64(len(s.Latencies)-1) * p / 100)
+	return s.Latencies[idx]
+}
+
+func (s *OpStats) Max() time.Duration {
+	if len(s.Latencies) == 0 {
+		return 0
+	}
+	return s.Latencies[len(s.Latencies)-1]
+}
+
+func (s *OpStats) merge(other *OpStats) {
+	s.Count += other.Count
+	s.Errors += other.Errors
+	s.Positive += other.Positive
+	s.Latencies = append(s.Latencies, other.Latencies...)
+}
+
+type Report struct {
+	Config    Config
+	Elapsed   time.Duration
+	Ops       []*OpStats
+	LastError error
+}
+
+func (r *Report) Total() int {
+	total := 0
+	for _, op := range r.Ops {
+		total += op.Count
+	}
+	return total
+}
+
+func (r *Report) RPS() float64 {
+	if r.Elapsed <= 0 {
+		return 0
+	}
+	return float64(r.Total()) / r.Elapsed.Seconds()
+}
+
+func (r *Report) Print(w io.Writer) {
+	target := r.Config.URL
+	if r.Config.Protocol == ProtocolUnix {
+		target = r.Config.Socket
+	}
+	_, _ = fmt.Fprintf(w, "Target:       %s (%s)\n", target, r.Config.Protocol)
+	_, _ = fmt.Fprintf(w, "Concurrency:  %d\n", r.Config.Concurrency)
+	_, _ = fmt.Fprintf(w, "Distribution: %s, keys: %d, add ratio: %v, seed: %d\n", r.Config.Distribution, r.Config.Keys, r.Config.AddRatio, r.Config.Seed)
+	_, _ = fmt.Fprintf(w, "Requests:     %d in %s\n", r.Total(), r.Elapsed.Round(time.Millisecond))
+	_, _ = fmt.Fprintf(w, "RPS:          %.0f\n\n", r.RPS())
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprint(tw, "op\tcount\terrors\tpositive")
+	for _, p := range percentiles {
+		_, _ = fmt.Fprintf(tw, "\tp%s", strconv.FormatFloat(p, 'f', -1, 64))
+	}
+	_, _ = fmt.Fprintln(tw, "\tmax")
+	for _, op := range r.Ops {
+		if op.Count == 0 {
+			continue
+		}
+		_, _ = fmt.Fprintf(tw, "%s\t%d\t%d\t%d", op.Name, op.Count, op.Errors, op.Positive)
+		for _, p := range percentiles {
+			_, _ = fmt.Fprintf(tw, "\t%s", op.Percentile(p))
+		}
+		_, _ = fmt.Fprintf(tw, "\t%s\n", op.Max())
+	}
+	_ = tw.Flush()
+
+	if r.LastError != nil {
+		_, _ = fmt.Fprintf(w, "\nLast error: %v\n", r.LastError)
+	}
+}
+
+// Run запускает Concurrency воркеров, каждый со своим соединением, и собирает статистику.
+func Run(cfg Config) (*Report, error) {
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+
+	clients := make([]Client, 0, cfg.Concurrency)
+	defer func() {
+		for _, client := range clients {
+			_ = client.Close()
+		}
+	}()
+	for i := 0; i < cfg.Concurrency; i++ {
+		client, err := newClient(cfg)
+		if err != nil {
+			return nil, err
+		}
+		clients = append(clients, client)
+	}
+
+	var (
+		issued   atomic.Int64
+		sequence atomic.Uint64
+		deadline time.Time
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		lastErr  error
+	)
+	results := make([][]*OpStats, cfg.Concurrency)
+
+	started := time.Now()
+	if cfg.Duration > 0 {
+		deadline = started.Add(cfg.Duration)
+	}
+
+	for i, client := range clients {
+		wg.Add(1)
+		go func(worker int, client Client) {
+			defer wg.Done()
+			rng := rand.New(rand.NewPCG(cfg.Seed, uint64(worker)))
+			next := keyGenerator(cfg, rng, &sequence)
+			check, add := &OpStats{Name: OpCheck}, &OpStats{Name: OpAdd}
+			results[worker] = []*OpStats{check, add}
+
+			for {
+				if deadline.IsZero() {
+					if issued.Add(1) > int64(cfg.Requests) {
+						return
+					}
+				} else if time.Now().After(deadline) {
+					return
+				}
+
+				value := cfg.Prefix + strconv.FormatUint(next(), 10)
+				op, call := check, client.Check
+				if cfg.AddRatio > 0 && rng.Float64() < cfg.AddRatio {
+					op, call = add, client.Add
+				}
+
+				start := time.Now()
+				ok, err := call(value)
+				op.Latencies = append(op.Latencies, time.Since(start))
+				op.Count++
+				if err != nil {
+					op.Errors++
+					mu.Lock()
+					lastErr = err
+					mu.Unlock()
+					continue
+				}
+				if ok {
+					op.Positive++
+				}
+			}
+		}(i, client)
+	}
+	wg.Wait()
+
+	report := &Report{
+		Config:    cfg,
+		Elapsed:   time.Since(started),
+		Ops:       []*OpStats{{Name: OpCheck}, {Name: OpAdd}},
+		LastError: lastErr,
+	}
+	for _, worker := range results {
+		for i, op := range worker {
+			report.Ops[i].merge(op)
+		}
+	}
+	for _, op := range report.Ops {
+		slices.Sort(op.Latencies)
+	}
+
+	return report, nil
+}
+
+func keyGenerator(cfg Config, rng *rand.Rand, sequence *atomic.Uint64) func() uint64 {
+	switch cfg.Distribution {
+	case DistZipf:
+		zipf := rand.NewZipf(rng, zipfS, zipfV, cfg.Keys-1)
+		return zipf.Uint64
+	case DistSequential:
+		return func() uint64 { return (sequence.Add(1) - 1) % cfg.Keys }
+	case DistUnique:
+		return func() uint64 { return sequence.Add(1) - 1 }
+	default:
+		return func() uint64 { return rng.Uint64N(cfg.Keys) }
+	}
+}
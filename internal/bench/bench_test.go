@@ -0,0 +1,81 @@
+package bench
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRunHTTP(t *testing.T) {
+	t.Parallel()
+	var (
+		mu   sync.Mutex
+		seen = map[string]bool{}
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/fcheck":
+			mu.Lock()
+			ok := seen[r.URL.Query().Get("value")]
+			mu.Unlock()
+			if ok {
+				w.WriteHeader(http.StatusOK)
+			} else {
+				w.WriteHeader(http.StatusNotFound)
+			}
+		case "/api/add":
+			w.WriteHeader(http.StatusCreated)
+		}
+	}))
+	defer server.Close()
+
+	report, err := Run(Config{
+		Protocol:     ProtocolHTTP,
+		URL:          server.URL,
+		Timeout:      time.Second,
+		Concurrency:  3,
+		Requests:     300,
+		AddRatio:     0.5,
+		Distribution: DistUniform,
+		Keys:         100,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if report.Total() != 300 {
+		t.Errorf("Expected 300 requests, got %d", report.Total())
+	}
+	for _, op := range report.Ops {
+		if op.Errors != 0 {
+			t.Errorf("Expected no errors for %s, got %d (%v)", op.Name, op.Errors, report.LastError)
+		}
+	}
+	if report.Ops[1].Positive != report.Ops[1].Count {
+		t.Errorf("Expected all adds to be positive, got %d of %d", report.Ops[1].Positive, report.Ops[1].Count)
+	}
+}
+
+func TestPercentile(t *testing.T) {
+	t.Parallel()
+	op := OpStats{}
+	for i := 1; i <= 100; i++ {
+		op.Latencies = append(op.Latencies, time.Duration(i)*time.Millisecond)
+	}
+
+	if p := op.Percentile(50); p != 50*time.Millisecond {
+		t.Errorf("Expected p50 50ms, got %s", p)
+	}
+	if p := op.Max(); p != 100*time.Millisecond {
+		t.Errorf("Expected max 100ms, got %s", p)
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	t.Parallel()
+	if _, err := Run(Config{Concurrency: 1, Requests: 1, Keys: 1, Distribution: "gauss"}); err == nil {
+		t.Error("Expected error for unknown distribution")
+	}
+}
@@ -0,0 +1,164 @@
+package bench
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	ProtocolHTTP = "http"
+	ProtocolUnix = "unix"
+)
+
+// Client одно соединение с сервером bloom-du. Каждый воркер использует свой клиент.
+type Client interface {
+	Check(value string) (bool, error)
+	Add(value string) (bool, error)
+	Close() error
+}
+
+func newClient(cfg Config) (Client, error) {
+	switch cfg.Protocol {
+	case ProtocolHTTP:
+		return newHTTPClient(cfg.URL, cfg.Timeout), nil
+	case ProtocolUnix:
+		return newUnixClient(cfg.Socket, cfg.Timeout)
+	default:
+		return nil, fmt.Errorf("unknown protocol: `%s`", cfg.Protocol)
+	}
+}
+
+type httpClient struct {
+	client  *http.Client
+	baseURL string
+}
+
+func newHTTPClient(baseURL string, timeout time.Duration) *httpClient {
+	return &httpClient{
+		client: &http.Client{
+			Timeout:   timeout,
+			Transport: &http.Transport{MaxIdleConnsPerHost: 1},
+		},
+		baseURL: strings.TrimRight(baseURL, "/"),
+	}
+}
+
+// Check использует HEAD /api/fcheck, как и `ab` в README.
+func (c *httpClient) Check(value string) (bool, error) {
+	req, err := http.NewRequest(http.MethodHead, c.baseURL+"/api/fcheck?value="+url.QueryEscape(value), nil)
+	if err != nil {
+		return false, err
+	}
+	status, err := c.do(req)
+	if err != nil {
+		return false, err
+	}
+
+	switch status {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusNotFound:
+		return false, nil
+	default:
+		return false, fmt.Errorf("unexpected status: %d", status)
+	}
+}
+
+func (c *httpClient) Add(value string) (bool, error) {
+	body, err := json.Marshal(map[string]string{"value": value})
+	if err != nil {
+		return false, err
+	}
+	req, err := http.NewRequest(http.MethodPost, c.baseURL+"/api/add", bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	status, err := c.do(req)
+	if err != nil {
+		return false, err
+	}
+
+	switch status {
+	case http.StatusCreated:
+		return true, nil
+	case http.StatusNotModified:
+		return false, nil
+	default:
+		return false, fmt.Errorf("unexpected status: %d", status)
+	}
+}
+
+func (c *httpClient) do(req *http.Request) (int, error) {
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	// дочитываем тело, чтобы соединение переиспользовалось (keep-alive)
+	_, _ = io.Copy(io.Discard, resp.Body)
+	_ = resp.Body.Close()
+	return resp.StatusCode, nil
+}
+
+func (c *httpClient) Close() error {
+	c.client.CloseIdleConnections()
+	return nil
+}
+
+type unixClient struct {
+	conn    net.Conn
+	reader  *bufio.Reader
+	timeout time.Duration
+}
+
+func newUnixClient(path string, timeout time.Duration) (*unixClient, error) {
+	conn, err := net.DialTimeout("unix", path, timeout)
+	if err != nil {
+		return nil, err
+	}
+	return &unixClient{conn: conn, reader: bufio.NewReader(conn), timeout: timeout}, nil
+}
+
+func (c *unixClient) Check(value string) (bool, error) {
+	return c.command("CHECK", value)
+}
+
+func (c *unixClient) Add(value string) (bool, error) {
+	return c.command("ADD", value)
+}
+
+func (c *unixClient) command(cmd, value string) (bool, error) {
+	if c.timeout > 0 {
+		_ = c.conn.SetDeadline(time.Now().Add(c.timeout))
+	}
+	if _, err := io.WriteString(c.conn, cmd+" "+value+"\n"); err != nil {
+		return false, err
+	}
+
+	line, err := c.reader.ReadString('\n')
+	if err != nil {
+		return false, err
+	}
+
+	switch line = strings.TrimRight(line, "\n"); line {
+	case "1":
+		return true, nil
+	case "0":
+		return false, nil
+	default:
+		return false, errors.New(strings.TrimPrefix(line, "ERR "))
+	}
+}
+
+func (c *unixClient) Close() error {
+	return c.conn.Close()
+}
@@ -0,0 +1,198 @@
+package bloom
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"strings"
+
+	boom "github.com/tylertreat/BoomFilters"
+
+	"bloom-du/internal/redisbloom"
+)
+
+const (
+	// cuckooBucketEntries количество отпечатков в одной корзине (как в BoomFilters).
+	cuckooBucketEntries = 4
+	// sliceHeaderSize размер заголовка слайса на 64-битной платформе.
+	sliceHeaderSize = 24
+)
+
+var engineNames = map[ProbabilisticEngine]string{
+	ClassicBloom:   "classic",
+	StableBloom:    "stable",
+	CountingBloom:  "counting",
+	CuckooBloom:    "cuckoo",
+	RedisBloom:     "redis",
+	RotatingBloom:  "rotating",
+	CountMinSketch: "countmin",
+}
+
+func (e ProbabilisticEngine) String() string {
+	if name, ok := engineNames[e]; ok {
+		return name
+	}
+	return fmt.Sprintf("unknown(%d)", uint8(e))
+}
+
+// ParseEngine возвращает тип фильтра по его имени (stable, classic, ...).
+func ParseEngine(name string) (ProbabilisticEngine, error) {
+	for engine, engineName := range engineNames {
+		if strings.EqualFold(engineName, name) {
+			return engine, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown engine: `%s`", name)
+}
+
+// Params расчётные параметры фильтра для заданной ёмкости и fpRate.
+type Params struct {
+	Engine   ProbabilisticEngine
+	Capacity uint
+	FpRate   float64
+
+	// M количество бит (classic), ячеек (stable) или корзин (cuckoo).
+	M uint
+	K uint
+	// D бит на ячейку (stable).
+	D uint8
+	// P количество ячеек, уменьшаемых при каждом добавлении (stable).
+	P uint
+	// F длина отпечатка в байтах (cuckoo).
+	F uint
+
+	StablePoint  float64
+	StableFpRate float64
+
+	MemoryBytes uint64
+	// DumpBytes ожидаемый размер чекпоинта, 0 если движок не умеет сохраняться.
+	DumpBytes uint64
+}
+
+// Calculate считает параметры фильтра по тем же формулам, что используются при создании
+// фильтров в BoomFilters. cellBits используется только для stable фильтра.
+func Calculate(engine ProbabilisticEngine, capacity uint, fpRate float64, cellBits uint8) (Params, error) {
+	if capacity == 0 {
+		return Params{}, fmt.Errorf("capacity must be > 0")
+	}
+	if fpRate <= 0 || fpRate >= 1 {
+		return Params{}, fmt.Errorf("fpRate must be in (0, 1), got %v", fpRate)
+	}
+
+	params := Params{Engine: engine, Capacity: capacity, FpRate: fpRate}
+
+	switch engine {
+	// для rotating - одно поколение
+	case ClassicBloom, RotatingBloom:
+		params.M = boom.OptimalM(capacity, fpRate)
+		params.K = boom.OptimalK(fpRate)
+		params.MemoryBytes = bucketsDataSize(params.M, 1)
+		// count, m, k + Buckets
+		params.DumpBytes = 3*uint64(binary.Size(uint64(0))) + bucketsDumpSize(params.M, 1)
+	case StableBloom:
+		if cellBits == 0 || cellBits > 8 {
+			return Params{}, fmt.Errorf("cell bits must be in [1, 8], got %d", cellBits)
+		}
+		params.M = boom.OptimalM(capacity, fpRate)
+		params.D = cellBits
+		params.K = stableK(params.M, fpRate)
+		params.P = optimalStableP(params.M, params.K, cellBits, fpRate)
+		params.StablePoint = stablePoint(params.M, params.K, params.P, cellBits)
+		params.StableFpRate = math.Pow(1-params.StablePoint, float64(params.K))
+		params.MemoryBytes = bucketsDataSize(params.M, cellBits) + uint64(params.K)*uint64(binary.Size(uint64(0)))
+		// m, p, k, max, len(indexBuffer), indexBuffer + Buckets
+		params.DumpBytes = (3+uint64(params.K))*uint64(binary.Size(uint64(0))) +
+			uint64(binary.Size(uint8(0))) + uint64(binary.Size(int64(0))) +
+			bucketsDumpSize(params.M, cellBits)
+	case RedisBloom:
+		bitCount, hashes := redisbloom.Size(uint64(capacity), fpRate)
+		params.M = uint(bitCount)
+		params.K = uint(hashes)
+		params.MemoryBytes = redisbloom.DataSize(bitCount)
+		params.DumpBytes = redisbloom.DumpHeaderSize + params.MemoryBytes
+	case CuckooBloom:
+		params.F = cuckooFingerprintSize(fpRate)
+		params.M = cuckooBuckets(capacity, params.F)
+		params.K = 2
+		// корзины с пустыми слотами + отпечатки (выделяются при добавлении, по 8 байт минимум)
+		params.MemoryBytes = uint64(params.M)*(sliceHeaderSize+cuckooBucketEntries*sliceHeaderSize) +
+			uint64(capacity)*((uint64(params.F)+7)/8*8)
+	case CountingBloom, CountMinSketch:
+		return Params{}, fmt.Errorf("engine `%s` is not supported yet", engine)
+	default:
+		return Params{}, fmt.Errorf("unknown stucture type: `%d`", engine)
+	}
+
+	return params, nil
+}
+
+// bucketsDataSize размер данных boom.Buckets в байтах.
+func bucketsDataSize(count uint, bucketSize uint8) uint64 {
+	return (uint64(count)*uint64(bucketSize) + 7) / 8
+}
+
+// bucketsDumpSize размер boom.Buckets после WriteTo: bucketSize, max, count, len + данные.
+func bucketsDumpSize(count uint, bucketSize uint8) uint64 {
+	return 2*uint64(binary.Size(uint8(0))) + 2*uint64(binary.Size(uint64(0))) + bucketsDataSize(count, bucketSize)
+}
+
+// stableK повторяет расчёт k из boom.NewStableBloomFilter.
+func stableK(m uint, fpRate float64) uint {
+	k := boom.OptimalK(fpRate) / 2
+	if k > m {
+		k = m
+	} else if k == 0 {
+		k = 1
+	}
+	return k
+}
+
+// optimalStableP повторяет неэкспортируемую функцию из BoomFilters.
+func optimalStableP(m, k uint, d uint8, fpRate float64) uint {
+	var (
+		maxValue = math.Pow(2, float64(d)) - 1
+		subDenom = math.Pow(1-math.Pow(fpRate, 1/float64(k)), 1/maxValue)
+		denom    = (1/subDenom - 1) * (1/float64(k) - 1/float64(m))
+	)
+
+	p := uint(1 / denom)
+	if p == 0 {
+		p = 1
+	}
+
+	return p
+}
+
+// stablePoint повторяет boom.StableBloomFilter.StablePoint.
+func stablePoint(m, k, p uint, d uint8) float64 {
+	var (
+		maxValue = float64(uint(1)<<d - 1)
+		subDenom = float64(p) * (1/float64(k) - 1/float64(m))
+		denom    = 1 + 1/subDenom
+	)
+
+	return math.Pow(1/denom, maxValue)
+}
+
+// cuckooFingerprintSize повторяет calculateF из BoomFilters.
+func cuckooFingerprintSize(fpRate float64) uint {
+	f := uint(math.Ceil(math.Log(2*float64(cuckooBucketEntries)/fpRate))) / 8
+	if f == 0 {
+		f = 1
+	}
+	return f
+}
+
+// cuckooBuckets повторяет расчёт количества корзин из boom.NewCuckooFilter.
+func cuckooBuckets(capacity, fingerprintSize uint) uint {
+	x := capacity / fingerprintSize * 8
+	x--
+	x |= x >> 1
+	x |= x >> 2
+	x |= x >> 4
+	x |= x >> 8
+	x |= x >> 16
+	x |= x >> 32
+	x++
+	return x
+}
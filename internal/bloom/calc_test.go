@@ -0,0 +1,93 @@
+package bloom
+
+import (
+	"bytes"
+	"testing"
+
+	boom "github.com/tylertreat/BoomFilters"
+)
+
+func TestCalculateClassic(t *testing.T) {
+	t.Parallel()
+	params, err := Calculate(ClassicBloom, 10_000, 0.01, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	filter := boom.NewBloomFilter(10_000, 0.01)
+	if params.M != filter.Capacity() || params.K != filter.K() {
+		t.Errorf("Expected M=%d K=%d, got M=%d K=%d", filter.Capacity(), filter.K(), params.M, params.K)
+	}
+
+	var buf bytes.Buffer
+	_, _ = filter.WriteTo(&buf)
+	if params.DumpBytes != uint64(buf.Len()) {
+		t.Errorf("Expected dump size %d, got %d", buf.Len(), params.DumpBytes)
+	}
+}
+
+func TestCalculateStable(t *testing.T) {
+	t.Parallel()
+	params, err := Calculate(StableBloom, 10_000, 0.001, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	filter := boom.NewStableBloomFilter(params.M, 3, 0.001)
+	if params.K != filter.K() || params.P != filter.P() {
+		t.Errorf("Expected K=%d P=%d, got K=%d P=%d", filter.K(), filter.P(), params.K, params.P)
+	}
+	if params.StablePoint != filter.StablePoint() || params.StableFpRate != filter.FalsePositiveRate() {
+		t.Errorf("Expected stable point %f, got %f", filter.StablePoint(), params.StablePoint)
+	}
+
+	var buf bytes.Buffer
+	_, _ = filter.WriteTo(&buf)
+	if params.DumpBytes != uint64(buf.Len()) {
+		t.Errorf("Expected dump size %d, got %d", buf.Len(), params.DumpBytes)
+	}
+}
+
+func TestCalculateCuckoo(t *testing.T) {
+	t.Parallel()
+	params, err := Calculate(CuckooBloom, 10_000, 0.001, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	filter := boom.NewCuckooFilter(10_000, 0.001)
+	if params.M != filter.Buckets() {
+		t.Errorf("Expected %d buckets, got %d", filter.Buckets(), params.M)
+	}
+}
+
+func TestParseEngine(t *testing.T) {
+	t.Parallel()
+	engine, err := ParseEngine("Classic")
+	if err != nil || engine != ClassicBloom {
+		t.Errorf("Expected ClassicBloom, got %v (%v)", engine, err)
+	}
+	if _, err = ParseEngine("unknown"); err == nil {
+		t.Error("Expected error for unknown engine")
+	}
+}
+
+// TestCalculateMatchesEngines calc выводит параметры, которые дают движки с теми же capacity и fp_rate.
+func TestCalculateMatchesEngines(t *testing.T) {
+	t.Parallel()
+	opts := Options{Capacity: 10_000, FpRate: 0.001, CellBits: 4}
+	for _, engine := range []ProbabilisticEngine{ClassicBloom, StableBloom, RedisBloom} {
+		params, err := Calculate(engine, opts.Capacity, opts.FpRate, opts.CellBits)
+		if err != nil {
+			t.Fatal(err)
+		}
+		structure, err := makeEngine(engine, nil, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		stats := structure.(interface{ Stats() Stats }).Stats()
+		if stats.Cells != uint64(params.M) || stats.K != uint64(params.K) {
+			t.Errorf("%s: calc M=%d K=%d, engine cells=%d K=%d", engine, params.M, params.K, stats.Cells, stats.K)
+		}
+	}
+}
@@ -0,0 +1,163 @@
+package bloom
+
+import (
+	"fmt"
+	"io"
+)
+
+// Capability то, что фильтр умеет сверх Membership. Список отдаётся в /api/filters.
+type Capability string
+
+const (
+	CapabilityMembership  Capability = "membership"
+	CapabilityCounter     Capability = "counter"
+	CapabilityRemover     Capability = "remover"
+	CapabilityMerger      Capability = "merger"
+	CapabilityStats       Capability = "stats"
+	CapabilitySnapshot    Capability = "snapshot"
+	CapabilityCardinality Capability = "cardinality"
+	CapabilityTopK        Capability = "topk"
+)
+
+// Counter движок, который считает частоту значений (countmin).
+type Counter interface {
+	// Incr увеличивает счётчик на delta и возвращает новую оценку.
+	Incr(value string, delta uint64) uint64
+	Count(value string) uint64
+}
+
+// Remover движок, из которого можно удалить значение, false - значения не было.
+type Remover interface {
+	Remove(value string) bool
+}
+
+// Merger движок, который объединяется с движком того же типа и параметров.
+type Merger interface {
+	Merge(other Membership) error
+}
+
+// StatsReporter движок, который сам считает свою статистику. Без него в Stats только Engine.
+type StatsReporter interface {
+	Stats() Stats
+}
+
+// Snapshotter движок, который сохраняется в дамп. Без него фильтр живёт только в памяти.
+type Snapshotter interface {
+	io.WriterTo
+	io.ReaderFrom
+}
+
+// Cardinality оценка количества уникальных значений (CardinalityFilter).
+type Cardinality interface {
+	Distinct() uint64
+}
+
+// wrapper фильтр поверх другого фильтра (CardinalityFilter, TopKFilter).
+type wrapper interface {
+	Unwrap() Filter
+}
+
+// as ищет T среди фильтра и фильтров под ним, последним проверяется движок.
+func as[T any](filter Filter) (T, bool) {
+	for {
+		if t, ok := filter.(T); ok {
+			return t, true
+		}
+		if w, ok := filter.(wrapper); ok {
+			filter = w.Unwrap()
+			continue
+		}
+		if p, ok := filter.(*persistentFilter); ok {
+			t, ok := p.structure.(T)
+			return t, ok
+		}
+		var zero T
+		return zero, false
+	}
+}
+
+// structureOf движок фильтра под всеми обёртками.
+func structureOf(filter Filter) Membership {
+	for {
+		switch f := filter.(type) {
+		case wrapper:
+			filter = f.Unwrap()
+		case *persistentFilter:
+			return f.structure
+		default:
+			return nil
+		}
+	}
+}
+
+// engineCapability возможность движка. Обёртки и persistentFilter пересылают её вниз и ведут свой учёт
+// (HyperLogLog, чекпоинт), поэтому возвращается самая внешняя реализация.
+func engineCapability[T any](filter Filter) (T, bool) {
+	if _, ok := structureOf(filter).(T); !ok {
+		var zero T
+		return zero, false
+	}
+	return as[T](filter)
+}
+
+// AsCounter возвращает Counter, если движок фильтра его поддерживает.
+func AsCounter(filter Filter) (Counter, bool) {
+	return engineCapability[Counter](filter)
+}
+
+// AsRemover возвращает Remover, если движок фильтра его поддерживает.
+func AsRemover(filter Filter) (Remover, bool) {
+	return engineCapability[Remover](filter)
+}
+
+// AsCardinality возвращает оценку уникальных значений, если она включена для фильтра.
+func AsCardinality(filter Filter) (Cardinality, bool) {
+	return as[Cardinality](filter)
+}
+
+// AsTopK возвращает топ дублей, если он включён для фильтра.
+func AsTopK(filter Filter) (*TopKFilter, bool) {
+	return as[*TopKFilter](filter)
+}
+
+// Merge добавляет в dst всё, что есть в src. Движки должны совпадать и реализовывать Merger.
+func Merge(dst, src Filter) error {
+	merger, ok := structureOf(dst).(Merger)
+	if !ok {
+		return fmt.Errorf("engine `%s` does not support merge", dst.Engine())
+	}
+	p, _ := as[*persistentFilter](dst)
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	if err := merger.Merge(structureOf(src)); err != nil {
+		return err
+	}
+	p.needCheckpoint.Store(true)
+	return nil
+}
+
+// Capabilities возможности фильтра, membership есть у всех.
+func Capabilities(filter Filter) []Capability {
+	structure := structureOf(filter)
+	capabilities := []Capability{CapabilityMembership}
+	add := func(capability Capability, ok bool) {
+		if ok {
+			capabilities = append(capabilities, capability)
+		}
+	}
+	_, ok := structure.(Counter)
+	add(CapabilityCounter, ok)
+	_, ok = structure.(Remover)
+	add(CapabilityRemover, ok)
+	_, ok = structure.(Merger)
+	add(CapabilityMerger, ok)
+	_, ok = structure.(StatsReporter)
+	add(CapabilityStats, ok)
+	_, ok = structure.(Snapshotter)
+	add(CapabilitySnapshot, ok)
+	_, ok = AsCardinality(filter)
+	add(CapabilityCardinality, ok)
+	_, ok = AsTopK(filter)
+	add(CapabilityTopK, ok)
+	return capabilities
+}
@@ -0,0 +1,164 @@
+package bloom
+
+import (
+	"errors"
+	"fmt"
+	"hash"
+	"hash/fnv"
+	"io"
+	"sync"
+
+	"github.com/rs/zerolog"
+	boom "github.com/tylertreat/BoomFilters"
+
+	"bloom-du/internal/utils"
+)
+
+const (
+	// cardinalityError стандартная ошибка HyperLogLog: 16384 регистра, 16 КБ.
+	cardinalityError  = 0.01
+	cardinalitySuffix = ".hll"
+)
+
+// CardinalityFilter HyperLogLog поверх фильтра любого движка: считает уникальные значения,
+// прошедшие через Add и TestAndAdd, включая загрузку из источника. Сохраняется рядом с дампом фильтра.
+type CardinalityFilter struct {
+	Filter
+	hll            *boom.HyperLogLog
+	mux            sync.Mutex
+	dumpFilepath   string
+	needCheckpoint bool
+}
+
+// withCardinality загружает HyperLogLog из дампа рядом с дампом фильтра. Строки источника приходят
+// в observeSource при загрузке фильтра. Если HyperLogLog включили для уже существующего дампа, счёт начинается с нуля.
+func withCardinality(filter Filter, checkpointPath string) *CardinalityFilter {
+	hll, _ := boom.NewDefaultHyperLogLog(cardinalityError)
+	hll.SetHash(mixedHash32{fnv.New32a()})
+	f := &CardinalityFilter{
+		Filter:       filter,
+		hll:          hll,
+		dumpFilepath: checkpointPath + cardinalitySuffix,
+	}
+
+	if fileExists(f.dumpFilepath) {
+		if err := readDump(f.dumpFilepath, hllDump{f.hll}); err != nil {
+			f.LogCh() <- LogEvent{Level: zerolog.ErrorLevel, Name: bootstrapName, Msg: fmt.Sprintf("Load HyperLogLog err: %v", err)}
+		}
+	}
+	return f
+}
+
+// observeSource повторное добавление не меняет HyperLogLog, поэтому источник можно читать и поверх дампа (--force).
+func (f *CardinalityFilter) observeSource(line []byte) {
+	f.mux.Lock()
+	f.hll.Add(line)
+	f.needCheckpoint = true
+	f.mux.Unlock()
+}
+
+func (f *CardinalityFilter) Unwrap() Filter {
+	return f.Filter
+}
+
+func (f *CardinalityFilter) observe(value string) {
+	f.mux.Lock()
+	f.hll.Add([]byte(value))
+	f.needCheckpoint = true
+	f.mux.Unlock()
+}
+
+func (f *CardinalityFilter) Add(value string) {
+	f.observe(value)
+	f.Filter.Add(value)
+}
+
+func (f *CardinalityFilter) TestAndAdd(value string) bool {
+	f.observe(value)
+	return f.Filter.TestAndAdd(value)
+}
+
+// Distinct оценка количества уникальных значений.
+func (f *CardinalityFilter) Distinct() uint64 {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	return f.hll.Count()
+}
+
+func (f *CardinalityFilter) Stats() Stats {
+	stats := f.Filter.Stats()
+	distinct := f.Distinct()
+	stats.Distinct = &distinct
+	return stats
+}
+
+func (f *CardinalityFilter) GetDumpSize() uint64 {
+	return f.Filter.GetDumpSize() + getDumpSize(f.dumpFilepath)
+}
+
+// Checkpoint сохраняет фильтр, затем HyperLogLog.
+func (f *CardinalityFilter) Checkpoint() (bool, error) {
+	saved, err := f.Filter.Checkpoint()
+	hllSaved, hllErr := f.checkpointHLL()
+	if hllErr != nil {
+		return saved, errors.Join(err, hllErr)
+	}
+	return saved || hllSaved, err
+}
+
+// checkpointHLL сохраняет только HyperLogLog: при чекпоинте фильтра и во время загрузки источника.
+func (f *CardinalityFilter) checkpointHLL() (bool, error) {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	if !f.needCheckpoint {
+		return false, nil
+	}
+	if err := utils.WriteDump(f.dumpFilepath, hllDump{f.hll}); err != nil {
+		return false, fmt.Errorf("hyperloglog: %w", err)
+	}
+	f.needCheckpoint = false
+	return true, nil
+}
+
+// hllDump Snapshotter для writeDump и readDump.
+type hllDump struct {
+	hll *boom.HyperLogLog
+}
+
+func (d hllDump) WriteTo(stream io.Writer) (int64, error) {
+	n, err := d.hll.WriteDataTo(stream)
+	return int64(n), err
+}
+
+func (d hllDump) ReadFrom(stream io.Reader) (int64, error) {
+	n, err := d.hll.ReadDataFrom(stream)
+	return int64(n), err
+}
+
+// mixedHash32 FNV-1a с финализатором murmur3: старшие биты FNV на коротких похожих строках
+// почти не меняются, а HyperLogLog выбирает по ним регистр (с fnv.New32 по умолчанию оценка занижена в разы).
+type mixedHash32 struct {
+	hash.Hash32
+}
+
+func (h mixedHash32) Sum32() uint32 {
+	sum := h.Hash32.Sum32()
+	sum ^= sum >> 16
+	sum *= 0x85ebca6b
+	sum ^= sum >> 13
+	sum *= 0xc2b2ae35
+	sum ^= sum >> 16
+	return sum
+}
+
+// Incr Counter поверх внутреннего фильтра, см. AsCounter.
+func (f *CardinalityFilter) Incr(value string, delta uint64) uint64 {
+	f.observe(value)
+	counter, _ := AsCounter(f.Filter)
+	return counter.Incr(value, delta)
+}
+
+func (f *CardinalityFilter) Count(value string) uint64 {
+	counter, _ := AsCounter(f.Filter)
+	return counter.Count(value)
+}
@@ -0,0 +1,58 @@
+package bloom
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCardinalityFilter(t *testing.T) {
+	t.Parallel()
+
+	logCh := make(chan LogEvent)
+	go func() {
+		for range logCh {
+		}
+	}()
+	dir := t.TempDir()
+	source := filepath.Join(dir, "source.txt")
+	checkpointPath := filepath.Join(dir, "filter.bloom")
+
+	var lines []byte
+	for i := 0; i < 10_000; i++ {
+		lines = fmt.Appendf(lines, "value_%d\n", i%5_000)
+	}
+	if err := os.WriteFile(source, lines, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	opts := Options{Window: 3600e9, Generations: 1, Capacity: 100_000, Cardinality: true}
+	filter, err := MakeEngine(RotatingBloom, []string{source}, false, logCh, checkpointPath, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 1_000; i++ {
+		filter.TestAndAdd(fmt.Sprintf("api_%d", i))
+	}
+
+	assertDistinct := func(name string, stats Stats) {
+		t.Helper()
+		if stats.Distinct == nil {
+			t.Fatalf("%s: Distinct is not set", name)
+		}
+		if got := float64(*stats.Distinct); got < 5_700 || got > 6_300 {
+			t.Errorf("%s: Distinct = %v, want ~6000", name, got)
+		}
+	}
+	assertDistinct("filter", filter.Stats())
+
+	if saved, err := filter.Checkpoint(); !saved || err != nil {
+		t.Fatalf("Checkpoint() = %v, %v", saved, err)
+	}
+	restored, err := MakeEngine(RotatingBloom, []string{source}, false, logCh, checkpointPath, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertDistinct("restored", restored.Stats())
+}
@@ -0,0 +1,252 @@
+package bloom
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"hash/fnv"
+	"io"
+	"math"
+	"sync"
+
+	boom "github.com/tylertreat/BoomFilters"
+
+	"bloom-du/internal/utils"
+)
+
+const (
+	// countMinEpsilon ошибка частоты не больше epsilon * (всего добавлений): ширина 271 829.
+	countMinEpsilon = 0.00001
+	// countMinDelta вероятность, что ошибка больше: глубина 7, итого ~15 МБ.
+	countMinDelta = 0.001
+	// countMinHeaderSize epsilon, delta, count перед матрицей в WriteDataTo.
+	countMinHeaderSize = 3 * 8
+)
+
+// CountMinSketchFilter частота значений (Count-Min Sketch): "сколько раз видели".
+// Как Membership отвечает на Test по ненулевому счётчику, TestAndAdd увеличивает счётчик на 1.
+type CountMinSketchFilter struct {
+	CMS *boom.CountMinSketch
+	// mux нужен на каждую операцию: CountMinSketch хеширует через общий hash.Hash64
+	mux sync.Mutex
+	// filled бит на каждый ненулевой счётчик матрицы, nonZero - их количество: Stats не сканирует матрицу
+	filled  []uint64
+	nonZero uint64
+	// recount счётчики могли обнулиться (Remove, Merge, загрузка), nonZero пересчитывается по матрице
+	recount bool
+	hash    hash.Hash64
+}
+
+// NewCountMinSketchFilter creating Count-Min Sketch, loading and checkpoint - persistentFilter
+func NewCountMinSketchFilter() *CountMinSketchFilter {
+	return &CountMinSketchFilter{
+		CMS:    boom.NewCountMinSketch(countMinEpsilon, countMinDelta),
+		filled: make([]uint64, (countMinWidth()*countMinDepth()+63)/64),
+		hash:   fnv.New64(),
+	}
+}
+
+// markFilled отмечает счётчики значения ненулевыми. Позиции считаются так же, как в boom.CountMinSketch.Add.
+func (f *CountMinSketchFilter) markFilled(data []byte) {
+	f.hash.Reset()
+	_, _ = f.hash.Write(data)
+	sum := f.hash.Sum64()
+	lower, upper := sum&0xffffffff, sum>>32
+
+	width := countMinWidth()
+	for i := range countMinDepth() {
+		cell := i*width + (lower+upper*i)%width
+		if word, bit := cell/64, uint64(1)<<(cell%64); f.filled[word]&bit == 0 {
+			f.filled[word] |= bit
+			f.nonZero++
+		}
+	}
+}
+
+func (f *CountMinSketchFilter) Engine() ProbabilisticEngine {
+	return CountMinSketch
+}
+
+// Incr увеличивает счётчик значения на delta и возвращает новую оценку частоты.
+func (f *CountMinSketchFilter) Incr(value string, delta uint64) uint64 {
+	data := []byte(value)
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	for range delta {
+		f.CMS.Add(data)
+	}
+	if delta > 0 {
+		f.markFilled(data)
+	}
+	return f.CMS.Count(data)
+}
+
+// Count оценка частоты значения, может быть только больше настоящей.
+func (f *CountMinSketchFilter) Count(value string) uint64 {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	return f.CMS.Count([]byte(value))
+}
+
+// Remove уменьшает счётчик значения на 1.
+func (f *CountMinSketchFilter) Remove(value string) bool {
+	data := []byte(value)
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	removed := f.CMS.TestAndRemove(data, 1)
+	// оценка - минимум счётчиков значения: пока она не ноль, ни один счётчик не обнулился
+	if removed && f.CMS.Count(data) == 0 {
+		f.recount = true
+	}
+	return removed
+}
+
+// Merge складывает счётчики, параметры sketch должны совпадать.
+func (f *CountMinSketchFilter) Merge(other Membership) error {
+	o, ok := other.(*CountMinSketchFilter)
+	if !ok {
+		return fmt.Errorf("can't merge %s into %s", other.Engine(), f.Engine())
+	}
+	if o == f {
+		return nil
+	}
+	o.mux.Lock()
+	defer o.mux.Unlock()
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	f.recount = true
+	return f.CMS.Merge(o.CMS)
+}
+
+func (f *CountMinSketchFilter) Add(data []byte) {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	f.CMS.Add(data)
+	f.markFilled(data)
+}
+
+func (f *CountMinSketchFilter) Test(data []byte) bool {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	return f.CMS.Count(data) > 0
+}
+
+func (f *CountMinSketchFilter) TestAndAdd(data []byte) bool {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	seen := f.CMS.Count(data) > 0
+	f.CMS.Add(data)
+	f.markFilled(data)
+	return !seen
+}
+
+func (f *CountMinSketchFilter) WriteTo(stream io.Writer) (int64, error) {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	n, err := f.CMS.WriteDataTo(stream)
+	return int64(n), err
+}
+
+func (f *CountMinSketchFilter) ReadFrom(stream io.Reader) (int64, error) {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	n, err := f.CMS.ReadDataFrom(stream)
+	f.recount = true
+	return int64(n), err
+}
+
+// Stats заполненность - доля ненулевых счётчиков, Test ошибается, если все depth счётчиков значения не нулевые.
+// Матрица сканируется, только если счётчики могли обнулиться, иначе берётся nonZero.
+func (f *CountMinSketchFilter) Stats() Stats {
+	f.mux.Lock()
+	if f.recount {
+		f.recountFilled()
+	}
+	nonZero, total := f.nonZero, f.CMS.TotalCount()
+	f.mux.Unlock()
+
+	depth := countMinDepth()
+	cells := countMinWidth() * depth
+	fillRatio := float64(nonZero) / float64(cells)
+	return Stats{
+		Engine:       f.Engine().String(),
+		Cells:        cells,
+		K:            depth,
+		Count:        total,
+		FillRatio:    fillRatio,
+		FpRate:       estimatedFpRate(fillRatio, depth),
+		TargetFpRate: countMinDelta,
+		// каждая строка матрицы - отдельный массив с одной хеш-функцией
+		Cardinality: estimatedCardinality(cells/depth, 1, fillRatio),
+		MemoryBytes: cells * 8,
+	}
+}
+
+// recountFilled заново заполняет filled по матрице. Вызывается под mux.
+func (f *CountMinSketchFilter) recountFilled() {
+	clear(f.filled)
+	counter := &wordCounter{skip: countMinHeaderSize, filled: f.filled}
+	_, _ = f.CMS.WriteDataTo(counter)
+	f.nonZero, f.recount = counter.nonZero, false
+}
+
+// countMinDepth глубина матрицы, как в boom.NewCountMinSketch.
+func countMinDepth() uint64 {
+	return uint64(math.Ceil(math.Log(1 / countMinDelta)))
+}
+
+// countMinWidth ширина матрицы, как в boom.NewCountMinSketch.
+func countMinWidth() uint64 {
+	return uint64(math.Ceil(math.E / countMinEpsilon))
+}
+
+func (f *CountMinSketchFilter) String() string {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	return fmt.Sprintf("[Epsilon: %g] [Delta: %g] Total: %s",
+		f.CMS.Epsilon(),
+		f.CMS.Delta(),
+		utils.HumInt(int(f.CMS.TotalCount())),
+	)
+}
+
+// wordCounter считает ненулевые uint64 в потоке после skip байт заголовка и отмечает их в filled.
+type wordCounter struct {
+	skip    int
+	partial []byte
+	words   uint64
+	nonZero uint64
+	filled  []uint64
+}
+
+func (c *wordCounter) Write(p []byte) (int, error) {
+	n := len(p)
+	if c.skip > 0 {
+		skip := min(c.skip, len(p))
+		c.skip -= skip
+		p = p[skip:]
+	}
+	if len(c.partial) > 0 {
+		need := min(8-len(c.partial), len(p))
+		c.partial = append(c.partial, p[:need]...)
+		p = p[need:]
+		if len(c.partial) < 8 {
+			return n, nil
+		}
+		c.count(c.partial)
+		c.partial = c.partial[:0]
+	}
+	for ; len(p) >= 8; p = p[8:] {
+		c.count(p[:8])
+	}
+	c.partial = append(c.partial, p...)
+	return n, nil
+}
+
+func (c *wordCounter) count(word []byte) {
+	if binary.LittleEndian.Uint64(word) != 0 {
+		c.nonZero++
+		c.filled[c.words/64] |= 1 << (c.words % 64)
+	}
+	c.words++
+}
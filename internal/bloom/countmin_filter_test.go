@@ -0,0 +1,98 @@
+package bloom
+
+import (
+	"path/filepath"
+	"slices"
+	"strconv"
+	"testing"
+)
+
+func TestCountMinSketchFilter(t *testing.T) {
+	t.Parallel()
+
+	logCh := make(chan LogEvent, 1000)
+	dumpPath := filepath.Join(t.TempDir(), "phones.cms")
+	filter, err := MakeEngine(CountMinSketch, nil, false, logCh, dumpPath, Options{Cardinality: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	counter, ok := AsCounter(filter)
+	if !ok {
+		t.Fatal("countmin must be a Counter")
+	}
+	if !filter.TestAndAdd("79991110011") || filter.TestAndAdd("79991110011") {
+		t.Fatal("first TestAndAdd must add, second must find")
+	}
+	if got := counter.Incr("79991110011", 3); got != 5 {
+		t.Fatalf("Incr = %d, want 5", got)
+	}
+	if got := counter.Count("79991110022"); got != 0 {
+		t.Fatalf("Count of unseen = %d, want 0", got)
+	}
+
+	if _, err = filter.Checkpoint(); err != nil {
+		t.Fatalf("checkpoint: %v", err)
+	}
+	restored, err := MakeEngine(CountMinSketch, nil, false, logCh, dumpPath, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if counter, _ = AsCounter(restored); counter.Count("79991110011") != 5 {
+		t.Fatalf("restored Count = %d, want 5", counter.Count("79991110011"))
+	}
+
+	other, _ := MakeEngine(CountMinSketch, nil, false, logCh, filepath.Join(t.TempDir(), "other.cms"), Options{})
+	other.Add("79991110011")
+	if err = Merge(restored, other); err != nil {
+		t.Fatalf("merge: %v", err)
+	}
+	if got := counter.Count("79991110011"); got != 6 {
+		t.Fatalf("merged Count = %d, want 6", got)
+	}
+
+	if got := Capabilities(filter); !slices.Equal(got, []Capability{
+		CapabilityMembership, CapabilityCounter, CapabilityRemover, CapabilityMerger,
+		CapabilityStats, CapabilitySnapshot, CapabilityCardinality,
+	}) {
+		t.Errorf("capabilities = %v", got)
+	}
+}
+
+// TestCountMinSketchStats заполненность считается при записи и совпадает с подсчётом по матрице.
+func TestCountMinSketchStats(t *testing.T) {
+	t.Parallel()
+
+	filter := NewCountMinSketchFilter()
+	check := func(step string) {
+		t.Helper()
+		counter := &wordCounter{skip: countMinHeaderSize, filled: make([]uint64, len(filter.filled))}
+		_, _ = filter.CMS.WriteDataTo(counter)
+		want := float64(counter.nonZero) / float64(countMinWidth()*countMinDepth())
+		if got := filter.Stats().FillRatio; got != want || !slices.Equal(filter.filled, counter.filled) {
+			t.Errorf("%s: fill ratio %g, matrix %g", step, got, want)
+		}
+	}
+
+	for i := range 1000 {
+		filter.Add([]byte(strconv.Itoa(i)))
+	}
+	filter.TestAndAdd([]byte("seen"))
+	filter.Incr("hot", 5)
+	check("add")
+	if filter.recount {
+		t.Error("adds must not need a matrix scan")
+	}
+
+	filter.Remove("seen")
+	filter.Remove("hot")
+	check("remove")
+
+	other := NewCountMinSketchFilter()
+	other.Add([]byte("merged"))
+	if err := filter.Merge(other); err != nil {
+		t.Fatal(err)
+	}
+	filter.Add([]byte("after merge"))
+	check("merge")
+}
@@ -0,0 +1,159 @@
+package bloom
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// ingestedSuffix файл рядом с дампом со списком загруженных файлов источника (SourceOptions.SkipIngested).
+const ingestedSuffix = ".sources"
+
+// ExpandSources раскрывает директории (файлы в ней, без вложенных) и glob шаблоны (/data/export-*.txt.gz)
+// в список файлов. Порядок сохраняется, внутри директории и шаблона - по имени. Повторы убираются.
+func ExpandSources(sources []string) ([]string, error) {
+	var files []string
+	for _, source := range sources {
+		var matches []string
+		switch {
+		case source == StdinSource:
+			matches = []string{source}
+		case strings.ContainsAny(source, `*?[`):
+			var err error
+			if matches, err = filepath.Glob(source); err != nil {
+				return nil, fmt.Errorf("source `%s`: %w", source, err)
+			}
+		default:
+			stat, err := os.Stat(source)
+			if err != nil || !stat.IsDir() {
+				// ошибку открытия покажет загрузка файла
+				matches = []string{source}
+				break
+			}
+			entries, err := os.ReadDir(source)
+			if err != nil {
+				return nil, fmt.Errorf("source `%s`: %w", source, err)
+			}
+			for _, entry := range entries {
+				if entry.Type().IsRegular() {
+					matches = append(matches, filepath.Join(source, entry.Name()))
+				}
+			}
+		}
+
+		for _, match := range matches {
+			if !slices.Contains(files, match) {
+				files = append(files, match)
+			}
+		}
+	}
+	return files, nil
+}
+
+// ingestedFile файл источника, прочитанный целиком. Если размер или время изменения другие, файл читается снова.
+type ingestedFile struct {
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+}
+
+// ingestedFiles загруженные файлы по пути. Сохраняется вместе с дампом, чтобы не разойтись с ним.
+type ingestedFiles map[string]ingestedFile
+
+func statIngested(path string) (ingestedFile, bool) {
+	if path == StdinSource {
+		return ingestedFile{}, false
+	}
+	stat, err := os.Stat(path)
+	if err != nil {
+		return ingestedFile{}, false
+	}
+	return ingestedFile{Size: stat.Size(), ModTime: stat.ModTime().UTC()}, true
+}
+
+// unchanged файл path с тех пор, как записали file, не менялся.
+func (file ingestedFile) unchanged(path string) bool {
+	current, ok := statIngested(path)
+	return ok && current.Size == file.Size && current.ModTime.Equal(file.ModTime)
+}
+
+// has файл загружен и с тех пор не менялся.
+func (files ingestedFiles) has(path string) bool {
+	recorded, ok := files[path]
+	return ok && recorded.unchanged(path)
+}
+
+func (files ingestedFiles) WriteTo(stream io.Writer) (int64, error) {
+	data, err := json.Marshal(files)
+	if err != nil {
+		return 0, err
+	}
+	n, err := stream.Write(data)
+	return int64(n), err
+}
+
+func (files ingestedFiles) ReadFrom(stream io.Reader) (int64, error) {
+	data, err := io.ReadAll(stream)
+	if err != nil {
+		return int64(len(data)), err
+	}
+	return int64(len(data)), json.Unmarshal(data, &files)
+}
+
+// IngestResult итог чтения файла в работающий фильтр.
+type IngestResult struct {
+	Records   int64
+	Added     int64
+	Malformed int64
+	// Rejected записи, которые не прошли правила проверки значений фильтра
+	Rejected int64
+	// Offset байт распакованного файла после последней записи: с него продолжается дописанный файл
+	Offset int64
+}
+
+// IngestFile добавляет записи файла, начиная с offset, в работающий фильтр через TestAndAdd,
+// как /api/bulk: HyperLogLog и топ дублей их тоже видят. При ошибке result - сколько успели прочитать.
+func IngestFile(filter Filter, path string, opts SourceOptions, offset int64) (IngestResult, error) {
+	result := IngestResult{Offset: offset}
+	source, err := openSource(path)
+	if err != nil {
+		return result, err
+	}
+	defer source.Close()
+	records, err := newRecordReader(source, opts, offset)
+	if err != nil {
+		return result, err
+	}
+
+	for {
+		key, err := records.Read()
+		if err == io.EOF {
+			return result, nil
+		}
+		var bad *malformedRecord
+		if errors.As(err, &bad) {
+			result.Records++
+			result.Malformed++
+			result.Offset = records.Offset()
+			continue
+		}
+		if err != nil {
+			return result, err
+		}
+
+		result.Records++
+		result.Offset = records.Offset()
+		if ValidateValue(filter, string(key)) != nil {
+			result.Rejected++
+			continue
+		}
+		if filter.TestAndAdd(string(key)) {
+			result.Added++
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package bloom
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestBootstrapSkipIngested(t *testing.T) {
+	t.Parallel()
+
+	logCh := make(chan LogEvent)
+	go func() {
+		for range logCh {
+		}
+	}()
+	dir := t.TempDir()
+	checkpointPath := filepath.Join(t.TempDir(), "filter.bloom")
+	writeSource := func(name string, from, to int) string {
+		t.Helper()
+		var lines []byte
+		for i := from; i < to; i++ {
+			lines = fmt.Appendf(lines, "value_%d\n", i)
+		}
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, lines, 0644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	a, b := writeSource("a.txt", 0, 1_000), writeSource("b.txt", 1_000, 2_000)
+
+	sources := []string{filepath.Join(dir, "*.txt"), a}
+	if files, err := ExpandSources(sources); err != nil || !slices.Equal(files, []string{a, b}) {
+		t.Fatalf("ExpandSources() = %v, %v", files, err)
+	}
+
+	opts := Options{Window: 3600e9, Generations: 1, Capacity: 100_000,
+		Source: SourceOptions{Parallel: 2, SkipIngested: true}}
+	filter, err := MakeEngine(RotatingBloom, sources, false, logCh, checkpointPath, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	progress, _ := BootstrapProgress(checkpointPath)
+	if progress.Added != 2_000 || len(progress.Files) != 2 || !progress.Files[1].Done {
+		t.Fatalf("progress = %+v", progress)
+	}
+	if saved, err := filter.Checkpoint(); !saved || err != nil {
+		t.Fatalf("Checkpoint() = %v, %v", saved, err)
+	}
+
+	c := writeSource("c.txt", 2_000, 2_500)
+	restored, err := MakeEngine(RotatingBloom, sources, false, logCh, checkpointPath, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	progress, _ = BootstrapProgress(checkpointPath)
+	if len(progress.Files) != 1 || progress.Files[0].Source != c || progress.Added != 500 {
+		t.Fatalf("progress after restart = %+v", progress)
+	}
+	for _, value := range []string{"value_10", "value_1500", "value_2100"} {
+		if !restored.Test(value) {
+			t.Errorf("Test(%s) = false after restart", value)
+		}
+	}
+}
+
+func TestIngestFileAppended(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "export.ndjson")
+	if err := os.WriteFile(path, []byte("{\"id\":\"a1\"}\n{\"id\":\"b2\"}\nbroken\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	logCh := make(chan LogEvent)
+	go func() {
+		for range logCh {
+		}
+	}()
+	filter, err := MakeEngine(RotatingBloom, nil, false, logCh, filepath.Join(t.TempDir(), "filter.bloom"),
+		Options{Window: 3600e9, Generations: 1, Capacity: 1_000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := SourceOptions{Format: SourceNDJSON, Key: []string{"id"}}
+
+	result, err := IngestFile(filter, path, opts, 0)
+	if err != nil || result.Records != 3 || result.Added != 2 || result.Malformed != 1 {
+		t.Fatalf("IngestFile() = %+v, %v", result, err)
+	}
+
+	// дописанный файл читается с прошлого Offset
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = file.WriteString("{\"id\":\"a1\"}\n{\"id\":\"c3\"}\n")
+	_ = file.Close()
+	result, err = IngestFile(filter, path, opts, result.Offset)
+	if err != nil || result.Records != 2 || result.Added != 1 {
+		t.Fatalf("IngestFile() after append = %+v, %v", result, err)
+	}
+	if !filter.Test("c3") {
+		t.Error("Test(c3) = false")
+	}
+}
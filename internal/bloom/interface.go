@@ -1,12 +1,8 @@
 package bloom
 
 import (
-	"bytes"
-	"compress/gzip"
 	"fmt"
-	"io"
 	"os"
-	"path/filepath"
 	"time"
 
 	"github.com/rs/zerolog"
@@ -27,20 +23,64 @@ const (
 	StableBloom
 	CountingBloom
 	CuckooBloom
+	// RedisBloom классический фильтр, совместимый с RedisBloom (BF.SCANDUMP / BF.LOADCHUNK).
+	RedisBloom
+	// RotatingBloom поколения classic фильтров за скользящее окно (TTL дедупликация).
+	RotatingBloom
+	// CountMinSketch частота значений, а не только наличие (см. Counter).
+	CountMinSketch
 )
 
 type ProbabilisticEngine uint8
 
+// Membership ядро движка: только структура данных. Загрузку, чекпоинт и логи для всех движков
+// делает persistentFilter, остальное движок объявляет capability интерфейсами (см. capability.go).
+type Membership interface {
+	Engine() ProbabilisticEngine
+	Add(data []byte)
+	Test(data []byte) bool
+	// TestAndAdd true, если значения не было и оно добавлено (в BoomFilters наоборот).
+	TestAndAdd(data []byte) bool
+}
+
+// Filter движок вместе с загрузкой и чекпоинтом, его возвращает MakeEngine.
 type Filter interface {
 	Engine() ProbabilisticEngine
 	Add(value string)
 	Test(value string) bool
 	TestAndAdd(value string) bool
 	GetDumpSize() uint64
-	Checkpoint() bool
+	// Checkpoint сохраняет дамп, false - если сохранять нечего.
+	Checkpoint() (bool, error)
+	Stats() Stats
 	LogCh() chan<- LogEvent
 }
 
+// Options параметры фильтра из конфигурации, нулевые значения - значения по умолчанию движка.
+type Options struct {
+	// Window окно и Generations количество поколений (rotating).
+	Window      time.Duration
+	Generations int
+	// Capacity ёмкость фильтра (classic, redis, stable) или одного поколения (rotating), FpRate желаемый fpRate,
+	// CellBits бит на ячейку (stable). Считаются так же, как в calc, и действуют только для нового фильтра:
+	// при загрузке дампа параметры берутся из него.
+	Capacity uint
+	FpRate   float64
+	CellBits uint8
+	// Cardinality вести HyperLogLog уникальных значений (любой движок).
+	Cardinality bool
+	// TopK размер топа самых частых дублей, 0 - не вести (любой движок).
+	TopK uint
+	// Source формат источника и ключ записи.
+	Source SourceOptions
+	// Normalize шаги нормализации значений из источника и API, пусто - значения как есть.
+	Normalize Normalizer
+	// Validator правила значений источника и API, nil - без проверки.
+	Validator *Validator
+	// Keyer HMAC значений секретным ключом фильтра, nil - значения хранятся как есть.
+	Keyer *Keyer
+}
+
 type LogEvent struct {
 	Level zerolog.Level
 	Name  string
@@ -63,16 +103,56 @@ type LogEvent struct {
 // TODO реализовать классический фильтр и сравнить производительность с redis
 func MakeEngine(
 	name ProbabilisticEngine,
-	source string,
+	sources []string,
 	force bool,
 	logCh chan LogEvent,
 	checkpointPath string,
+	opts Options,
 ) (Filter, error) {
+	structure, err := makeEngine(name, logCh, opts)
+	if err != nil {
+		return nil, err
+	}
+	persistent := newPersistentFilter(structure, sources, opts.Source, logCh, checkpointPath)
+	persistent.normalizer, persistent.validator, persistent.keyer = opts.Normalize, opts.Validator, opts.Keyer
+	if err = persistent.checkDumpKey(); err != nil {
+		return nil, err
+	}
+	var filter Filter = persistent
+	if opts.TopK > 0 {
+		filter = withTopK(filter, opts.TopK, checkpointPath)
+	}
+	if opts.Cardinality {
+		cardinality := withCardinality(filter, checkpointPath)
+		persistent.observeSource(cardinality.observeSource)
+		persistent.onBootstrapCheckpoint(cardinality.checkpointHLL)
+		filter = cardinality
+	}
+	if opts.Keyer != nil {
+		filter = withKeyer(filter, opts.Keyer)
+	}
+	if opts.Validator != nil {
+		filter = withValidator(filter, opts.Validator)
+	}
+	if len(opts.Normalize) > 0 {
+		filter = withNormalizer(filter, opts.Normalize)
+	}
+	persistent.Boostrap(force)
+	return filter, nil
+}
+
+func makeEngine(name ProbabilisticEngine, logCh chan LogEvent, opts Options) (Membership, error) {
 	switch name {
 	case StableBloom:
-		return NewStableBloomFilter(source, force, logCh, checkpointPath), nil
+		return NewStableBloomFilter(opts), nil
 	case ClassicBloom:
-		return NewClassicBloomFilter(source, force, logCh, checkpointPath), nil
+		return NewClassicBloomFilter(opts), nil
+	case RedisBloom:
+		return NewRedisBloomFilter(opts), nil
+	case RotatingBloom:
+		return NewRotatingBloomFilter(logCh, opts), nil
+	case CountMinSketch:
+		return NewCountMinSketchFilter(), nil
 	default:
 		return nil, fmt.Errorf("unknown stucture type: `%d`", name)
 	}
@@ -81,7 +161,10 @@ func MakeEngine(
 func getDumpSize(dumpFilepath string) uint64 {
 	file, err := os.OpenFile(dumpFilepath, os.O_RDONLY, 0644)
 	if err != nil {
-		log.Error().Err(err).Send()
+		// дампа ещё нет, пока не было чекпоинта
+		if !os.IsNotExist(err) {
+			log.Error().Err(err).Send()
+		}
 		return 0
 	}
 	defer file.Close()
@@ -93,75 +176,9 @@ func getDumpSize(dumpFilepath string) uint64 {
 	return uint64(stat.Size())
 }
 
-func getLineCount(sourceFilepath string) int {
-	if isGzSource(sourceFilepath) {
-		return lineCounterGz(sourceFilepath)
-	}
-	return lineCounterPlain(sourceFilepath)
-}
-
-func isGzSource(sourceFilepath string) bool {
-	ext := filepath.Ext(sourceFilepath)
-	return ext == ".gz"
-}
-
-func lineCounterPlain(filePath string) int {
-	count := 0
-	file, err := os.Open(filePath)
-	if err != nil {
-		return 0
-	}
-	defer file.Close()
-
-	buf := make([]byte, 32*1024)
-	lineSep := []byte{'\n'}
-
-	for {
-		c, err := file.Read(buf)
-		if err != nil && err != io.EOF {
-			return count
-		}
-
-		count += bytes.Count(buf[:c], lineSep)
-
-		if err == io.EOF {
-			break
-		}
-	}
-
-	return count
-}
-
-func lineCounterGz(filePath string) int {
-	count := 0
-	file, err := os.Open(filePath)
-	if err != nil {
-		return 0
-	}
-	defer file.Close()
-
-	buf := make([]byte, 32*1024)
-	lineSep := []byte{'\n'}
-
-	gz, err := gzip.NewReader(file)
-	if err != nil {
-		return 0
-	}
-	defer gz.Close()
-
-	for {
-		n, err := gz.Read(buf)
-		if err != nil && err != io.EOF {
-			return count
-		}
-
-		count += bytes.Count(buf[:n], lineSep)
-		if err == io.EOF {
-			break
-		}
-	}
-
-	return count
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
 }
 
 // StopWatchLog todo скорее всего можно выпилить после экспериментов (оставить только HTTP метрики)
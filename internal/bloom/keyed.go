@@ -0,0 +1,172 @@
+package bloom
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"sync"
+)
+
+const (
+	// HMACMinKeySize короче ключ легко подобрать, и смысла в HMAC нет.
+	HMACMinKeySize = 16
+	// keyedMagic начало дампа фильтра с ключом, за ним длина и ID ключа, дальше дамп движка как обычно.
+	keyedMagic = "BDUHMAC1"
+	// keyIDContext из ключа и этой строки получается ID ключа: по нему нельзя восстановить ключ.
+	keyIDContext = "bloom-du key id"
+)
+
+// Keyer HMAC-SHA256 значений с секретным ключом фильтра: по дампу без ключа нельзя проверить,
+// есть ли в фильтре известный номер телефона или email.
+type Keyer struct {
+	id     string
+	hashes sync.Pool
+}
+
+// NewKeyer ключ не меньше HMACMinKeySize байт.
+func NewKeyer(key []byte) (*Keyer, error) {
+	if len(key) < HMACMinKeySize {
+		return nil, fmt.Errorf("hmac key must be at least %d bytes, got %d", HMACMinKeySize, len(key))
+	}
+	key = append([]byte(nil), key...)
+	k := &Keyer{hashes: sync.Pool{New: func() any { return hmac.New(sha256.New, key) }}}
+	k.id = hex.EncodeToString(k.sum([]byte(keyIDContext))[:8])
+	return k, nil
+}
+
+// ID отпечаток ключа, записывается в заголовок дампа.
+func (k *Keyer) ID() string {
+	return k.id
+}
+
+// Sum HMAC значения в hex: его видят HyperLogLog, топ дублей и движок.
+func (k *Keyer) Sum(value string) string {
+	return hex.EncodeToString(k.sum([]byte(value)))
+}
+
+func (k *Keyer) sum(value []byte) []byte {
+	h := k.hashes.Get().(hash.Hash)
+	defer k.hashes.Put(h)
+	h.Reset()
+	h.Write(value)
+	return h.Sum(nil)
+}
+
+// KeyedFilter заменяет значения их HMAC до HyperLogLog, топа дублей и движка.
+// Стоит под ValidatedFilter: правила и нормализация работают с исходным значением.
+type KeyedFilter struct {
+	Filter
+	keyer *Keyer
+}
+
+func withKeyer(filter Filter, keyer *Keyer) *KeyedFilter {
+	return &KeyedFilter{Filter: filter, keyer: keyer}
+}
+
+func (f *KeyedFilter) Unwrap() Filter {
+	return f.Filter
+}
+
+func (f *KeyedFilter) Add(value string) {
+	f.Filter.Add(f.keyer.Sum(value))
+}
+
+func (f *KeyedFilter) Test(value string) bool {
+	return f.Filter.Test(f.keyer.Sum(value))
+}
+
+func (f *KeyedFilter) TestAndAdd(value string) bool {
+	return f.Filter.TestAndAdd(f.keyer.Sum(value))
+}
+
+// Incr Counter поверх внутреннего фильтра, см. AsCounter.
+func (f *KeyedFilter) Incr(value string, delta uint64) uint64 {
+	counter, _ := AsCounter(f.Filter)
+	return counter.Incr(f.keyer.Sum(value), delta)
+}
+
+func (f *KeyedFilter) Count(value string) uint64 {
+	counter, _ := AsCounter(f.Filter)
+	return counter.Count(f.keyer.Sum(value))
+}
+
+// Remove Remover поверх внутреннего фильтра, см. AsRemover.
+func (f *KeyedFilter) Remove(value string) bool {
+	remover, _ := AsRemover(f.Filter)
+	return remover.Remove(f.keyer.Sum(value))
+}
+
+// KeyID ID ключа HMAC фильтра, пусто - значения хранятся без ключа.
+func KeyID(filter Filter) string {
+	if keyed, ok := as[*KeyedFilter](filter); ok {
+		return keyed.keyer.ID()
+	}
+	return ""
+}
+
+// keyedDump дамп движка с заголовком keyedMagic и ID ключа.
+type keyedDump struct {
+	Snapshotter
+	keyID string
+}
+
+func (d keyedDump) WriteTo(stream io.Writer) (int64, error) {
+	header := append([]byte(keyedMagic), byte(len(d.keyID)))
+	header = append(header, d.keyID...)
+	n, err := stream.Write(header)
+	if err != nil {
+		return int64(n), err
+	}
+	written, err := d.Snapshotter.WriteTo(stream)
+	return int64(n) + written, err
+}
+
+func (d keyedDump) ReadFrom(stream io.Reader) (int64, error) {
+	buffered := bufio.NewReader(stream)
+	keyID, n, err := readKeyID(buffered)
+	if err != nil {
+		return n, err
+	}
+	if keyID != d.keyID {
+		return n, fmt.Errorf("dump hmac key id %q, configured key id %q", keyID, d.keyID)
+	}
+	read, err := d.Snapshotter.ReadFrom(buffered)
+	return n + read, err
+}
+
+// readKeyID ID ключа из заголовка дампа, пусто - дамп без ключа (заголовок не прочитан).
+func readKeyID(stream *bufio.Reader) (string, int64, error) {
+	magic, err := stream.Peek(len(keyedMagic))
+	if err != nil || string(magic) != keyedMagic {
+		// короткий дамп без заголовка разберёт движок
+		return "", 0, nil
+	}
+	_, _ = stream.Discard(len(keyedMagic))
+	size, err := stream.ReadByte()
+	if err != nil {
+		return "", int64(len(keyedMagic)), err
+	}
+	keyID := make([]byte, size)
+	n, err := io.ReadFull(stream, keyID)
+	return string(keyID), int64(len(keyedMagic) + 1 + n), err
+}
+
+// dumpKeyID ID ключа, с которым записан дамп, пусто - дамп без ключа.
+func dumpKeyID(dumpFilepath string) (string, error) {
+	file, err := os.Open(dumpFilepath)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	keyID, _, err := readKeyID(bufio.NewReader(file))
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		err = fmt.Errorf("truncated hmac header: %w", err)
+	}
+	return keyID, err
+}
@@ -0,0 +1,80 @@
+package bloom
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestKeyedFilterDump(t *testing.T) {
+	t.Parallel()
+
+	logCh := make(chan LogEvent)
+	go func() {
+		for range logCh {
+		}
+	}()
+	dir := t.TempDir()
+	source := filepath.Join(dir, "source.txt")
+	if err := os.WriteFile(source, []byte("79991110011\n79991110012\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	checkpointPath := filepath.Join(dir, "filter.cms")
+	keyer, err := NewKeyer([]byte("0123456789abcdef-secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := Options{Keyer: keyer, TopK: 10}
+	filter, err := MakeEngine(CountMinSketch, []string{source}, false, logCh, checkpointPath, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if filter.TestAndAdd("79991110011") || KeyID(filter) != keyer.ID() {
+		t.Fatal("source value must be found through the key")
+	}
+	// без ключа значение не находится: в движке только HMAC
+	if structureOf(filter).Test([]byte("79991110011")) {
+		t.Error("plain value must not be stored")
+	}
+	if top, _ := AsTopK(filter); strings.Contains(top.Top(0)[0].Value, "7999") {
+		t.Errorf("top-k must keep HMAC, got %+v", top.Top(0))
+	}
+	if _, err = filter.Checkpoint(); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(checkpointPath); !bytes.HasPrefix(data, []byte(keyedMagic)) {
+		t.Fatal("dump must start with hmac header")
+	}
+
+	// тот же ключ - дамп загружается
+	restored, err := MakeEngine(CountMinSketch, nil, false, logCh, checkpointPath, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if counter, _ := AsCounter(restored); counter.Count("79991110011") != 2 {
+		t.Errorf("Count() after restore = %d, want 2", counter.Count("79991110011"))
+	}
+
+	// другой ключ или без ключа - отказ
+	other, _ := NewKeyer([]byte("fedcba9876543210-secret"))
+	for _, keyer := range []*Keyer{other, nil} {
+		if _, err = MakeEngine(CountMinSketch, nil, false, logCh, checkpointPath, Options{Keyer: keyer}); err == nil {
+			t.Errorf("dump must not load with key %v", keyer)
+		}
+	}
+
+	// дамп без ключа не загружается с ключом
+	plainPath := filepath.Join(dir, "plain.cms")
+	plain, _ := MakeEngine(CountMinSketch, []string{source}, false, logCh, plainPath, Options{})
+	if _, err = plain.Checkpoint(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = MakeEngine(CountMinSketch, nil, false, logCh, plainPath, opts); err == nil {
+		t.Error("plain dump must not load with key")
+	}
+	if _, err = NewKeyer([]byte("short")); err == nil {
+		t.Error("short key must be rejected")
+	}
+}
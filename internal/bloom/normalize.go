@@ -0,0 +1,142 @@
+package bloom
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// Шаги нормализации значений, s/шаблон/замена/ - замена по регулярному выражению (разделитель - символ после s).
+const (
+	NormalizeTrim      = "trim"
+	NormalizeLowercase = "lowercase"
+	NormalizeNFC       = "nfc"
+	NormalizeDigits    = "digits"
+)
+
+// errNormalizedEmpty запись источника, от которой после нормализации ничего не осталось.
+var errNormalizedEmpty = errors.New("empty after normalization")
+
+// Normalizer шаги, которые по порядку применяются к каждому значению до хеширования:
+// " Foo@Mail.ru" и "foo@mail.ru" после trim и lowercase - одно значение.
+type Normalizer []func(string) string
+
+// ParseNormalizer собирает Normalizer из шагов конфигурации: trim, lowercase, nfc, digits, s/шаблон/замена/.
+func ParseNormalizer(steps []string) (Normalizer, error) {
+	normalizer := make(Normalizer, 0, len(steps))
+	for _, step := range steps {
+		switch step {
+		case NormalizeTrim:
+			normalizer = append(normalizer, strings.TrimSpace)
+		case NormalizeLowercase:
+			normalizer = append(normalizer, strings.ToLower)
+		case NormalizeNFC:
+			normalizer = append(normalizer, norm.NFC.String)
+		case NormalizeDigits:
+			normalizer = append(normalizer, onlyDigits)
+		default:
+			replace, err := parseReplace(step)
+			if err != nil {
+				return nil, err
+			}
+			normalizer = append(normalizer, replace)
+		}
+	}
+	return normalizer, nil
+}
+
+// Normalize значение после всех шагов.
+func (n Normalizer) Normalize(value string) string {
+	for _, step := range n {
+		value = step(value)
+	}
+	return value
+}
+
+func onlyDigits(value string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= '0' && r <= '9' {
+			return r
+		}
+		return -1
+	}, value)
+}
+
+// parseReplace шаг s/шаблон/замена/ как в sed, в замене доступны группы $1.
+// Если в шаблоне есть /, подойдёт другой разделитель: s|^\+7|8|.
+func parseReplace(step string) (func(string) string, error) {
+	if len(step) < 4 || step[0] != 's' || unicode.IsLetter(rune(step[1])) || unicode.IsSpace(rune(step[1])) {
+		return nil, fmt.Errorf("unknown normalize step `%s`: trim, lowercase, nfc, digits or s/pattern/replacement/", step)
+	}
+	parts := strings.Split(step[2:], step[1:2])
+	if len(parts) != 3 || parts[2] != "" {
+		return nil, fmt.Errorf("normalize step `%s`: want s%[2]cpattern%[2]creplacement%[2]c", step, step[1])
+	}
+	pattern, err := regexp.Compile(parts[0])
+	if err != nil {
+		return nil, fmt.Errorf("normalize step `%s`: %w", step, err)
+	}
+	replacement := parts[1]
+	return func(value string) string {
+		return pattern.ReplaceAllString(value, replacement)
+	}, nil
+}
+
+// NormalizedFilter нормализует значения до всех остальных обёрток: HyperLogLog, топ дублей и движок
+// видят одно и то же значение, как бы его ни прислали. Пустое после нормализации значение не добавляется.
+type NormalizedFilter struct {
+	Filter
+	normalizer Normalizer
+}
+
+func withNormalizer(filter Filter, normalizer Normalizer) *NormalizedFilter {
+	return &NormalizedFilter{Filter: filter, normalizer: normalizer}
+}
+
+func (f *NormalizedFilter) Unwrap() Filter {
+	return f.Filter
+}
+
+func (f *NormalizedFilter) Add(value string) {
+	if value = f.normalizer.Normalize(value); value != "" {
+		f.Filter.Add(value)
+	}
+}
+
+func (f *NormalizedFilter) Test(value string) bool {
+	value = f.normalizer.Normalize(value)
+	return value != "" && f.Filter.Test(value)
+}
+
+func (f *NormalizedFilter) TestAndAdd(value string) bool {
+	value = f.normalizer.Normalize(value)
+	return value != "" && f.Filter.TestAndAdd(value)
+}
+
+// Incr Counter поверх внутреннего фильтра, см. AsCounter.
+func (f *NormalizedFilter) Incr(value string, delta uint64) uint64 {
+	counter, _ := AsCounter(f.Filter)
+	if value = f.normalizer.Normalize(value); value == "" {
+		return 0
+	}
+	return counter.Incr(value, delta)
+}
+
+func (f *NormalizedFilter) Count(value string) uint64 {
+	counter, _ := AsCounter(f.Filter)
+	if value = f.normalizer.Normalize(value); value == "" {
+		return 0
+	}
+	return counter.Count(value)
+}
+
+// Remove Remover поверх внутреннего фильтра, см. AsRemover.
+func (f *NormalizedFilter) Remove(value string) bool {
+	remover, _ := AsRemover(f.Filter)
+	value = f.normalizer.Normalize(value)
+	return value != "" && remover.Remove(value)
+}
@@ -0,0 +1,77 @@
+package bloom
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseNormalizer(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		steps []string
+		value string
+		want  string
+	}{
+		{[]string{"trim", "lowercase"}, " Foo@Mail.ru \t", "foo@mail.ru"},
+		{[]string{"nfc"}, "e\u0301", "\u00e9"},
+		{[]string{"digits", `s|^8(\d{10})$|7$1|`}, "8 (999) 111-00-11", "79991110011"},
+		{[]string{"s/\\s+/ /"}, "a  \t b", "a b"},
+		{nil, " As Is ", " As Is "},
+	}
+	for _, tt := range tests {
+		normalizer, err := ParseNormalizer(tt.steps)
+		if err != nil {
+			t.Fatalf("ParseNormalizer(%q): %v", tt.steps, err)
+		}
+		if got := normalizer.Normalize(tt.value); got != tt.want {
+			t.Errorf("Normalize(%q) with %q = %q, want %q", tt.value, tt.steps, got, tt.want)
+		}
+	}
+
+	for _, steps := range [][]string{{"upper"}, {"s/a/b"}, {"s/a/b/c/"}, {"s/(/x/"}} {
+		if _, err := ParseNormalizer(steps); err == nil {
+			t.Errorf("ParseNormalizer(%q) must fail", steps)
+		}
+	}
+}
+
+func TestNormalizedFilter(t *testing.T) {
+	t.Parallel()
+
+	logCh := make(chan LogEvent)
+	go func() {
+		for range logCh {
+		}
+	}()
+	dir := t.TempDir()
+	source := filepath.Join(dir, "source.txt")
+	if err := os.WriteFile(source, []byte(" Foo@Mail.ru\nfoo@mail.ru \n  \nBar@mail.ru\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	normalizer, _ := ParseNormalizer([]string{"trim", "lowercase"})
+	opts := Options{Cardinality: true, Normalize: normalizer}
+	filter, err := MakeEngine(CountMinSketch, []string{source}, false, logCh, filepath.Join(dir, "filter.cms"), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// загрузка и API видят одно значение
+	counter, _ := AsCounter(filter)
+	if got := counter.Count("FOO@mail.ru"); got != 2 {
+		t.Errorf("Count(FOO@mail.ru) = %d, want 2", got)
+	}
+	if got := counter.Incr(" bar@MAIL.ru", 1); got != 2 {
+		t.Errorf("Incr(bar@MAIL.ru) = %d, want 2", got)
+	}
+	if progress, _ := BootstrapProgress(filepath.Join(dir, "filter.cms")); progress.Malformed != 1 {
+		t.Errorf("progress = %+v, empty value must be malformed", progress)
+	}
+	if filter.TestAndAdd("   ") || filter.Test("") {
+		t.Error("empty value after normalization must not be added")
+	}
+	if cardinality, _ := AsCardinality(filter); cardinality.Distinct() != 2 {
+		t.Errorf("Distinct() = %d, want 2", cardinality.Distinct())
+	}
+}
@@ -0,0 +1,624 @@
+package bloom
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"bloom-du/internal/utils"
+)
+
+// persistentFilter общий для всех движков слой: загрузка дампа или источника, чекпоинт, логи и статистика.
+// Движок реализует только Membership, сохраняется, если реализует Snapshotter.
+type persistentFilter struct {
+	structure Membership
+	// sources файлы, директории и glob шаблоны источника, раскрываются при загрузке
+	sources       []string
+	sourceOptions SourceOptions
+	dumpFilepath  string
+	// mux эксклюзивный на загрузку и чекпоинт, операции с движком его не берут (как и раньше)
+	mux            sync.RWMutex
+	needCheckpoint atomic.Bool // true if new element added. False if not AND last checkpoint success
+	logCh          chan LogEvent
+	// sourceObservers получают каждую строку источника при загрузке (HyperLogLog): stdin второй раз не прочитать
+	sourceObservers []func(line []byte)
+	// ingested файлы источника, загруженные целиком (SkipIngested), сохраняются при чекпоинте под mux
+	ingested ingestedFiles
+	// resume позиция загрузки источника, сохраняется при чекпоинтах во время загрузки
+	resume resumeState
+	// normalizer и validator нормализуют и проверяют ключи источника при загрузке,
+	// в API это делают NormalizedFilter и ValidatedFilter
+	normalizer Normalizer
+	validator  *Validator
+	// keyer HMAC ключей источника, в API - KeyedFilter. ID ключа пишется в заголовок дампа
+	keyer *Keyer
+	// checkpointHooks сохраняют то, что наполняется при загрузке вместе с фильтром (HyperLogLog)
+	checkpointHooks []func() (bool, error)
+}
+
+// newPersistentFilter фильтр без данных, загрузка - Boostrap.
+func newPersistentFilter(structure Membership, sources []string, sourceOptions SourceOptions, logCh chan LogEvent, checkpointPath string) *persistentFilter {
+	return &persistentFilter{
+		structure:     structure,
+		sources:       sources,
+		sourceOptions: sourceOptions,
+		dumpFilepath:  checkpointPath,
+		logCh:         logCh,
+		ingested:      ingestedFiles{},
+		resume:        resumeState{},
+	}
+}
+
+// observeSource fn будет вызван для каждой строки источника. Регистрировать до Boostrap.
+func (f *persistentFilter) observeSource(fn func(line []byte)) {
+	f.sourceObservers = append(f.sourceObservers, fn)
+}
+
+// onBootstrapCheckpoint fn вызывается при чекпоинте во время загрузки источника, после дампа фильтра.
+func (f *persistentFilter) onBootstrapCheckpoint(fn func() (bool, error)) {
+	f.checkpointHooks = append(f.checkpointHooks, fn)
+}
+
+func (f *persistentFilter) Engine() ProbabilisticEngine {
+	return f.structure.Engine()
+}
+
+func (f *persistentFilter) LogCh() chan<- LogEvent {
+	return f.logCh
+}
+
+func (f *persistentFilter) Add(value string) {
+	f.structure.Add([]byte(value))
+	f.needCheckpoint.Store(true)
+}
+
+func (f *persistentFilter) Test(value string) bool {
+	return f.structure.Test([]byte(value))
+}
+
+func (f *persistentFilter) TestAndAdd(value string) bool {
+	added := f.structure.TestAndAdd([]byte(value))
+	if added {
+		f.needCheckpoint.Store(true)
+		f.LogCh() <- LogEvent{Level: zerolog.DebugLevel, Name: "add", Count: 1.0}
+	}
+	return added
+}
+
+// Incr, Count и Remove пересылают capability движку и отмечают изменения для чекпоинта, см. engineCapability.
+func (f *persistentFilter) Incr(value string, delta uint64) uint64 {
+	f.needCheckpoint.Store(true)
+	return f.structure.(Counter).Incr(value, delta)
+}
+
+func (f *persistentFilter) Count(value string) uint64 {
+	return f.structure.(Counter).Count(value)
+}
+
+func (f *persistentFilter) Remove(value string) bool {
+	removed := f.structure.(Remover).Remove(value)
+	if removed {
+		f.needCheckpoint.Store(true)
+	}
+	return removed
+}
+
+func (f *persistentFilter) GetDumpSize() uint64 {
+	return getDumpSize(f.dumpFilepath)
+}
+
+func (f *persistentFilter) Checkpoint() (bool, error) {
+	snapshotter, ok := f.structure.(Snapshotter)
+	if !ok || !f.needCheckpoint.Load() {
+		f.LogCh() <- LogEvent{Level: zerolog.DebugLevel, Name: "checkpoint", Msg: "Checkpoint is not necessary now."}
+		return false, nil
+	}
+
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	// изменения во время записи попадут в следующий чекпоинт
+	f.needCheckpoint.Store(false)
+	err := utils.WriteDump(f.dumpFilepath, f.dump(snapshotter))
+	if err != nil {
+		f.needCheckpoint.Store(true)
+		f.LogCh() <- LogEvent{
+			Level: zerolog.ErrorLevel,
+			Name:  "checkpoint",
+			Msg:   fmt.Sprintf("Error to save Checkpoint: %v", err),
+		}
+		return false, err
+	}
+	// загрузка закончена и целиком в дампе
+	if err = os.Remove(f.dumpFilepath + resumeSuffix); err != nil && !os.IsNotExist(err) {
+		f.LogCh() <- LogEvent{Level: zerolog.ErrorLevel, Name: "checkpoint", Msg: fmt.Sprintf("Error to remove resume state: %v", err)}
+	}
+	if f.sourceOptions.SkipIngested {
+		// список пишется после дампа: файл, не попавший в дамп, не должен считаться загруженным
+		if err = utils.WriteDump(f.dumpFilepath+ingestedSuffix, f.ingested); err != nil {
+			f.LogCh() <- LogEvent{
+				Level: zerolog.ErrorLevel,
+				Name:  "checkpoint",
+				Msg:   fmt.Sprintf("Error to save ingested sources: %v", err),
+			}
+		}
+	}
+
+	return true, nil
+}
+
+func (f *persistentFilter) Stats() Stats {
+	reporter, ok := f.structure.(StatsReporter)
+	if !ok {
+		return Stats{Engine: f.Engine().String()}
+	}
+	f.mux.RLock()
+	defer f.mux.RUnlock()
+	return reporter.Stats()
+}
+
+// Boostrap загружает дамп, если он есть, иначе источник. С force источник читается поверх дампа.
+// С SkipIngested после дампа читаются файлы источника, которых ещё нет в списке загруженных.
+// Прерванная загрузка (есть дамп и позиция) продолжается с сохранённой позиции, в том числе с force.
+func (f *persistentFilter) Boostrap(force bool) {
+	hasSource := len(f.sources) > 0
+
+	if hasSource && f.isDumpExist() && fileExists(f.dumpFilepath+resumeSuffix) {
+		f.LogCh() <- LogEvent{
+			Level: zerolog.InfoLevel,
+			Name:  bootstrapName,
+			Msg:   fmt.Sprintf("Resume load data from: %s", strings.Join(f.sources, ", ")),
+		}
+		f.loadDump()
+		if f.sourceOptions.SkipIngested {
+			f.loadIngested()
+		}
+		if err := readDump(f.dumpFilepath+resumeSuffix, f.resume); err != nil {
+			f.LogCh() <- LogEvent{Level: zerolog.ErrorLevel, Name: bootstrapName, Msg: fmt.Sprintf("Load resume state err: %v", err)}
+		}
+		f.bootstrap()
+		f.logStructure()
+		return
+	}
+
+	forceLoadFromSource := force && hasSource
+	defaultDumpLoad := !force && f.isDumpExist()
+	defaultSourceLoad := !force && hasSource && !f.isDumpExist()
+	emptyLoad := !hasSource && !f.isDumpExist()
+
+	if forceLoadFromSource {
+		if f.isDumpExist() {
+			f.loadDump()
+		}
+		f.bootstrap()
+	}
+
+	if defaultDumpLoad {
+		f.loadDump()
+		if hasSource && f.sourceOptions.SkipIngested {
+			f.loadIngested()
+			f.bootstrap()
+		}
+	}
+
+	if defaultSourceLoad {
+		f.LogCh() <- LogEvent{
+			Level: zerolog.InfoLevel,
+			Name:  bootstrapName,
+			Msg:   fmt.Sprintf("Try load data from: %s", strings.Join(f.sources, ", ")),
+		}
+		f.bootstrap()
+	}
+
+	if emptyLoad {
+		f.LogCh() <- LogEvent{
+			Level: zerolog.InfoLevel,
+			Name:  bootstrapName,
+			Msg:   "Start empty filter",
+		}
+	}
+
+	f.logStructure()
+}
+
+func (f *persistentFilter) logStructure() {
+	if stringer, ok := f.structure.(fmt.Stringer); ok {
+		f.LogCh() <- LogEvent{Level: zerolog.DebugLevel, Name: bootstrapName, Msg: stringer.String()}
+	}
+}
+
+// isDumpExist дамп движка без Snapshotter не читается, даже если файл есть.
+func (f *persistentFilter) isDumpExist() bool {
+	_, ok := f.structure.(Snapshotter)
+	return ok && fileExists(f.dumpFilepath)
+}
+
+func (f *persistentFilter) loadDump() {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	f.LogCh() <- LogEvent{
+		Level: zerolog.InfoLevel,
+		Name:  bootstrapName,
+		Msg:   fmt.Sprintf("Try load dump: %s!", f.dumpFilepath),
+	}
+
+	if err := readDump(f.dumpFilepath, f.dump(f.structure.(Snapshotter))); err != nil {
+		f.LogCh() <- LogEvent{Level: zerolog.ErrorLevel, Name: bootstrapName, Msg: fmt.Sprintf("Load dump err: %v", err)}
+	}
+}
+
+// dump дамп движка, у фильтра с ключом - с ID ключа в заголовке.
+func (f *persistentFilter) dump(snapshotter Snapshotter) Snapshotter {
+	if f.keyer == nil {
+		return snapshotter
+	}
+	return keyedDump{Snapshotter: snapshotter, keyID: f.keyer.ID()}
+}
+
+// checkDumpKey дамп загружается, только если записан с тем же ключом HMAC (или оба без ключа):
+// иначе значения из API не совпадут со значениями в дампе, а чекпоинт перезапишет дамп.
+func (f *persistentFilter) checkDumpKey() error {
+	if !f.isDumpExist() {
+		return nil
+	}
+	keyID, err := dumpKeyID(f.dumpFilepath)
+	if err != nil {
+		return fmt.Errorf("dump %s: %w", f.dumpFilepath, err)
+	}
+	want := ""
+	if f.keyer != nil {
+		want = f.keyer.ID()
+	}
+	switch {
+	case keyID == want:
+		return nil
+	case want == "":
+		return fmt.Errorf("dump %s is written with hmac key id %s, but hmac key is not configured", f.dumpFilepath, keyID)
+	case keyID == "":
+		return fmt.Errorf("dump %s is written without hmac key, remove it to rebuild the filter with key id %s", f.dumpFilepath, want)
+	default:
+		return fmt.Errorf("dump %s is written with hmac key id %s, configured key id %s", f.dumpFilepath, keyID, want)
+	}
+}
+
+// loadIngested список загруженных файлов пишется вместе с дампом, без дампа он не нужен.
+func (f *persistentFilter) loadIngested() {
+	path := f.dumpFilepath + ingestedSuffix
+	if !fileExists(path) {
+		return
+	}
+	if err := readDump(path, f.ingested); err != nil {
+		f.LogCh() <- LogEvent{Level: zerolog.ErrorLevel, Name: bootstrapName, Msg: fmt.Sprintf("Load ingested sources err: %v", err)}
+	}
+}
+
+// maxMalformedLogs сколько пропущенных записей источника логировать, дальше только счётчик.
+const maxMalformedLogs = 10
+
+// sourceBatch ключи одного файла источника подряд в data, ends - конец каждого ключа.
+// offset и records - позиция в файле после пакета. Последний пакет файла с last, err - ошибка чтения файла.
+type sourceBatch struct {
+	file    *fileProgress
+	data    []byte
+	ends    []int
+	offset  int64
+	records int64
+	last    bool
+	err     error
+}
+
+const sourceBatchSize = 1024
+
+// bootstrap читает файлы источника по очереди или по SourceOptions.Parallel одновременно,
+// ключи добавляются через TestAndAdd движка в одной горутине: движки не потокобезопасны.
+func (f *persistentFilter) bootstrap() {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+
+	files, err := ExpandSources(f.sources)
+	if err != nil {
+		f.LogCh() <- LogEvent{Level: zerolog.ErrorLevel, Name: bootstrapName, Msg: fmt.Sprintf("Load from file err: %v", err)}
+		return
+	}
+	for path := range f.resume {
+		if _, ok := f.resume.position(path); !ok || !slices.Contains(files, path) {
+			delete(f.resume, path)
+		}
+	}
+	files = slices.DeleteFunc(files, func(file string) bool {
+		if f.ingested.has(file) {
+			f.LogCh() <- LogEvent{Level: zerolog.InfoLevel, Name: bootstrapName, Msg: fmt.Sprintf("Skip ingested: %s", file)}
+			return true
+		}
+		if position, ok := f.resume.position(file); ok && position.Done {
+			f.LogCh() <- LogEvent{Level: zerolog.InfoLevel, Name: bootstrapName, Msg: fmt.Sprintf("Skip loaded before restart: %s", file)}
+			f.ingested[file] = position.ingestedFile
+			return true
+		}
+		return false
+	})
+	if len(files) == 0 {
+		f.LogCh() <- LogEvent{Level: zerolog.InfoLevel, Name: bootstrapName, Msg: "No new source files"}
+		return
+	}
+
+	format := f.sourceOptions.format()
+	progress := startProgress(f.dumpFilepath, strings.Join(f.sources, ", "), format, files)
+	defer progress.done.Store(true)
+	// resumed строки, прочитанные до перезапуска: в scanned они есть, в этой загрузке не добавлялись
+	var resumed int64
+	for _, file := range progress.files {
+		file.stat, _ = statIngested(file.source)
+		if position, ok := f.resume.position(file.source); ok {
+			file.offset, file.records = position.Offset, position.Records
+			resumed += position.Records
+			f.LogCh() <- LogEvent{
+				Level: zerolog.InfoLevel,
+				Name:  bootstrapName,
+				Msg:   fmt.Sprintf("Resume %s from line %s", file.source, utils.HumInt(int(position.Records))),
+			}
+		}
+	}
+	lastCheckpoint := time.Now()
+
+	queue := make(chan *fileProgress)
+	batches := make(chan sourceBatch, 4*max(f.sourceOptions.Parallel, 1))
+	var wg sync.WaitGroup
+	for range max(f.sourceOptions.Parallel, 1) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for file := range queue {
+				f.readSource(file, progress, batches)
+			}
+		}()
+	}
+	go func() {
+		for _, file := range progress.files {
+			queue <- file
+		}
+		close(queue)
+		wg.Wait()
+		close(batches)
+	}()
+
+	for batch := range batches {
+		start := 0
+		for _, end := range batch.ends {
+			key := batch.data[start:end]
+			start = end
+			for _, observe := range f.sourceObservers {
+				observe(key)
+			}
+			if !f.structure.TestAndAdd(key) {
+				continue
+			}
+			batch.file.added.Add(1)
+			if added := progress.added.Add(1); added%10_000_000 == 0 {
+				f.LogCh() <- LogEvent{
+					Level: zerolog.InfoLevel,
+					Name:  bootstrapName,
+					Msg:   fmt.Sprintf("Добавлено: %s из [%s]", utils.HumInt(int(added)), utils.HumInt(int(progress.total.Load()))),
+				}
+			}
+			f.LogCh() <- LogEvent{Level: zerolog.InfoLevel, Name: "add", Count: 1.0}
+		}
+		batch.file.offset, batch.file.records = batch.offset, batch.records
+		if batch.last {
+			f.finishSource(batch.file, batch.err)
+		}
+		if interval := f.sourceOptions.CheckpointInterval; interval > 0 && time.Since(lastCheckpoint) >= interval {
+			f.checkpointBootstrap(progress)
+			lastCheckpoint = time.Now()
+		}
+	}
+
+	f.needCheckpoint.Store(true)
+
+	added, malformed, rejected := progress.added.Load(), progress.malformed.Load(), progress.rejected.Load()
+	skipped := progress.scanned.Load() - resumed - added - malformed - rejected
+	f.LogCh() <- LogEvent{
+		Level: zerolog.InfoLevel,
+		Name:  bootstrapName,
+		Msg:   fmt.Sprintf("Добавлено: [%s] Пропущено [%s]", utils.HumInt(int(added)), utils.HumInt(int(skipped))),
+	}
+	if malformed > 0 {
+		f.LogCh() <- LogEvent{
+			Level: zerolog.WarnLevel,
+			Name:  bootstrapName,
+			Msg:   fmt.Sprintf("Некорректных записей %s: [%s]", format, utils.HumInt(int(malformed))),
+		}
+	}
+	if rejected > 0 {
+		f.LogCh() <- LogEvent{
+			Level: zerolog.WarnLevel,
+			Name:  bootstrapName,
+			Msg:   fmt.Sprintf("Не прошли проверку значений: [%s]", utils.HumInt(int(rejected))),
+		}
+	}
+}
+
+// readSource читает один файл и отправляет его ключи пакетами, последним всегда идёт пакет с last.
+func (f *persistentFilter) readSource(file *fileProgress, progress *progress, batches chan<- sourceBatch) {
+	batch := sourceBatch{file: file}
+	defer func() {
+		batch.last = true
+		batches <- batch
+	}()
+
+	// file.offset и file.records дальше меняет только bootstrap, здесь своя копия
+	offset, count := file.offset, file.records
+	batch.offset, batch.records = offset, count
+	source, err := openSource(file.source)
+	if err != nil {
+		batch.err = err
+		return
+	}
+	defer source.Close()
+	records, err := newRecordReader(source, f.sourceOptions, offset)
+	if err != nil {
+		batch.err = err
+		return
+	}
+	file.scanned.Store(count)
+	progress.scanned.Add(count)
+
+	lineCount := int64(getLineCount(file.source))
+	file.total.Store(lineCount)
+	progress.total.Add(lineCount)
+	msg := fmt.Sprintf("Load file: %s", file.source)
+	if source.format != "" {
+		msg += fmt.Sprintf(", source format: %s", source.format)
+	}
+	f.LogCh() <- LogEvent{Level: zerolog.InfoLevel, Name: bootstrapName, Msg: msg}
+
+	for {
+		key, err := records.Read()
+		if err == io.EOF {
+			break
+		}
+		if err == nil && len(f.normalizer) > 0 {
+			if key = []byte(f.normalizer.Normalize(string(key))); len(key) == 0 {
+				err = &malformedRecord{line: int(count + 1), err: errNormalizedEmpty}
+			}
+		}
+		if err == nil && f.validator != nil {
+			if invalid := f.validator.Validate(string(key)); invalid != nil {
+				count++
+				batch.offset, batch.records = records.Offset(), count
+				file.scanned.Add(1)
+				file.rejected.Add(1)
+				progress.scanned.Add(1)
+				if progress.rejected.Add(1) <= maxMalformedLogs {
+					f.LogCh() <- LogEvent{
+						Level: zerolog.WarnLevel,
+						Name:  bootstrapName,
+						Msg:   fmt.Sprintf("Skip rejected %s record %d: %v", file.source, count, invalid),
+					}
+				}
+				continue
+			}
+		}
+		var bad *malformedRecord
+		if errors.As(err, &bad) {
+			count++
+			batch.offset, batch.records = records.Offset(), count
+			file.scanned.Add(1)
+			file.malformed.Add(1)
+			progress.scanned.Add(1)
+			if progress.malformed.Add(1) <= maxMalformedLogs {
+				f.LogCh() <- LogEvent{
+					Level: zerolog.WarnLevel,
+					Name:  bootstrapName,
+					Msg:   fmt.Sprintf("Skip malformed %s %s %v", f.sourceOptions.format(), file.source, bad),
+				}
+			}
+			continue
+		}
+		if err != nil {
+			batch.err = err
+			return
+		}
+
+		count++
+		batch.offset, batch.records = records.Offset(), count
+		file.scanned.Add(1)
+		if scanned := progress.scanned.Add(1); scanned%1_000_000 == 0 {
+			f.LogCh() <- LogEvent{
+				Level: zerolog.InfoLevel,
+				Name:  bootstrapName,
+				Msg:   fmt.Sprintf("Прочитано: %s из [%s]", utils.HumInt(int(scanned)), utils.HumInt(int(progress.total.Load()))),
+			}
+		}
+
+		if f.keyer != nil {
+			key = []byte(f.keyer.Sum(string(key)))
+		}
+		batch.data = append(batch.data, key...)
+		batch.ends = append(batch.ends, len(batch.data))
+		if len(batch.ends) == sourceBatchSize {
+			batches <- batch
+			batch = sourceBatch{file: file, offset: batch.offset, records: count}
+		}
+	}
+}
+
+// checkpointBootstrap сохраняет дамп посреди загрузки и позицию каждого файла после него:
+// после остановки Boostrap продолжит с неё. Ключи, добавленные после дампа, но до позиции, прочитаются ещё раз.
+func (f *persistentFilter) checkpointBootstrap(progress *progress) {
+	snapshotter, ok := f.structure.(Snapshotter)
+	if !ok {
+		return
+	}
+	for _, file := range progress.files {
+		if file.stat == (ingestedFile{}) || file.records == 0 && !file.done.Load() {
+			continue
+		}
+		f.resume[file.source] = resumeFile{
+			ingestedFile: file.stat,
+			Offset:       file.offset,
+			Records:      file.records,
+			Done:         file.done.Load() && !file.failed,
+		}
+	}
+
+	start := time.Now()
+	err := utils.WriteDump(f.dumpFilepath, f.dump(snapshotter))
+	for _, hook := range f.checkpointHooks {
+		if err != nil {
+			break
+		}
+		_, err = hook()
+	}
+	if err == nil {
+		err = utils.WriteDump(f.dumpFilepath+resumeSuffix, f.resume)
+	}
+	if err != nil {
+		f.LogCh() <- LogEvent{Level: zerolog.ErrorLevel, Name: bootstrapName, Msg: fmt.Sprintf("Error to save bootstrap checkpoint: %v", err)}
+		return
+	}
+	f.LogCh() <- LogEvent{
+		Level: zerolog.InfoLevel,
+		Name:  bootstrapName,
+		Msg:   fmt.Sprintf("Bootstrap checkpoint saved [%s]", time.Since(start)),
+	}
+}
+
+// finishSource вызывается, когда все ключи файла добавлены: файл целиком в фильтре, его можно запомнить.
+func (f *persistentFilter) finishSource(file *fileProgress, err error) {
+	file.done.Store(true)
+	file.failed = err != nil
+	if err != nil {
+		f.LogCh() <- LogEvent{Level: zerolog.ErrorLevel, Name: bootstrapName, Msg: fmt.Sprintf("Load from file %s err: %v", file.source, err)}
+		return
+	}
+	f.LogCh() <- LogEvent{
+		Level: zerolog.InfoLevel,
+		Name:  bootstrapName,
+		Msg: fmt.Sprintf("File %s done: added [%s] of [%s]",
+			file.source, utils.HumInt(int(file.added.Load())), utils.HumInt(int(file.scanned.Load()))),
+	}
+	if file.stat != (ingestedFile{}) {
+		f.ingested[file.source] = file.stat
+	}
+}
+
+// readDump читает дамп, записанный writeDump.
+func readDump(dumpFilepath string, from io.ReaderFrom) error {
+	file, err := os.Open(dumpFilepath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, err = from.ReadFrom(bufio.NewReader(file))
+	return err
+}
@@ -0,0 +1,73 @@
+package bloom
+
+import (
+	"cmp"
+	"fmt"
+	"io"
+
+	"bloom-du/internal/redisbloom"
+	"bloom-du/internal/utils"
+)
+
+const (
+	redisCapacity = 200_000_000
+	redisFpRate   = 0.1
+)
+
+type RedisBloomFilter struct {
+	RBF *redisbloom.Filter
+}
+
+// NewRedisBloomFilter creating RedisBloom compatible filter, loading and checkpoint - persistentFilter
+func NewRedisBloomFilter(opts Options) *RedisBloomFilter {
+	return &RedisBloomFilter{RBF: redisbloom.New(uint64(cmp.Or(opts.Capacity, redisCapacity)), cmp.Or(opts.FpRate, redisFpRate))}
+}
+
+func (f *RedisBloomFilter) Engine() ProbabilisticEngine {
+	return RedisBloom
+}
+
+func (f *RedisBloomFilter) Add(data []byte) {
+	f.RBF.Add(data)
+}
+
+func (f *RedisBloomFilter) Test(data []byte) bool {
+	return f.RBF.Test(data)
+}
+
+func (f *RedisBloomFilter) TestAndAdd(data []byte) bool {
+	return !f.RBF.TestAndAdd(data)
+}
+
+func (f *RedisBloomFilter) WriteTo(stream io.Writer) (int64, error) {
+	return f.RBF.WriteTo(stream)
+}
+
+func (f *RedisBloomFilter) ReadFrom(stream io.Reader) (int64, error) {
+	return f.RBF.ReadFrom(stream)
+}
+
+func (f *RedisBloomFilter) Stats() Stats {
+	fillRatio, k := f.RBF.FillRatio(), uint64(f.RBF.K())
+	return Stats{
+		Engine:       f.Engine().String(),
+		Cells:        f.RBF.Bits(),
+		K:            k,
+		Count:        f.RBF.Count(),
+		FillRatio:    fillRatio,
+		FpRate:       estimatedFpRate(fillRatio, k),
+		TargetFpRate: f.RBF.ErrorRate(),
+		Cardinality:  estimatedCardinality(f.RBF.Bits(), k, fillRatio),
+		MemoryBytes:  redisbloom.DataSize(f.RBF.Bits()),
+	}
+}
+
+func (f *RedisBloomFilter) String() string {
+	return fmt.Sprintf("[Capacity: %s] [K: %d] Count: %s, FillRatio: %f, EstimatedFillRatio: %f",
+		utils.HumInt(int(f.RBF.Capacity())),
+		f.RBF.K(),
+		utils.HumInt(int(f.RBF.Count())),
+		f.RBF.FillRatio(),
+		f.RBF.EstimatedFillRatio(),
+	)
+}
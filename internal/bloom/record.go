@@ -0,0 +1,376 @@
+package bloom
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Форматы источника: строка целиком, колонки CSV или поля JSON-lines.
+const (
+	SourceLines  = "lines"
+	SourceCSV    = "csv"
+	SourceNDJSON = "ndjson"
+
+	// defaultKeySeparator разделитель частей составного ключа.
+	defaultKeySeparator = "|"
+	// maxRecordSize максимальная длина строки источника.
+	maxRecordSize = 1 << 20
+)
+
+// SourceOptions как из записи источника получается значение для фильтра. Нулевое значение - строка целиком.
+type SourceOptions struct {
+	// Format lines, csv или ndjson, пусто - lines.
+	Format string
+	// Delimiter разделитель колонок CSV, пусто - запятая.
+	Delimiter string
+	// Header первая строка CSV - заголовок, она не добавляется. Включается сама, если колонка задана именем.
+	Header bool
+	// Key колонки CSV (номер с 1 или имя из заголовка) или пути полей JSON (user.email, items.0.id).
+	// Несколько колонок - составной ключ.
+	Key []string
+	// KeySeparator разделитель частей составного ключа, пусто - "|".
+	KeySeparator string
+	// Parallel сколько файлов источника читать одновременно, 0 и 1 - по очереди.
+	Parallel int
+	// SkipIngested запоминать прочитанные файлы и после перезапуска с дампом читать только новые и изменённые.
+	SkipIngested bool
+	// CheckpointInterval как часто сохранять дамп и позицию во время загрузки, чтобы после остановки
+	// продолжить с неё. 0 - только в конце, как обычный чекпоинт.
+	CheckpointInterval time.Duration
+}
+
+// Validate проверяет сочетание формата и ключа до загрузки.
+func (o SourceOptions) Validate() error {
+	if o.Parallel < 0 {
+		return fmt.Errorf("source parallel must be >= 0")
+	}
+	switch o.Format {
+	case "", SourceLines:
+		if len(o.Key) > 0 {
+			return fmt.Errorf("source key is not supported for %s format", SourceLines)
+		}
+	case SourceCSV:
+		if len(o.Key) == 0 {
+			return fmt.Errorf("source key is required for %s format", SourceCSV)
+		}
+		if _, err := o.delimiter(); err != nil {
+			return err
+		}
+		for _, column := range o.Key {
+			if index, err := strconv.Atoi(column); err == nil && index < 1 {
+				return fmt.Errorf("csv column must be >= 1, got %d", index)
+			}
+		}
+	case SourceNDJSON:
+		if len(o.Key) == 0 {
+			return fmt.Errorf("source key is required for %s format", SourceNDJSON)
+		}
+		if slices.Contains(o.Key, "") {
+			return fmt.Errorf("json field path must not be empty")
+		}
+	default:
+		return fmt.Errorf("unknown source format `%s`, expected %s, %s or %s", o.Format, SourceLines, SourceCSV, SourceNDJSON)
+	}
+	return nil
+}
+
+func (o SourceOptions) format() string {
+	if o.Format == "" {
+		return SourceLines
+	}
+	return o.Format
+}
+
+func (o SourceOptions) delimiter() (rune, error) {
+	if o.Delimiter == "" {
+		return ',', nil
+	}
+	// \t в конфигурации и флагах
+	delimiter := strings.ReplaceAll(o.Delimiter, `\t`, "\t")
+	r, size := utf8.DecodeRuneInString(delimiter)
+	if size != len(delimiter) || r == utf8.RuneError || r == '"' || r == '\n' || r == '\r' {
+		return 0, fmt.Errorf("bad csv delimiter `%s`", o.Delimiter)
+	}
+	return r, nil
+}
+
+func (o SourceOptions) separator() string {
+	if o.KeySeparator == "" {
+		return defaultKeySeparator
+	}
+	return o.KeySeparator
+}
+
+// malformedRecord запись источника, из которой не получилось достать ключ: она пропускается и считается.
+type malformedRecord struct {
+	line int
+	err  error
+}
+
+func (e *malformedRecord) Error() string {
+	return fmt.Sprintf("line %d: %v", e.line, e.err)
+}
+
+func (e *malformedRecord) Unwrap() error {
+	return e.err
+}
+
+// recordReader возвращает ключи записей источника: *malformedRecord - запись пропущена, io.EOF - конец.
+// Offset - байт распакованного источника сразу после последней прочитанной записи.
+type recordReader interface {
+	Read() ([]byte, error)
+	Offset() int64
+}
+
+// newRecordReader с offset > 0 продолжает чтение с этого байта (см. Offset): заголовок CSV читается
+// с начала, остальное до offset пропускается. Сжатый источник при этом всё равно распаковывается.
+func newRecordReader(source io.Reader, opts SourceOptions, offset int64) (recordReader, error) {
+	buffered := bufio.NewReaderSize(source, 64*1024)
+	if opts.format() == SourceCSV {
+		return newCSVReader(buffered, opts, offset)
+	}
+
+	if err := discard(buffered, offset); err != nil {
+		return nil, err
+	}
+	scanner := newLineScanner(buffered)
+	lines := &lineReader{scanner: scanner, offset: offset}
+	scanner.Split(lines.scanLines)
+	if opts.format() == SourceNDJSON {
+		return &ndjsonReader{lineReader: lines, paths: splitPaths(opts.Key), separator: opts.separator()}, nil
+	}
+	return lines, nil
+}
+
+func discard(buffered *bufio.Reader, n int64) error {
+	if n <= 0 {
+		return nil
+	}
+	if _, err := buffered.Discard(int(n)); err != nil {
+		return fmt.Errorf("skip %d bytes: %w", n, err)
+	}
+	return nil
+}
+
+func newLineScanner(source io.Reader) *bufio.Scanner {
+	scanner := bufio.NewScanner(source)
+	scanner.Buffer(make([]byte, 64*1024), maxRecordSize)
+	return scanner
+}
+
+// lineReader строка целиком, как было до форматов.
+type lineReader struct {
+	scanner *bufio.Scanner
+	offset  int64
+}
+
+// scanLines bufio.ScanLines, который считает прочитанные байты.
+func (r *lineReader) scanLines(data []byte, atEOF bool) (int, []byte, error) {
+	advance, token, err := bufio.ScanLines(data, atEOF)
+	r.offset += int64(advance)
+	return advance, token, err
+}
+
+func (r *lineReader) Read() ([]byte, error) {
+	if r.scanner.Scan() {
+		return r.scanner.Bytes(), nil
+	}
+	if err := r.scanner.Err(); err != nil {
+		return nil, err
+	}
+	return nil, io.EOF
+}
+
+func (r *lineReader) Offset() int64 {
+	return r.offset
+}
+
+type csvReader struct {
+	reader    *csv.Reader
+	columns   []int
+	separator string
+	key       []byte
+	// skipped байты, пропущенные при продолжении загрузки: csv.Reader их не видел
+	skipped int64
+}
+
+// newCSVReader читает заголовок, если он есть, и находит в нём колонки, заданные именем.
+// csv.Reader читает из buffered напрямую и только до конца записи, поэтому после заголовка можно пропускать байты.
+func newCSVReader(buffered *bufio.Reader, opts SourceOptions, offset int64) (*csvReader, error) {
+	delimiter, err := opts.delimiter()
+	if err != nil {
+		return nil, err
+	}
+	reader := csv.NewReader(buffered)
+	reader.Comma = delimiter
+	// количество колонок проверяется по ключу, а не по первой записи
+	reader.FieldsPerRecord = -1
+	reader.ReuseRecord = true
+
+	r := &csvReader{reader: reader, columns: make([]int, len(opts.Key)), separator: opts.separator()}
+	header := opts.Header
+	for i, column := range opts.Key {
+		index, err := strconv.Atoi(column)
+		if err != nil {
+			header = true
+			continue
+		}
+		r.columns[i] = index - 1
+	}
+	if header {
+		if err = r.readHeader(opts); err != nil {
+			return nil, err
+		}
+	}
+
+	if offset > reader.InputOffset() {
+		r.skipped = offset - reader.InputOffset()
+		if err = discard(buffered, r.skipped); err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+func (r *csvReader) readHeader(opts SourceOptions) error {
+	names, err := r.reader.Read()
+	if err != nil {
+		return fmt.Errorf("csv header: %w", err)
+	}
+	for i, column := range opts.Key {
+		if _, err := strconv.Atoi(column); err == nil {
+			continue
+		}
+		index := slices.Index(names, column)
+		if index < 0 {
+			return fmt.Errorf("csv header has no column `%s`", column)
+		}
+		r.columns[i] = index
+	}
+	return nil
+}
+
+func (r *csvReader) Offset() int64 {
+	return r.skipped + r.reader.InputOffset()
+}
+
+func (r *csvReader) Read() ([]byte, error) {
+	record, err := r.reader.Read()
+	var parseErr *csv.ParseError
+	if errors.As(err, &parseErr) {
+		return nil, &malformedRecord{line: parseErr.StartLine, err: parseErr.Err}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	r.key = r.key[:0]
+	for i, column := range r.columns {
+		if column >= len(record) {
+			line, _ := r.reader.FieldPos(0)
+			return nil, &malformedRecord{line: line, err: fmt.Errorf("no column %d, got %d", column+1, len(record))}
+		}
+		if i > 0 {
+			r.key = append(r.key, r.separator...)
+		}
+		r.key = append(r.key, record[column]...)
+	}
+	return r.key, nil
+}
+
+type ndjsonReader struct {
+	*lineReader
+	paths     [][]string
+	separator string
+	line      int
+	key       []byte
+}
+
+func splitPaths(fields []string) [][]string {
+	paths := make([][]string, 0, len(fields))
+	for _, field := range fields {
+		paths = append(paths, strings.Split(field, "."))
+	}
+	return paths
+}
+
+func (r *ndjsonReader) Read() ([]byte, error) {
+	for r.scanner.Scan() {
+		r.line++
+		line := bytes.TrimSpace(r.scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+
+		var document any
+		decoder := json.NewDecoder(bytes.NewReader(line))
+		decoder.UseNumber()
+		if err := decoder.Decode(&document); err != nil {
+			return nil, &malformedRecord{line: r.line, err: err}
+		}
+		if _, err := decoder.Token(); err != io.EOF {
+			return nil, &malformedRecord{line: r.line, err: fmt.Errorf("data after json value")}
+		}
+
+		r.key = r.key[:0]
+		for i, path := range r.paths {
+			value, err := jsonField(document, path)
+			if err != nil {
+				return nil, &malformedRecord{line: r.line, err: err}
+			}
+			if i > 0 {
+				r.key = append(r.key, r.separator...)
+			}
+			r.key = append(r.key, value...)
+		}
+		return r.key, nil
+	}
+	if err := r.scanner.Err(); err != nil {
+		return nil, err
+	}
+	return nil, io.EOF
+}
+
+// jsonField значение поля по пути: строка как есть, число и bool текстом. Объект, массив и null - ошибка.
+func jsonField(document any, path []string) (string, error) {
+	value := document
+	for _, name := range path {
+		switch node := value.(type) {
+		case map[string]any:
+			field, ok := node[name]
+			if !ok {
+				return "", fmt.Errorf("no field `%s`", strings.Join(path, "."))
+			}
+			value = field
+		case []any:
+			index, err := strconv.Atoi(name)
+			if err != nil || index < 0 || index >= len(node) {
+				return "", fmt.Errorf("no field `%s`", strings.Join(path, "."))
+			}
+			value = node[index]
+		default:
+			return "", fmt.Errorf("no field `%s`", strings.Join(path, "."))
+		}
+	}
+
+	switch value := value.(type) {
+	case string:
+		return value, nil
+	case json.Number:
+		return value.String(), nil
+	case bool:
+		return strconv.FormatBool(value), nil
+	default:
+		return "", fmt.Errorf("field `%s` is not a string, number or bool", strings.Join(path, "."))
+	}
+}
@@ -0,0 +1,119 @@
+package bloom
+
+import (
+	"errors"
+	"io"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestRecordReader(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name      string
+		opts      SourceOptions
+		data      string
+		keys      []string
+		malformed int
+	}{
+		{
+			name: "lines",
+			data: "a\nb,c\n",
+			keys: []string{"a", "b,c"},
+		},
+		{
+			name: "csv column index",
+			opts: SourceOptions{Format: SourceCSV, Delimiter: ";", Key: []string{"2"}},
+			data: "1;alice@example.com\n2\n3;\"bob;x\"\n",
+			keys: []string{"alice@example.com", "bob;x"}, malformed: 1,
+		},
+		{
+			name: "csv header composite",
+			opts: SourceOptions{Format: SourceCSV, Key: []string{"tenant", "email"}},
+			data: "email,tenant\na@x,1\nb\"@x,2\nc@x,3\n",
+			keys: []string{"1|a@x", "3|c@x"}, malformed: 1,
+		},
+		{
+			name: "csv header by index",
+			opts: SourceOptions{Format: SourceCSV, Delimiter: `\t`, Header: true, Key: []string{"1"}},
+			data: "phone\tname\n79990001122\tann\n",
+			keys: []string{"79990001122"},
+		},
+		{
+			name: "ndjson",
+			opts: SourceOptions{Format: SourceNDJSON, Key: []string{"user.email", "items.0.id"}, KeySeparator: ":"},
+			data: `{"user":{"email":"a@x"},"items":[{"id":7}]}` + "\n\n" +
+				`{"user":{"email":"b@x"}}` + "\n" +
+				`{"user":` + "\n" +
+				`{"user":{"email":"c@x"},"items":[{"id":true}]} {}` + "\n" +
+				`{"user":{"email":"d@x"},"items":[{"id":"9"}]}` + "\n",
+			keys: []string{"a@x:7", "d@x:9"}, malformed: 3,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.opts.Validate(); err != nil {
+				t.Fatal(err)
+			}
+			keys, malformed, offsets := readRecords(t, tt.data, tt.opts, 0)
+			if !slices.Equal(keys, tt.keys) || malformed != tt.malformed {
+				t.Errorf("keys %q malformed %d, want %q and %d", keys, malformed, tt.keys, tt.malformed)
+			}
+
+			// продолжение загрузки с позиции после первого ключа
+			resumed, _, _ := readRecords(t, tt.data, tt.opts, offsets[0])
+			if !slices.Equal(resumed, tt.keys[1:]) {
+				t.Errorf("resumed from %d: keys %q, want %q", offsets[0], resumed, tt.keys[1:])
+			}
+		})
+	}
+}
+
+// readRecords ключи, количество некорректных записей и Offset после каждого ключа.
+func readRecords(t *testing.T, data string, opts SourceOptions, offset int64) ([]string, int, []int64) {
+	t.Helper()
+	records, err := newRecordReader(strings.NewReader(data), opts, offset)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var keys []string
+	var offsets []int64
+	malformed := 0
+	for {
+		key, err := records.Read()
+		if err == io.EOF {
+			return keys, malformed, offsets
+		}
+		var bad *malformedRecord
+		if errors.As(err, &bad) {
+			malformed++
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys = append(keys, string(key))
+		offsets = append(offsets, records.Offset())
+	}
+}
+
+func TestSourceOptionsValidate(t *testing.T) {
+	t.Parallel()
+
+	for _, opts := range []SourceOptions{
+		{Format: "xml"},
+		{Format: SourceCSV},
+		{Format: SourceCSV, Key: []string{"0"}},
+		{Format: SourceCSV, Delimiter: "ab", Key: []string{"1"}},
+		{Format: SourceNDJSON, Key: []string{""}},
+		{Key: []string{"1"}},
+	} {
+		if err := opts.Validate(); err == nil {
+			t.Errorf("%+v: expected error", opts)
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package bloom
+
+import (
+	"encoding/json"
+	"io"
+)
+
+// resumeSuffix файл рядом с дампом с позицией прерванной загрузки источника.
+// Пишется после дампа при чекпоинтах во время загрузки, удаляется первым обычным чекпоинтом.
+const resumeSuffix = ".resume"
+
+// resumeFile сколько файла источника уже в дампе. Файл с другим размером или временем изменения читается заново.
+type resumeFile struct {
+	ingestedFile
+	// Offset байт распакованного файла после последней записи в дампе
+	Offset  int64 `json:"offset"`
+	Records int64 `json:"records"`
+	Done    bool  `json:"done"`
+}
+
+// resumeState позиция загрузки по пути файла источника.
+type resumeState map[string]resumeFile
+
+// position с какого места продолжить файл: ok false - файла в состоянии нет или он изменился.
+func (state resumeState) position(path string) (resumeFile, bool) {
+	file, ok := state[path]
+	if !ok || !file.unchanged(path) {
+		return resumeFile{}, false
+	}
+	return file, true
+}
+
+func (state resumeState) WriteTo(stream io.Writer) (int64, error) {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return 0, err
+	}
+	n, err := stream.Write(data)
+	return int64(n), err
+}
+
+func (state resumeState) ReadFrom(stream io.Reader) (int64, error) {
+	data, err := io.ReadAll(stream)
+	if err != nil {
+		return int64(len(data)), err
+	}
+	return int64(len(data)), json.Unmarshal(data, &state)
+}
@@ -0,0 +1,74 @@
+package bloom
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"bloom-du/internal/utils"
+)
+
+func TestBootstrapResume(t *testing.T) {
+	t.Parallel()
+
+	logCh := make(chan LogEvent)
+	go func() {
+		for range logCh {
+		}
+	}()
+	dir := t.TempDir()
+	source := filepath.Join(dir, "source.txt")
+	checkpointPath := filepath.Join(dir, "filter.cms")
+	var lines []byte
+	for i := 0; i < 3_000; i++ {
+		lines = fmt.Appendf(lines, "value_%d\n", i)
+	}
+	if err := os.WriteFile(source, lines, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// чекпоинт после каждого пакета, позиция последнего - файл загружен целиком
+	opts := Options{Source: SourceOptions{CheckpointInterval: time.Nanosecond}}
+	if _, err := MakeEngine(CountMinSketch, []string{source}, false, logCh, checkpointPath, opts); err != nil {
+		t.Fatal(err)
+	}
+	state := resumeState{}
+	if err := readDump(checkpointPath+resumeSuffix, state); err != nil {
+		t.Fatal(err)
+	}
+	if position, ok := state.position(source); !ok || !position.Done || position.Records != 3_000 {
+		t.Fatalf("resume state = %+v", state)
+	}
+
+	// остановка после первых 1000 строк: продолжение читает только остальные
+	position := state[source]
+	position.Done, position.Records, position.Offset = false, 1_000, int64(len("value_0\n")*10+len("value_10\n")*90+len("value_100\n")*900)
+	state[source] = position
+	if err := utils.WriteDump(checkpointPath+resumeSuffix, state); err != nil {
+		t.Fatal(err)
+	}
+	restored, err := MakeEngine(CountMinSketch, []string{source}, false, logCh, checkpointPath, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	counter, _ := AsCounter(restored)
+	if got := counter.Count("value_999"); got != 1 {
+		t.Errorf("Count(value_999) = %d, want 1: line before resume position read again", got)
+	}
+	if got := counter.Count("value_1000"); got != 2 {
+		t.Errorf("Count(value_1000) = %d, want 2: line after resume position not read", got)
+	}
+	if progress, _ := BootstrapProgress(checkpointPath); progress.Scanned != 3_000 || progress.Added != 0 {
+		t.Errorf("progress = %+v", progress)
+	}
+
+	// обычный чекпоинт после загрузки убирает позицию
+	if _, err = restored.Checkpoint(); err != nil {
+		t.Fatal(err)
+	}
+	if fileExists(checkpointPath + resumeSuffix) {
+		t.Error("resume state must be removed by checkpoint")
+	}
+}
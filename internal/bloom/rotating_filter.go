@@ -0,0 +1,286 @@
+package bloom
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+	boom "github.com/tylertreat/BoomFilters"
+
+	"bloom-du/internal/utils"
+)
+
+const (
+	rotatingWindow      = 24 * time.Hour
+	rotatingGenerations = 24
+	// rotatingCapacity ёмкость одного поколения по умолчанию.
+	rotatingCapacity = 10_000_000
+	rotatingFpRate   = 0.01
+)
+
+// generation поколение: classic фильтр, в который добавляются элементы с start до start + span.
+type generation struct {
+	start time.Time
+	bf    *boom.BloomFilter
+	// nonZero установленные биты закрытого поколения: в него больше не пишут, считаются один раз и без mux
+	counted sync.Once
+	nonZero uint64
+}
+
+// RotatingBloomFilter "видели за последние window": N поколений по window/N.
+// Проверка идёт по всем живым поколениям, добавление - в текущее. Поколение удаляется,
+// когда с его начала прошло window, поэтому элемент живёт от window - span до window.
+type RotatingBloomFilter struct {
+	// generations от старого к новому, последнее - текущее
+	generations  []*generation
+	window       time.Duration
+	span         time.Duration
+	capacity     uint
+	fpRate       float64
+	nextRotation int64
+	now          func() time.Time
+	// mux нужен на каждую операцию: ротация меняет generations, а classic фильтр хеширует
+	// через общий hash.Hash64 и меняет биты без синхронизации
+	mux   sync.Mutex
+	logCh chan LogEvent
+}
+
+// NewRotatingBloomFilter creating rotating filter, loading and checkpoint - persistentFilter
+func NewRotatingBloomFilter(logCh chan LogEvent, opts Options) *RotatingBloomFilter {
+	return newRotatingBloomFilter(logCh, opts, time.Now)
+}
+
+func newRotatingBloomFilter(logCh chan LogEvent, opts Options, now func() time.Time) *RotatingBloomFilter {
+	window := cmp.Or(opts.Window, rotatingWindow)
+	filter := &RotatingBloomFilter{
+		window:   window,
+		span:     window / time.Duration(cmp.Or(opts.Generations, rotatingGenerations)),
+		capacity: cmp.Or(opts.Capacity, rotatingCapacity),
+		fpRate:   cmp.Or(opts.FpRate, rotatingFpRate),
+		now:      now,
+		logCh:    logCh,
+	}
+	filter.generations = []*generation{filter.newGeneration(filter.now())}
+	filter.rotate(filter.now())
+
+	return filter
+}
+
+func (f *RotatingBloomFilter) newGeneration(now time.Time) *generation {
+	return &generation{
+		start: now.Truncate(f.span),
+		bf:    boom.NewBloomFilter(f.capacity, f.fpRate),
+	}
+}
+
+func (f *RotatingBloomFilter) current() *boom.BloomFilter {
+	return f.generations[len(f.generations)-1].bf
+}
+
+// rotate открывает новое поколение, если текущее закончилось, и удаляет истёкшие. Вызывается под mux.Lock.
+func (f *RotatingBloomFilter) rotate(now time.Time) {
+	if last := f.generations[len(f.generations)-1]; !now.Before(last.start.Add(f.span)) {
+		f.generations = append(f.generations, f.newGeneration(now))
+	}
+
+	expired := 0
+	for expired < len(f.generations)-1 && !f.generations[expired].start.Add(f.window).After(now) {
+		expired++
+	}
+	if expired > 0 {
+		f.generations = append([]*generation(nil), f.generations[expired:]...)
+		f.logCh <- LogEvent{
+			Level: zerolog.InfoLevel,
+			Name:  "rotate",
+			Msg:   fmt.Sprintf("Удалено поколений: %d, осталось: %d", expired, len(f.generations)),
+		}
+	}
+
+	f.nextRotation = f.generations[len(f.generations)-1].start.Add(f.span).UnixNano()
+}
+
+// lock захватывает mux и делает ротацию, если пора: проверяется при каждой операции.
+func (f *RotatingBloomFilter) lock() {
+	f.mux.Lock()
+	if now := f.now(); now.UnixNano() >= f.nextRotation {
+		f.rotate(now)
+	}
+}
+
+func (f *RotatingBloomFilter) Engine() ProbabilisticEngine {
+	return RotatingBloom
+}
+
+func (f *RotatingBloomFilter) Add(data []byte) {
+	f.lock()
+	defer f.mux.Unlock()
+	f.current().Add(data)
+}
+
+func (f *RotatingBloomFilter) Test(data []byte) bool {
+	f.lock()
+	defer f.mux.Unlock()
+	for i := len(f.generations) - 1; i >= 0; i-- {
+		if f.generations[i].bf.Test(data) {
+			return true
+		}
+	}
+	return false
+}
+
+// TestAndAdd всегда добавляет в текущее поколение: повторно увиденный элемент живёт ещё window.
+func (f *RotatingBloomFilter) TestAndAdd(data []byte) bool {
+	f.lock()
+	defer f.mux.Unlock()
+
+	if f.current().TestAndAdd(data) {
+		return false
+	}
+	for _, gen := range f.generations[:len(f.generations)-1] {
+		if gen.bf.Test(data) {
+			return false
+		}
+	}
+	return true
+}
+
+// WriteTo дамп: window и span (нс), количество поколений, затем для каждого
+// начало (unix нс) и дамп classic фильтра.
+func (f *RotatingBloomFilter) WriteTo(stream io.Writer) (int64, error) {
+	f.lock()
+	defer f.mux.Unlock()
+
+	header := []int64{int64(f.window), int64(f.span), int64(len(f.generations))}
+	if err := binary.Write(stream, binary.BigEndian, header); err != nil {
+		return 0, err
+	}
+
+	written := int64(binary.Size(header))
+	for _, gen := range f.generations {
+		if err := binary.Write(stream, binary.BigEndian, gen.start.UnixNano()); err != nil {
+			return written, err
+		}
+		n, err := gen.bf.WriteTo(stream)
+		written += 8 + n
+		if err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
+
+// ReadFrom загружает поколения из дампа. Если window или span в конфигурации поменялись,
+// старые поколения доживают до своего срока, новые создаются по новой конфигурации.
+func (f *RotatingBloomFilter) ReadFrom(stream io.Reader) (int64, error) {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+
+	header := make([]int64, 3)
+	if err := binary.Read(stream, binary.BigEndian, header); err != nil {
+		return 0, err
+	}
+	window, span, count := time.Duration(header[0]), time.Duration(header[1]), header[2]
+	if count <= 0 {
+		return 0, fmt.Errorf("rotating dump: bad generations count %d", count)
+	}
+	if window != f.window || span != f.span {
+		f.logCh <- LogEvent{
+			Level: zerolog.WarnLevel,
+			Name:  bootstrapName,
+			Msg:   fmt.Sprintf("Dump window %s / span %s differs from config %s / %s", window, span, f.window, f.span),
+		}
+	}
+
+	read := int64(binary.Size(header))
+	generations := make([]*generation, 0, count)
+	for range count {
+		var start int64
+		if err := binary.Read(stream, binary.BigEndian, &start); err != nil {
+			return read, err
+		}
+		bf := boom.NewBloomFilter(1, rotatingFpRate)
+		n, err := bf.ReadFrom(stream)
+		read += 8 + n
+		if err != nil {
+			return read, err
+		}
+		generations = append(generations, &generation{start: time.Unix(0, start), bf: bf})
+	}
+
+	f.generations = generations
+	f.rotate(f.now())
+	return read, nil
+}
+
+// countNonZero установленные биты classic фильтра.
+func countNonZero(dump io.WriterTo) uint64 {
+	// дамп: count, m, k, затем Buckets
+	counter := &cellCounter{sizeAt: 3 * 8}
+	_, _ = dump.WriteTo(counter)
+	return counter.nonZero
+}
+
+// Stats FillRatio - заполненность текущего поколения (по ней срабатывают пороги насыщения),
+// FpRate - вероятность ложного ответа хотя бы одного из поколений. Под mux только ротация
+// и копия текущего поколения, биты считаются без блокировки.
+func (f *RotatingBloomFilter) Stats() Stats {
+	// lock делает ротацию: у фильтра без запросов истёкшие поколения удаляются хотя бы при сборе метрик
+	f.lock()
+	generations := slices.Clone(f.generations)
+	current := generations[len(generations)-1]
+	currentCount := uint64(current.bf.Count())
+	var currentDump bytes.Buffer
+	_, _ = current.bf.WriteTo(&currentDump)
+	f.mux.Unlock()
+	currentNonZero := countNonZero(&currentDump)
+
+	stats := Stats{
+		Engine:       f.Engine().String(),
+		K:            uint64(current.bf.K()),
+		TargetFpRate: f.fpRate,
+		Generations:  len(generations),
+	}
+	passRate := 1.0
+	for _, gen := range generations {
+		nonZero, count := currentNonZero, currentCount
+		if gen != current {
+			nonZero, count = gen.closedNonZero(), uint64(gen.bf.Count())
+		}
+
+		cells, k := uint64(gen.bf.Capacity()), uint64(gen.bf.K())
+		fillRatio := float64(nonZero) / float64(cells)
+		stats.Cells += cells
+		stats.Count += count
+		stats.FillRatio = fillRatio
+		stats.Cardinality += estimatedCardinality(cells, k, fillRatio)
+		stats.MemoryBytes += bucketsDataSize(gen.bf.Capacity(), 1)
+		passRate *= 1 - estimatedFpRate(fillRatio, k)
+	}
+	stats.FpRate = 1 - passRate
+	return stats
+}
+
+// closedNonZero установленные биты поколения, которое уже не текущее.
+func (g *generation) closedNonZero() uint64 {
+	g.counted.Do(func() {
+		g.nonZero = countNonZero(g.bf)
+	})
+	return g.nonZero
+}
+
+func (f *RotatingBloomFilter) String() string {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	return fmt.Sprintf("[Window: %s] [Span: %s] Generations: %d, Capacity per generation: %s",
+		f.window,
+		f.span,
+		len(f.generations),
+		utils.HumInt(int(f.capacity)),
+	)
+}
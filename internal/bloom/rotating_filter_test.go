@@ -0,0 +1,97 @@
+package bloom
+
+import (
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRotatingBloomFilter(t *testing.T) {
+	t.Parallel()
+
+	logCh := make(chan LogEvent, 1000)
+	dumpPath := filepath.Join(t.TempDir(), "rotating.bloom")
+	opts := Options{Window: 3 * time.Hour, Generations: 3, Capacity: 10_000}
+
+	now := time.Date(2024, 1, 1, 10, 30, 0, 0, time.UTC)
+	clock := func() time.Time { return now }
+	filter := newPersistentFilter(newRotatingBloomFilter(logCh, opts, clock), nil, SourceOptions{}, logCh, dumpPath)
+	filter.Boostrap(false)
+
+	if !filter.TestAndAdd("first") || filter.TestAndAdd("first") {
+		t.Fatal("first TestAndAdd must add, second must find")
+	}
+
+	// 11:30, 12:30 - новые поколения, first ещё в окне
+	for _, hour := range []int{11, 12} {
+		now = now.Add(time.Hour)
+		if !filter.Test("first") {
+			t.Fatalf("%d:30: first must be alive", hour)
+		}
+	}
+	filter.Add("second")
+	if got := filter.Stats().Generations; got != 3 {
+		t.Fatalf("generations = %d, want 3", got)
+	}
+
+	if _, err := filter.Checkpoint(); err != nil {
+		t.Fatalf("checkpoint: %v", err)
+	}
+	restored := newPersistentFilter(newRotatingBloomFilter(logCh, opts, clock), nil, SourceOptions{}, logCh, dumpPath)
+	restored.Boostrap(false)
+	if !restored.Test("first") || !restored.Test("second") {
+		t.Fatal("restored filter lost values")
+	}
+
+	// 13:30 - поколение 10:00 истекло, без запросов его удаляет сбор метрик
+	now = now.Add(time.Hour)
+	if stats := filter.Stats(); stats.Count != 1 {
+		t.Errorf("idle filter count = %d, want 1: expired generation must be dropped", stats.Count)
+	}
+	for name, f := range map[string]Filter{"filter": filter, "restored": restored} {
+		if f.Test("first") {
+			t.Errorf("%s: first must expire after window", name)
+		}
+		if !f.Test("second") {
+			t.Errorf("%s: second must be alive", name)
+		}
+	}
+}
+
+// TestRotatingBloomFilterParallel classic фильтр хеширует через общий hash.Hash64:
+// без блокировки параллельные вызовы портят индексы, и дубли проходят как новые.
+func TestRotatingBloomFilterParallel(t *testing.T) {
+	t.Parallel()
+
+	filter := NewRotatingBloomFilter(make(chan LogEvent, 1000), Options{Capacity: 100_000})
+	var wg sync.WaitGroup
+	// метрики собираются параллельно с записью
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for range 20 {
+			_ = filter.Stats()
+		}
+	}()
+	for w := range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range 5000 {
+				filter.TestAndAdd([]byte(strconv.Itoa(w*5000 + i)))
+			}
+		}()
+	}
+	wg.Wait()
+
+	for i := range 8 * 5000 {
+		if !filter.Test([]byte(strconv.Itoa(i))) {
+			t.Fatalf("value %d is lost", i)
+		}
+	}
+	if stats := filter.Stats(); stats.Count != 8*5000 || stats.Cardinality < 0.9*8*5000 {
+		t.Errorf("stats count = %d, cardinality = %f", stats.Count, stats.Cardinality)
+	}
+}
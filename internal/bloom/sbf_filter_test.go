@@ -62,7 +62,7 @@ func TestTestAddValue2(t *testing.T) {
 		t.Run(test.name, func(t *testing.T) {
 			t.Parallel()
 			logCh := make(chan LogEvent, 50)
-			filter := NewStableBloomFilter("", false, logCh, "")
+			filter := newPersistentFilter(NewStableBloomFilter(Options{}), nil, SourceOptions{}, logCh, "")
 
 			for i := 0; i < 30_000; i++ {
 				value := fmt.Sprintf("test_%d", i)
@@ -95,7 +95,7 @@ func TestGetDumpSize(t *testing.T) {
 		[]byte("Lorem ipsum dolor sit amet, consectetur adipiscing elit. Vivamus sit amet neque ac lorem dapibus ac."),
 	)
 
-	f := StableBloomFilter{dumpFilepath: filePath}
+	f := persistentFilter{dumpFilepath: filePath}
 
 	size := f.GetDumpSize()
 
@@ -123,9 +123,7 @@ func TestLineCounter(t *testing.T) {
 		[]byte("One\nTwo\nThree\nFour\nFive\n"),
 	)
 
-	f := StableBloomFilter{sourceFilepath: filePath}
-
-	count := f.getLineCount()
+	count := getLineCount(filePath)
 
 	if count != 5 {
 		t.Errorf("Expected size 5, got %v", count)
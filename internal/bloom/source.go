@@ -0,0 +1,145 @@
+package bloom
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+// StdinSource источник "-": строки читаются из stdin (pg_dump | bloom-du --source -).
+// Stdin читается один раз, поэтому количество строк заранее неизвестно.
+const StdinSource = "-"
+
+// decompressor формат сжатия источника, определяется по первым байтам, а не по расширению.
+type decompressor struct {
+	name  string
+	magic []byte
+	// match сигнатура с переменными байтами, проверяется вместо magic по первым len(magic) байтам
+	match func(head []byte) bool
+	open  func(r io.Reader) (io.ReadCloser, error)
+}
+
+// isBzip2 "BZh", размер блока 1-9 и сигнатура первого блока (пи) или конца пустого потока (корень из пи):
+// текст, который начинается с "BZh", не должен читаться как bzip2.
+func isBzip2(head []byte) bool {
+	return len(head) == 10 && string(head[:3]) == "BZh" && head[3] >= '1' && head[3] <= '9' &&
+		(bytes.Equal(head[4:], []byte{0x31, 0x41, 0x59, 0x26, 0x53, 0x59}) ||
+			bytes.Equal(head[4:], []byte{0x17, 0x72, 0x45, 0x38, 0x50, 0x90}))
+}
+
+var (
+	decompressorsMux sync.RWMutex
+	decompressors    = []decompressor{
+		{name: "gzip", magic: []byte{0x1f, 0x8b}, open: func(r io.Reader) (io.ReadCloser, error) {
+			return gzip.NewReader(r)
+		}},
+		{name: "zstd", magic: []byte{0x28, 0xb5, 0x2f, 0xfd}, open: func(r io.Reader) (io.ReadCloser, error) {
+			decoder, err := zstd.NewReader(r)
+			if err != nil {
+				return nil, err
+			}
+			return decoder.IOReadCloser(), nil
+		}},
+		{name: "bzip2", magic: make([]byte, 10), match: isBzip2, open: func(r io.Reader) (io.ReadCloser, error) {
+			return io.NopCloser(bzip2.NewReader(r)), nil
+		}},
+		{name: "xz", magic: []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}, open: func(r io.Reader) (io.ReadCloser, error) {
+			reader, err := xz.NewReader(r)
+			if err != nil {
+				return nil, err
+			}
+			return io.NopCloser(reader), nil
+		}},
+	}
+)
+
+// RegisterDecompressor добавляет формат источника. Сигнатуры проверяются в порядке регистрации.
+func RegisterDecompressor(name string, magic []byte, open func(r io.Reader) (io.ReadCloser, error)) {
+	decompressorsMux.Lock()
+	defer decompressorsMux.Unlock()
+	decompressors = append(decompressors, decompressor{name: name, magic: magic, open: open})
+}
+
+// sourceReader распакованные данные источника, Close закрывает и распаковщик, и файл.
+type sourceReader struct {
+	io.Reader
+	// format имя формата сжатия, пусто - без сжатия
+	format  string
+	closers []io.Closer
+}
+
+func (r *sourceReader) Close() error {
+	var err error
+	for i := len(r.closers) - 1; i >= 0; i-- {
+		if closeErr := r.closers[i].Close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+// openSource открывает файл или stdin (StdinSource) и распаковывает, если первые байты совпали с сигнатурой.
+func openSource(sourceFilepath string) (*sourceReader, error) {
+	source := &sourceReader{}
+	var file io.Reader = os.Stdin
+	if sourceFilepath != StdinSource {
+		f, err := os.Open(sourceFilepath)
+		if err != nil {
+			return nil, err
+		}
+		source.closers = append(source.closers, f)
+		file = f
+	}
+
+	buffered := bufio.NewReader(file)
+	source.Reader = buffered
+
+	decompressorsMux.RLock()
+	defer decompressorsMux.RUnlock()
+	for _, d := range decompressors {
+		// Peek вернёт меньше байт для короткого файла, тогда сигнатура просто не совпадёт
+		head, _ := buffered.Peek(len(d.magic))
+		if d.match != nil && !d.match(head) || d.match == nil && !bytes.Equal(head, d.magic) {
+			continue
+		}
+		reader, err := d.open(buffered)
+		if err != nil {
+			_ = source.Close()
+			return nil, err
+		}
+		source.Reader, source.format = reader, d.name
+		source.closers = append(source.closers, reader)
+		break
+	}
+	return source, nil
+}
+
+// getLineCount количество строк источника для прогресса загрузки, 0 - неизвестно (stdin или ошибка).
+func getLineCount(sourceFilepath string) int {
+	if sourceFilepath == StdinSource {
+		return 0
+	}
+	source, err := openSource(sourceFilepath)
+	if err != nil {
+		return 0
+	}
+	defer source.Close()
+
+	count := 0
+	buf := make([]byte, 32*1024)
+	lineSep := []byte{'\n'}
+	for {
+		n, err := source.Read(buf)
+		count += bytes.Count(buf[:n], lineSep)
+		if err != nil {
+			return count
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package bloom
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+func TestOpenSource(t *testing.T) {
+	t.Parallel()
+
+	const lines = "one\ntwo\nthree\n"
+	compress := map[string]func(w io.Writer) (io.WriteCloser, error){
+		"gzip": func(w io.Writer) (io.WriteCloser, error) { return gzip.NewWriter(w), nil },
+		"zstd": func(w io.Writer) (io.WriteCloser, error) { return zstd.NewWriter(w) },
+		"xz":   func(w io.Writer) (io.WriteCloser, error) { return xz.NewWriter(w) },
+	}
+
+	dir := t.TempDir()
+	// расширение не важно: .gz без сжатия читается как текст
+	files := map[string]string{"": filepath.Join(dir, "plain.gz")}
+	if err := os.WriteFile(files[""], []byte(lines), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// bzip2 из стандартной библиотеки не пишет: printf 'one\ntwo\nthree\n' | bzip2 | base64
+	bzipped, _ := base64.StdEncoding.DecodeString("QlpoOTFBWSZTWQh7fdcAAATBgAAQAkGUgCAAMQwIIaPUyIVHMjio8XckU4UJAIe33XA=")
+	files["bzip2"] = filepath.Join(dir, "bzip2.txt")
+	if err := os.WriteFile(files["bzip2"], bzipped, 0644); err != nil {
+		t.Fatal(err)
+	}
+	for format, newWriter := range compress {
+		var buf bytes.Buffer
+		w, err := newWriter(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, _ = io.WriteString(w, lines)
+		if err = w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		files[format] = filepath.Join(dir, format+".txt")
+		if err = os.WriteFile(files[format], buf.Bytes(), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// текст, который начинается с "BZh", - не bzip2
+	const bzhLines = "BZh9 is not bzip2\ntwo\n"
+	bzhText := filepath.Join(dir, "bzh.txt")
+	if err := os.WriteFile(bzhText, []byte(bzhLines), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if source, err := openSource(bzhText); err != nil || source.format != "" {
+		t.Errorf("BZh text: format %v, %v", source, err)
+	} else {
+		_ = source.Close()
+	}
+
+	for format, path := range files {
+		source, err := openSource(path)
+		if err != nil {
+			t.Fatalf("%s: %v", format, err)
+		}
+		data, err := io.ReadAll(source)
+		_ = source.Close()
+		if err != nil {
+			t.Fatalf("%s: %v", format, err)
+		}
+		if source.format != format || string(data) != lines {
+			t.Errorf("%s: format %q, data %q", format, source.format, data)
+		}
+		if got := getLineCount(path); got != strings.Count(lines, "\n") {
+			t.Errorf("%s: line count %d", format, got)
+		}
+	}
+}
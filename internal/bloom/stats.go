@@ -0,0 +1,218 @@
+package bloom
+
+import (
+	"math"
+	"math/bits"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Stats состояние фильтра для отчётов и метрик.
+type Stats struct {
+	Engine string `json:"engine"`
+	// Cells размер фильтра: бит для classic и redis, ячеек для stable.
+	Cells uint64 `json:"cells"`
+	K     uint64 `json:"k"`
+	// Count количество добавленных элементов, для stable не считается.
+	Count     uint64  `json:"count"`
+	FillRatio float64 `json:"fill_ratio"`
+	// FpRate оценка вероятности ложноположительного ответа при текущей заполненности.
+	FpRate float64 `json:"fp_rate"`
+	// TargetFpRate fpRate, с которым создан фильтр (для stable - в стабильном состоянии).
+	TargetFpRate float64 `json:"target_fp_rate"`
+	StablePoint  float64 `json:"stable_point,omitempty"`
+	// Cardinality оценка количества уникальных элементов по заполненности, для stable не считается.
+	Cardinality float64 `json:"cardinality"`
+	MemoryBytes uint64  `json:"memory_bytes"`
+	// Generations количество живых поколений (rotating).
+	Generations int `json:"generations,omitempty"`
+	// Distinct оценка HyperLogLog количества уникальных значений, если он включён (cardinality).
+	Distinct *uint64 `json:"distinct,omitempty"`
+}
+
+// estimatedFpRate вероятность ложноположительного ответа при текущей заполненности:
+// все k проверяемых ячеек уже не нулевые.
+func estimatedFpRate(fillRatio float64, k uint64) float64 {
+	return math.Pow(fillRatio, float64(k))
+}
+
+// estimatedCardinality оценка количества элементов по доле установленных бит (Swamidass, Baldi):
+// n = -m/k * ln(1 - X/m).
+func estimatedCardinality(cells, k uint64, fillRatio float64) float64 {
+	if fillRatio >= 1 {
+		return math.Inf(1)
+	}
+	return -float64(cells) / float64(k) * math.Log(1-fillRatio)
+}
+
+// cellCounter считает ненулевые ячейки Buckets (BoomFilters) прямо из потока WriteTo,
+// не копируя данные фильтра. sizeAt - смещение поля bucketSize в дампе.
+type cellCounter struct {
+	offset     int64
+	sizeAt     int64
+	bucketSize uint
+	acc        uint64
+	accBits    uint
+	nonZero    uint64
+}
+
+// bucketsDataOffset смещение данных Buckets относительно bucketSize: bucketSize, max, count, len.
+const bucketsDataOffset = 1 + 1 + 8 + 8
+
+func (c *cellCounter) Write(p []byte) (int, error) {
+	n := len(p)
+	for len(p) > 0 {
+		switch dataAt := c.sizeAt + bucketsDataOffset; {
+		case c.offset == c.sizeAt:
+			c.bucketSize = uint(p[0])
+			p = p[1:]
+			c.offset++
+		case c.offset < dataAt:
+			limit := c.sizeAt
+			if c.offset > c.sizeAt {
+				limit = dataAt
+			}
+			skip := min(int64(len(p)), limit-c.offset)
+			p = p[skip:]
+			c.offset += skip
+		default:
+			c.count(p)
+			c.offset += int64(len(p))
+			p = nil
+		}
+	}
+	return n, nil
+}
+
+func (c *cellCounter) count(data []byte) {
+	if c.bucketSize == 1 {
+		for _, b := range data {
+			c.nonZero += uint64(bits.OnesCount8(b))
+		}
+		return
+	}
+
+	mask := uint64(1)<<c.bucketSize - 1
+	for _, b := range data {
+		c.acc |= uint64(b) << c.accBits
+		c.accBits += 8
+		for c.accBits >= c.bucketSize {
+			if c.acc&mask != 0 {
+				c.nonZero++
+			}
+			c.acc >>= c.bucketSize
+			c.accBits -= c.bucketSize
+		}
+	}
+}
+
+// Progress прогресс загрузки фильтра из источника.
+type Progress struct {
+	Source string `json:"source"`
+	// Format формат записей источника (lines, csv, ndjson)
+	Format string `json:"format"`
+	// Total растёт по мере того, как начинается чтение очередного файла
+	Total   int64 `json:"total"`
+	Scanned int64 `json:"scanned"`
+	Added   int64 `json:"added"`
+	// Malformed пропущенные записи, из которых не получилось достать ключ
+	Malformed int64 `json:"malformed"`
+	// Rejected пропущенные записи, которые не прошли правила проверки значений
+	Rejected int64          `json:"rejected"`
+	Started  time.Time      `json:"started"`
+	Done     bool           `json:"done"`
+	Files    []FileProgress `json:"files,omitempty"`
+}
+
+// FileProgress прогресс одного файла источника.
+type FileProgress struct {
+	Source    string `json:"source"`
+	Total     int64  `json:"total"`
+	Scanned   int64  `json:"scanned"`
+	Added     int64  `json:"added"`
+	Malformed int64  `json:"malformed"`
+	Rejected  int64  `json:"rejected"`
+	Done      bool   `json:"done"`
+}
+
+// counters счётчики загрузки: общие и одного файла.
+type counters struct {
+	total     atomic.Int64
+	scanned   atomic.Int64
+	added     atomic.Int64
+	malformed atomic.Int64
+	rejected  atomic.Int64
+	done      atomic.Bool
+}
+
+type progress struct {
+	counters
+	source  string
+	format  string
+	started time.Time
+	files   []*fileProgress
+}
+
+type fileProgress struct {
+	counters
+	source string
+	// stat размер и время изменения файла на начало чтения, пусто - stdin или ошибка
+	stat ingestedFile
+	// offset и records позиция в файле, до которой ключи уже в фильтре; failed - чтение прервала ошибка.
+	// Меняются только горутиной загрузки.
+	offset  int64
+	records int64
+	failed  bool
+}
+
+var (
+	progressMux sync.Mutex
+	// progresses прогресс загрузки по пути дампа фильтра
+	progresses = map[string]*progress{}
+)
+
+func startProgress(dumpFilepath, source, format string, files []string) *progress {
+	p := &progress{source: source, format: format, started: time.Now()}
+	for _, file := range files {
+		p.files = append(p.files, &fileProgress{source: file})
+	}
+	progressMux.Lock()
+	progresses[dumpFilepath] = p
+	progressMux.Unlock()
+	return p
+}
+
+// BootstrapProgress возвращает прогресс последней загрузки из источника фильтра с дампом dumpFilepath.
+func BootstrapProgress(dumpFilepath string) (Progress, bool) {
+	progressMux.Lock()
+	p, ok := progresses[dumpFilepath]
+	progressMux.Unlock()
+	if !ok {
+		return Progress{}, false
+	}
+
+	result := Progress{
+		Source:    p.source,
+		Format:    p.format,
+		Total:     p.total.Load(),
+		Scanned:   p.scanned.Load(),
+		Added:     p.added.Load(),
+		Malformed: p.malformed.Load(),
+		Rejected:  p.rejected.Load(),
+		Started:   p.started,
+		Done:      p.done.Load(),
+	}
+	for _, file := range p.files {
+		result.Files = append(result.Files, FileProgress{
+			Source:    file.source,
+			Total:     file.total.Load(),
+			Scanned:   file.scanned.Load(),
+			Added:     file.added.Load(),
+			Malformed: file.malformed.Load(),
+			Rejected:  file.rejected.Load(),
+			Done:      file.done.Load(),
+		})
+	}
+	return result, true
+}
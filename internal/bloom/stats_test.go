@@ -0,0 +1,60 @@
+package bloom
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	boom "github.com/tylertreat/BoomFilters"
+)
+
+func TestStatsFillRatio(t *testing.T) {
+	t.Parallel()
+
+	classic := &ClassicBloomFilter{CBF: boom.NewBloomFilter(10_000, 0.01)}
+	stable := &StableBloomFilter{SBF: boom.NewStableBloomFilter(50_000, 3, 0.01)}
+	for i := 0; i < 5_000; i++ {
+		value := []byte(fmt.Sprintf("test_%d", i))
+		classic.CBF.Add(value)
+		stable.SBF.Add(value)
+	}
+
+	stats := classic.Stats()
+	if want := classic.CBF.FillRatio(); stats.FillRatio != want {
+		t.Errorf("classic FillRatio = %f, want %f", stats.FillRatio, want)
+	}
+	if stats.Count != 5_000 {
+		t.Errorf("classic Count = %d, want 5000", stats.Count)
+	}
+
+	stats = stable.Stats()
+	if want := stableFillRatio(t, stable.SBF); stats.FillRatio != want {
+		t.Errorf("stable FillRatio = %f, want %f", stats.FillRatio, want)
+	}
+	if stats.FillRatio == 0 || stats.MemoryBytes != bucketsDataSize(50_000, 3) {
+		t.Errorf("stable stats = %+v", stats)
+	}
+}
+
+// stableFillRatio доля ненулевых ячеек через boom.Buckets из дампа.
+func stableFillRatio(t *testing.T, sbf *boom.StableBloomFilter) float64 {
+	t.Helper()
+	var dump bytes.Buffer
+	if _, err := sbf.WriteTo(&dump); err != nil {
+		t.Fatal(err)
+	}
+
+	buckets := &boom.Buckets{}
+	header := 3*8 + 1 + 8 + 8*int(sbf.K())
+	if _, err := buckets.ReadFrom(bytes.NewReader(dump.Bytes()[header:])); err != nil {
+		t.Fatal(err)
+	}
+
+	nonZero := 0
+	for i := uint(0); i < buckets.Count(); i++ {
+		if buckets.Get(i) != 0 {
+			nonZero++
+		}
+	}
+	return float64(nonZero) / float64(buckets.Count())
+}
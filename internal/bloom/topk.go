@@ -0,0 +1,234 @@
+package bloom
+
+import (
+	"bytes"
+	"cmp"
+	"container/heap"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/rs/zerolog"
+	boom "github.com/tylertreat/BoomFilters"
+
+	"bloom-du/internal/utils"
+)
+
+const (
+	// topKEpsilon, topKDelta Count-Min Sketch топа: ширина 27 183, глубина 7, ~1.5 МБ.
+	topKEpsilon = 0.0001
+	topKDelta   = 0.001
+	topKSuffix  = ".topk"
+	// topKMaxValueLen защита от битого дампа при чтении длины значения.
+	topKMaxValueLen = 1 << 20
+)
+
+// Frequent значение из топа и оценка количества его повторов.
+type Frequent struct {
+	Value string `json:"value"`
+	Count uint64 `json:"count"`
+}
+
+// TopKFilter топ значений, которые чаще всего приходят повторно: TestAndAdd, вернувший false (дубль),
+// добавляет значение в топ. Add и загрузка из источника топ не меняют. Сохраняется рядом с дампом фильтра.
+type TopKFilter struct {
+	Filter
+	topk           *topK
+	mux            sync.Mutex
+	dumpFilepath   string
+	needCheckpoint bool
+}
+
+func withTopK(filter Filter, k uint, checkpointPath string) *TopKFilter {
+	f := &TopKFilter{
+		Filter:       filter,
+		topk:         newTopK(k),
+		dumpFilepath: checkpointPath + topKSuffix,
+	}
+	if fileExists(f.dumpFilepath) {
+		if err := readDump(f.dumpFilepath, f.topk); err != nil {
+			f.topk = newTopK(k)
+			f.LogCh() <- LogEvent{Level: zerolog.ErrorLevel, Name: bootstrapName, Msg: fmt.Sprintf("Load Top-K err: %v", err)}
+		}
+	}
+	return f
+}
+
+func (f *TopKFilter) Unwrap() Filter {
+	return f.Filter
+}
+
+func (f *TopKFilter) TestAndAdd(value string) bool {
+	added := f.Filter.TestAndAdd(value)
+	if !added {
+		f.mux.Lock()
+		f.topk.add([]byte(value))
+		f.needCheckpoint = true
+		f.mux.Unlock()
+	}
+	return added
+}
+
+// Top n самых частых дублей по убыванию, n <= 0 или больше k - весь топ.
+func (f *TopKFilter) Top(n int) []Frequent {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	return f.topk.top(n)
+}
+
+func (f *TopKFilter) GetDumpSize() uint64 {
+	return f.Filter.GetDumpSize() + getDumpSize(f.dumpFilepath)
+}
+
+// Checkpoint сохраняет фильтр, затем топ.
+func (f *TopKFilter) Checkpoint() (bool, error) {
+	saved, err := f.Filter.Checkpoint()
+
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	if !f.needCheckpoint {
+		return saved, err
+	}
+	if topErr := utils.WriteDump(f.dumpFilepath, f.topk); topErr != nil {
+		return saved, errors.Join(err, fmt.Errorf("top-k: %w", topErr))
+	}
+	f.needCheckpoint = false
+	return true, err
+}
+
+// topK повторяет boom.TopK (Count-Min Sketch + min-heap на k элементов), но умеет сохраняться:
+// у boom.TopK нет сериализации, а его CountMinSketch не экспортирован.
+type topK struct {
+	cms      *boom.CountMinSketch
+	k        uint
+	elements elementHeap
+}
+
+func newTopK(k uint) *topK {
+	return &topK{
+		cms:      boom.NewCountMinSketch(topKEpsilon, topKDelta),
+		k:        k,
+		elements: make(elementHeap, 0, k),
+	}
+}
+
+func (t *topK) add(data []byte) {
+	t.cms.Add(data)
+	freq := t.cms.Count(data)
+	if t.elements.Len() >= int(t.k) && freq < t.elements[0].Freq {
+		return
+	}
+
+	for i, element := range t.elements {
+		if bytes.Equal(data, element.Data) {
+			element.Freq = freq
+			heap.Fix(&t.elements, i)
+			return
+		}
+	}
+	heap.Push(&t.elements, &boom.Element{Data: slices.Clone(data), Freq: freq})
+	if t.elements.Len() > int(t.k) {
+		heap.Pop(&t.elements)
+	}
+}
+
+func (t *topK) top(n int) []Frequent {
+	result := make([]Frequent, 0, t.elements.Len())
+	for _, element := range t.elements {
+		result = append(result, Frequent{Value: string(element.Data), Count: element.Freq})
+	}
+	slices.SortFunc(result, func(a, b Frequent) int {
+		if a.Count != b.Count {
+			return cmp.Compare(b.Count, a.Count)
+		}
+		return strings.Compare(a.Value, b.Value)
+	})
+	if n > 0 && n < len(result) {
+		result = result[:n]
+	}
+	return result
+}
+
+// WriteTo Count-Min Sketch, затем количество элементов и по каждому: freq, len(data), data (BigEndian).
+func (t *topK) WriteTo(stream io.Writer) (int64, error) {
+	n, err := t.cms.WriteDataTo(stream)
+	total := int64(n)
+	if err != nil {
+		return total, err
+	}
+
+	if err = binary.Write(stream, binary.BigEndian, uint64(t.elements.Len())); err != nil {
+		return total, err
+	}
+	total += 8
+	for _, element := range t.elements {
+		if err = binary.Write(stream, binary.BigEndian, [2]uint64{element.Freq, uint64(len(element.Data))}); err != nil {
+			return total, err
+		}
+		written, err := stream.Write(element.Data)
+		total += 16 + int64(written)
+		if err != nil {
+			return total, err
+		}
+	}
+	return total, nil
+}
+
+// ReadFrom читает дамп WriteTo. Если в дампе больше k элементов (k уменьшили), остаются самые частые.
+func (t *topK) ReadFrom(stream io.Reader) (int64, error) {
+	n, err := t.cms.ReadDataFrom(stream)
+	total := int64(n)
+	if err != nil {
+		return total, err
+	}
+
+	var count uint64
+	if err = binary.Read(stream, binary.BigEndian, &count); err != nil {
+		return total, err
+	}
+	total += 8
+	t.elements = t.elements[:0]
+	for range count {
+		var header [2]uint64
+		if err = binary.Read(stream, binary.BigEndian, &header); err != nil {
+			return total, err
+		}
+		if header[1] > topKMaxValueLen {
+			return total, fmt.Errorf("top-k value length %d is too large", header[1])
+		}
+		data := make([]byte, header[1])
+		read, err := io.ReadFull(stream, data)
+		total += 16 + int64(read)
+		if err != nil {
+			return total, err
+		}
+		heap.Push(&t.elements, &boom.Element{Data: data, Freq: header[0]})
+		if t.elements.Len() > int(t.k) {
+			heap.Pop(&t.elements)
+		}
+	}
+	return total, nil
+}
+
+// elementHeap min-heap по частоте, как в boom.TopK.
+type elementHeap []*boom.Element
+
+func (e elementHeap) Len() int           { return len(e) }
+func (e elementHeap) Less(i, j int) bool { return e[i].Freq < e[j].Freq }
+func (e elementHeap) Swap(i, j int)      { e[i], e[j] = e[j], e[i] }
+
+func (e *elementHeap) Push(x any) {
+	*e = append(*e, x.(*boom.Element))
+}
+
+func (e *elementHeap) Pop() any {
+	old := *e
+	n := len(old)
+	x := old[n-1]
+	*e = old[:n-1]
+	return x
+}
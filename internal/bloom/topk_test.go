@@ -0,0 +1,58 @@
+package bloom
+
+import (
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestTopKFilter(t *testing.T) {
+	t.Parallel()
+
+	logCh := make(chan LogEvent, 1000)
+	go func() {
+		for range logCh {
+		}
+	}()
+	dumpPath := filepath.Join(t.TempDir(), "phones.cms")
+	opts := Options{TopK: 2, Cardinality: true}
+	filter, err := MakeEngine(CountMinSketch, nil, false, logCh, dumpPath, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// первое добавление - не дубль, в топ попадают только повторы
+	for value, times := range map[string]int{"spammer": 6, "noisy": 4, "rare": 2, "once": 1} {
+		for range times {
+			filter.TestAndAdd(value)
+		}
+	}
+	want := []Frequent{{Value: "spammer", Count: 5}, {Value: "noisy", Count: 3}}
+	topk, ok := AsTopK(filter)
+	if !ok {
+		t.Fatal("topk must be enabled")
+	}
+	if got := topk.Top(0); !slices.Equal(got, want) {
+		t.Fatalf("Top = %v, want %v", got, want)
+	}
+	if got := topk.Top(1); !slices.Equal(got, want[:1]) {
+		t.Fatalf("Top(1) = %v, want %v", got, want[:1])
+	}
+	if _, ok = AsCounter(filter); !ok {
+		t.Fatal("countmin under topk must stay a Counter")
+	}
+
+	if _, err = filter.Checkpoint(); err != nil {
+		t.Fatalf("checkpoint: %v", err)
+	}
+	restored, err := MakeEngine(CountMinSketch, nil, false, logCh, dumpPath, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	topk, _ = AsTopK(restored)
+	restored.TestAndAdd("noisy")
+	want[1].Count++
+	if got := topk.Top(0); !slices.Equal(got, want) {
+		t.Fatalf("restored Top = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,195 @@
+package bloom
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Правила проверки значений, имя правила возвращается в ValidationError.
+const (
+	RuleMinLen  = "min_len"
+	RuleMaxLen  = "max_len"
+	RulePattern = "pattern"
+	RuleCharset = "charset"
+	RuleMaxBulk = "max_bulk"
+)
+
+// charsets допустимые символы значения (ValidationRules.Charset).
+var charsets = map[string]func(rune) bool{
+	"digits":    func(r rune) bool { return r >= '0' && r <= '9' },
+	"hex":       func(r rune) bool { return strings.ContainsRune("0123456789abcdefABCDEF", r) },
+	"alnum":     func(r rune) bool { return unicode.IsLetter(r) || unicode.IsDigit(r) },
+	"ascii":     func(r rune) bool { return r < utf8.RuneSelf },
+	"printable": unicode.IsPrint,
+}
+
+// ValidationRules правила для значений фильтра, нулевое поле - правило не проверяется.
+// Длина в символах, проверяется значение после нормализации.
+type ValidationRules struct {
+	MinLen int
+	MaxLen int
+	// Pattern регулярное выражение, которому должно соответствовать всё значение
+	Pattern string
+	// Charset допустимые символы: digits, hex, alnum, ascii, printable
+	Charset string
+	// MaxBulk сколько значений можно передать в одном /api/bulk
+	MaxBulk int
+}
+
+// ValidationError значение не прошло правило Rule.
+type ValidationError struct {
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+func (e *ValidationError) Error() string {
+	return e.Rule + ": " + e.Message
+}
+
+// Validator проверяет значения по ValidationRules, nil - все значения подходят.
+type Validator struct {
+	rules   ValidationRules
+	pattern *regexp.Regexp
+	charset func(rune) bool
+}
+
+// NewValidator собирает Validator, nil - если правил нет.
+func NewValidator(rules ValidationRules) (*Validator, error) {
+	if rules == (ValidationRules{}) {
+		return nil, nil
+	}
+	if rules.MinLen < 0 || rules.MaxLen < 0 || rules.MaxBulk < 0 {
+		return nil, fmt.Errorf("value_min_len, value_max_len and max_bulk must not be negative")
+	}
+	if rules.MaxLen > 0 && rules.MinLen > rules.MaxLen {
+		return nil, fmt.Errorf("value_min_len %d is greater than value_max_len %d", rules.MinLen, rules.MaxLen)
+	}
+
+	v := &Validator{rules: rules}
+	if rules.Pattern != "" {
+		pattern, err := regexp.Compile(`^(?:` + rules.Pattern + `)$`)
+		if err != nil {
+			return nil, fmt.Errorf("value_pattern: %w", err)
+		}
+		v.pattern = pattern
+	}
+	if rules.Charset != "" {
+		charset, ok := charsets[rules.Charset]
+		if !ok {
+			return nil, fmt.Errorf("unknown value_charset `%s`: digits, hex, alnum, ascii or printable", rules.Charset)
+		}
+		v.charset = charset
+	}
+	return v, nil
+}
+
+// Validate первое правило, которое не прошло значение, или nil.
+func (v *Validator) Validate(value string) error {
+	if v == nil {
+		return nil
+	}
+	length := utf8.RuneCountInString(value)
+	switch {
+	case length < v.rules.MinLen:
+		return &ValidationError{Rule: RuleMinLen, Message: fmt.Sprintf("value length must be >= %d", v.rules.MinLen)}
+	case v.rules.MaxLen > 0 && length > v.rules.MaxLen:
+		return &ValidationError{Rule: RuleMaxLen, Message: fmt.Sprintf("value length must be <= %d", v.rules.MaxLen)}
+	}
+	if v.charset != nil {
+		for _, r := range value {
+			if !v.charset(r) {
+				return &ValidationError{Rule: RuleCharset, Message: fmt.Sprintf("value contains %q outside of %s", r, v.rules.Charset)}
+			}
+		}
+	}
+	if v.pattern != nil && !v.pattern.MatchString(value) {
+		return &ValidationError{Rule: RulePattern, Message: fmt.Sprintf("value must match %s", v.rules.Pattern)}
+	}
+	return nil
+}
+
+// ValidateBulk проверяет количество значений в одном запросе.
+func (v *Validator) ValidateBulk(n int) error {
+	if v == nil || v.rules.MaxBulk == 0 || n <= v.rules.MaxBulk {
+		return nil
+	}
+	return &ValidationError{Rule: RuleMaxBulk, Message: fmt.Sprintf("bulk size must be <= %d", v.rules.MaxBulk)}
+}
+
+// ValidatedFilter не пропускает к движку значения, которые не прошли правила: они не добавляются
+// и не находятся. Стоит под NormalizedFilter, поэтому проверяет уже нормализованное значение.
+type ValidatedFilter struct {
+	Filter
+	validator *Validator
+}
+
+func withValidator(filter Filter, validator *Validator) *ValidatedFilter {
+	return &ValidatedFilter{Filter: filter, validator: validator}
+}
+
+func (f *ValidatedFilter) Unwrap() Filter {
+	return f.Filter
+}
+
+func (f *ValidatedFilter) Add(value string) {
+	if f.validator.Validate(value) == nil {
+		f.Filter.Add(value)
+	}
+}
+
+func (f *ValidatedFilter) Test(value string) bool {
+	return f.validator.Validate(value) == nil && f.Filter.Test(value)
+}
+
+func (f *ValidatedFilter) TestAndAdd(value string) bool {
+	return f.validator.Validate(value) == nil && f.Filter.TestAndAdd(value)
+}
+
+// Incr Counter поверх внутреннего фильтра, см. AsCounter.
+func (f *ValidatedFilter) Incr(value string, delta uint64) uint64 {
+	counter, _ := AsCounter(f.Filter)
+	if f.validator.Validate(value) != nil {
+		return 0
+	}
+	return counter.Incr(value, delta)
+}
+
+func (f *ValidatedFilter) Count(value string) uint64 {
+	counter, _ := AsCounter(f.Filter)
+	if f.validator.Validate(value) != nil {
+		return 0
+	}
+	return counter.Count(value)
+}
+
+// Remove Remover поверх внутреннего фильтра, см. AsRemover.
+func (f *ValidatedFilter) Remove(value string) bool {
+	remover, _ := AsRemover(f.Filter)
+	return f.validator.Validate(value) == nil && remover.Remove(value)
+}
+
+// ValidateValue проверяет значение так же, как фильтр: после нормализации и по его правилам.
+// API вызывает её до операции, чтобы вернуть причину, а не молча не добавить значение.
+func ValidateValue(filter Filter, value string) error {
+	if normalized, ok := as[*NormalizedFilter](filter); ok {
+		value = normalized.normalizer.Normalize(value)
+		if value == "" {
+			return &ValidationError{Rule: RuleMinLen, Message: "value is empty after normalization"}
+		}
+	}
+	if validated, ok := as[*ValidatedFilter](filter); ok {
+		return validated.validator.Validate(value)
+	}
+	return nil
+}
+
+// ValidateBulk проверяет размер /api/bulk по правилам фильтра.
+func ValidateBulk(filter Filter, n int) error {
+	if validated, ok := as[*ValidatedFilter](filter); ok {
+		return validated.validator.ValidateBulk(n)
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+package bloom
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestValidator(t *testing.T) {
+	t.Parallel()
+
+	validator, err := NewValidator(ValidationRules{MinLen: 2, MaxLen: 11, Charset: "digits", Pattern: `7\d+`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		value string
+		rule  string // пусто - значение проходит
+	}{
+		{"79991110011", ""},
+		{"75", ""},
+		{"7", RuleMinLen},
+		{"799911100110", RuleMaxLen},
+		{"7999-111", RuleCharset},
+		{"89991110011", RulePattern},
+	}
+	for _, tt := range tests {
+		err := validator.Validate(tt.value)
+		var invalid *ValidationError
+		switch {
+		case tt.rule == "" && err != nil:
+			t.Errorf("Validate(%s) = %v, want nil", tt.value, err)
+		case tt.rule != "" && (!errors.As(err, &invalid) || invalid.Rule != tt.rule):
+			t.Errorf("Validate(%s) = %v, want rule %s", tt.value, err, tt.rule)
+		}
+	}
+
+	// значение длиной ровно value_min_len проходит
+	if validator, _ = NewValidator(ValidationRules{MinLen: 2}); validator.Validate("ab") != nil || validator.Validate("я") == nil {
+		t.Error("min_len must count characters and accept length equal to it")
+	}
+	for _, rules := range []ValidationRules{{MinLen: 5, MaxLen: 3}, {Charset: "latin"}, {Pattern: "("}, {MaxBulk: -1}} {
+		if _, err = NewValidator(rules); err == nil {
+			t.Errorf("NewValidator(%+v) must fail", rules)
+		}
+	}
+}
+
+func TestValidatedFilter(t *testing.T) {
+	t.Parallel()
+
+	logCh := make(chan LogEvent)
+	go func() {
+		for range logCh {
+		}
+	}()
+	dir := t.TempDir()
+	source := filepath.Join(dir, "source.txt")
+	if err := os.WriteFile(source, []byte("8 (999) 111-00-11\n+7 999 111-00-12\n12\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	normalizer, _ := ParseNormalizer([]string{"digits", `s/^8/7/`})
+	validator, _ := NewValidator(ValidationRules{MinLen: 11, MaxLen: 11, MaxBulk: 2})
+	checkpointPath := filepath.Join(dir, "filter.bloom")
+	opts := Options{Window: 3600e9, Generations: 1, Capacity: 1_000, Normalize: normalizer, Validator: validator}
+	filter, err := MakeEngine(RotatingBloom, []string{source}, false, logCh, checkpointPath, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if progress, _ := BootstrapProgress(checkpointPath); progress.Added != 2 || progress.Rejected != 1 {
+		t.Errorf("progress = %+v, want 2 added and 1 rejected", progress)
+	}
+	if !filter.Test("79991110011") || !filter.Test("+7 (999) 111-00-12") {
+		t.Error("normalized source values must be found")
+	}
+	if ValidateValue(filter, "8-999-111-00-13") != nil || ValidateValue(filter, "8-999") == nil {
+		t.Error("ValidateValue must check the normalized value")
+	}
+	if filter.TestAndAdd("12345") || filter.Test("12345") {
+		t.Error("value rejected by rules must not be added")
+	}
+	if ValidateBulk(filter, 2) != nil || ValidateBulk(filter, 3) == nil {
+		t.Error("ValidateBulk must check max_bulk")
+	}
+}
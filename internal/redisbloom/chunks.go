@@ -0,0 +1,129 @@
+package redisbloom
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// MaxChunkSize максимальный размер чанка данных, как MAX_SCANDUMP_SIZE в RedisBloom.
+const MaxChunkSize = 16 * 1024 * 1024
+
+// headerIter итератор чанка с заголовком фильтра.
+const headerIter = 1
+
+// Chunk пара (iterator, data) из ответа BF.SCANDUMP, которую нужно передать в BF.LOADCHUNK как есть.
+type Chunk struct {
+	Iter int64
+	Data []byte
+}
+
+// dumpedChainHeader заголовок SBChain (sb.c), упакованный, little-endian. За ним nfilters звеньев.
+type dumpedChainHeader struct {
+	Size     uint64
+	NFilters uint32
+	Options  uint32
+	Growth   uint32
+}
+
+// dumpedChainLink описание одного звена SBChain (sb.c), упакованное, little-endian.
+type dumpedChainLink struct {
+	Bytes   uint64
+	Bits    uint64
+	Size    uint64
+	Error   float64
+	Bpe     float64
+	Hashes  uint32
+	Entries uint64
+	N2      uint8
+}
+
+// Chunks кодирует фильтр так же, как последовательные вызовы BF.SCANDUMP:
+// сначала заголовок (iter = 1), затем данные частями не больше maxSize.
+func (f *Filter) Chunks(maxSize int) []Chunk {
+	if maxSize <= 0 {
+		maxSize = MaxChunkSize
+	}
+
+	var header bytes.Buffer
+	_ = binary.Write(&header, binary.LittleEndian, dumpedChainHeader{
+		Size:     f.count,
+		NFilters: 1,
+		Options:  f.options,
+		Growth:   f.growth,
+	})
+	_ = binary.Write(&header, binary.LittleEndian, dumpedChainLink{
+		Bytes:   uint64(len(f.data)),
+		Bits:    f.bits,
+		Size:    f.count,
+		Error:   f.errRate,
+		Bpe:     f.bpe,
+		Hashes:  f.hashes,
+		Entries: f.entries,
+		N2:      f.n2,
+	})
+
+	chunks := []Chunk{{Iter: headerIter, Data: header.Bytes()}}
+	iter := int64(headerIter)
+	for offset := 0; offset < len(f.data); offset += maxSize {
+		end := min(offset+maxSize, len(f.data))
+		iter += int64(end - offset)
+		chunks = append(chunks, Chunk{Iter: iter, Data: f.data[offset:end]})
+	}
+
+	return chunks
+}
+
+// FromChunks собирает фильтр из чанков BF.SCANDUMP. Поддерживаются только фильтры из одного звена
+// с 64-битным хешированием (по умолчанию для BF.RESERVE в RedisBloom 2.x).
+func FromChunks(chunks []Chunk) (*Filter, error) {
+	if len(chunks) == 0 || chunks[0].Iter != headerIter {
+		return nil, errors.New("first chunk must be a header (iterator 1)")
+	}
+
+	reader := bytes.NewReader(chunks[0].Data)
+	var header dumpedChainHeader
+	if err := binary.Read(reader, binary.LittleEndian, &header); err != nil {
+		return nil, fmt.Errorf("read header: %w", err)
+	}
+	if header.NFilters != 1 {
+		return nil, fmt.Errorf("scaled filters are not supported: %d links, expected 1", header.NFilters)
+	}
+	if header.Options&OptForce64 == 0 {
+		return nil, fmt.Errorf("32-bit hashing is not supported (options: %d)", header.Options)
+	}
+
+	var link dumpedChainLink
+	if err := binary.Read(reader, binary.LittleEndian, &link); err != nil {
+		return nil, fmt.Errorf("read link: %w", err)
+	}
+
+	f := &Filter{
+		options: header.Options,
+		growth:  header.Growth,
+		entries: link.Entries,
+		errRate: link.Error,
+		bpe:     link.Bpe,
+		hashes:  link.Hashes,
+		bits:    link.Bits,
+		n2:      link.N2,
+		count:   link.Size,
+		data:    make([]byte, link.Bytes),
+	}
+
+	loaded := uint64(0)
+	for _, chunk := range chunks[1:] {
+		offset := chunk.Iter - int64(len(chunk.Data)) - 1
+		if offset < 0 || uint64(offset)+uint64(len(chunk.Data)) > link.Bytes {
+			return nil, fmt.Errorf("chunk out of range: iterator %d, %d bytes", chunk.Iter, len(chunk.Data))
+		}
+		copy(f.data[offset:], chunk.Data)
+		loaded += uint64(len(chunk.Data))
+	}
+	if loaded != link.Bytes {
+		return nil, fmt.Errorf("incomplete dump: loaded %d of %d bytes", loaded, link.Bytes)
+	}
+
+	return f, nil
+}
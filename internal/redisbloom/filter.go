@@ -0,0 +1,263 @@
+// Package redisbloom классический фильтр Блума, побитово совместимый с RedisBloom (BF.*),
+// и конвертация в формат BF.SCANDUMP / BF.LOADCHUNK.
+package redisbloom
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/bits"
+	"os"
+
+	"bloom-du/internal/utils"
+)
+
+// Опции фильтра RedisBloom (bloom.h).
+const (
+	OptNoRound    = 1
+	OptEntsIsBits = 2
+	OptForce64    = 4
+	OptNoScaling  = 8
+
+	defaultGrowth = 2
+)
+
+// Filter одно звено (link) SBChain RedisBloom. Масштабирование (добавление звеньев) не поддерживается,
+// что соответствует BF.RESERVE ... NONSCALING.
+type Filter struct {
+	options uint32
+	growth  uint32
+
+	entries uint64  // ёмкость
+	errRate float64 // желаемый fpRate
+	bpe     float64 // бит на элемент
+	hashes  uint32
+	bits    uint64
+	n2      uint8 // если > 0, количество бит округлено до 2^n2
+	count   uint64
+
+	data []byte
+}
+
+// DumpHeaderSize размер заголовка дампа bloom-du (WriteTo) перед битовым массивом.
+const DumpHeaderSize = 65
+
+// dumpMagic сигнатура дампа, чтобы не спутать его с дампами фильтров BoomFilters.
+var dumpMagic = [4]byte{'R', 'B', 'F', '1'}
+
+var ErrNotRedisDump = errors.New("not a RedisBloom compatible dump (engine `redis`)")
+
+// ErrClassicDump дамп движка classic: биты в нём выставлены хешем FNV-1 BoomFilters, а RedisBloom ищет
+// по MurmurHash64A. Самих значений в дампе нет, поэтому пересчитать биты под RedisBloom нельзя.
+var ErrClassicDump = errors.New("classic engine dump cannot be converted to RedisBloom: " +
+	"its bits are set by a different hash function and the dump does not keep the values; " +
+	"rebuild the filter from its source with --engine=redis and export the new dump")
+
+// classicHeaderSize заголовок дампа classic (BoomFilters): count, m, k, затем Buckets: bucketSize, max, count, len.
+const classicHeaderSize = 3*8 + 2 + 2*8
+
+// New создаёт фильтр, как BF.RESERVE key errorRate capacity NONSCALING.
+func New(capacity uint64, errorRate float64) *Filter {
+	bitCount, hashes := Size(capacity, errorRate)
+
+	return &Filter{
+		options: OptNoRound | OptForce64 | OptNoScaling,
+		growth:  defaultGrowth,
+		entries: capacity,
+		errRate: errorRate,
+		bpe:     bitsPerEntry(errorRate),
+		hashes:  hashes,
+		bits:    bitCount,
+		data:    make([]byte, DataSize(bitCount)),
+	}
+}
+
+// Size возвращает размер битового массива и количество хеш-функций (bloom_init в RedisBloom).
+func Size(capacity uint64, errorRate float64) (uint64, uint32) {
+	bpe := bitsPerEntry(errorRate)
+	bitCount := uint64(float64(capacity) * bpe)
+	if bitCount == 0 {
+		bitCount = 1
+	}
+	return bitCount, uint32(math.Ceil(math.Ln2 * bpe))
+}
+
+// DataSize размер битового массива в байтах, выровненный до 64 бит.
+func DataSize(bitCount uint64) uint64 {
+	return (bitCount + 63) / 64 * 8
+}
+
+func bitsPerEntry(errorRate float64) float64 {
+	return -math.Log(errorRate) / (math.Ln2 * math.Ln2)
+}
+
+// Capacity возвращает ёмкость фильтра (количество элементов).
+func (f *Filter) Capacity() uint64 {
+	return f.entries
+}
+
+// Bits возвращает размер битового массива.
+func (f *Filter) Bits() uint64 {
+	return f.bits
+}
+
+// K возвращает количество хеш-функций.
+func (f *Filter) K() uint32 {
+	return f.hashes
+}
+
+// Count возвращает количество добавленных элементов.
+func (f *Filter) Count() uint64 {
+	return f.count
+}
+
+// ErrorRate возвращает желаемый fpRate.
+func (f *Filter) ErrorRate() float64 {
+	return f.errRate
+}
+
+// FillRatio доля установленных бит.
+func (f *Filter) FillRatio() float64 {
+	set := 0
+	for _, b := range f.data {
+		set += bits.OnesCount8(b)
+	}
+	return float64(set) / float64(f.modulus())
+}
+
+// EstimatedFillRatio оценка доли установленных бит по количеству элементов.
+func (f *Filter) EstimatedFillRatio() float64 {
+	return 1 - math.Exp(-float64(f.count)*float64(f.hashes)/float64(f.modulus()))
+}
+
+func (f *Filter) modulus() uint64 {
+	if f.n2 > 0 {
+		return 1 << f.n2
+	}
+	return f.bits
+}
+
+func (f *Filter) index(a, b uint64, i uint32) uint64 {
+	x := a + uint64(i)*b
+	if f.n2 > 0 {
+		return x & (1<<f.n2 - 1)
+	}
+	return x % f.bits
+}
+
+func (f *Filter) Test(data []byte) bool {
+	a, b := hash64(data)
+	for i := uint32(0); i < f.hashes; i++ {
+		x := f.index(a, b, i)
+		if f.data[x>>3]&(1<<(x%8)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func (f *Filter) Add(data []byte) {
+	f.TestAndAdd(data)
+}
+
+// TestAndAdd возвращает true, если элемент уже был в фильтре (как BF.ADD возвращает 0).
+func (f *Filter) TestAndAdd(data []byte) bool {
+	a, b := hash64(data)
+	found := true
+	for i := uint32(0); i < f.hashes; i++ {
+		x := f.index(a, b, i)
+		mask := byte(1 << (x % 8))
+		if f.data[x>>3]&mask == 0 {
+			found = false
+			f.data[x>>3] |= mask
+		}
+	}
+	if !found {
+		f.count++
+	}
+	return found
+}
+
+// WriteTo сохраняет фильтр в формате дампа bloom-du.
+func (f *Filter) WriteTo(stream io.Writer) (int64, error) {
+	header := []any{
+		dumpMagic, f.options, f.growth, f.entries, f.errRate, f.bpe, f.hashes, f.bits, f.n2, f.count, uint64(len(f.data)),
+	}
+	var written int64
+	for _, field := range header {
+		if err := binary.Write(stream, binary.BigEndian, field); err != nil {
+			return written, err
+		}
+		written += int64(binary.Size(field))
+	}
+
+	n, err := stream.Write(f.data)
+	return written + int64(n), err
+}
+
+// ReadFrom загружает фильтр из дампа bloom-du, записанного WriteTo.
+func (f *Filter) ReadFrom(stream io.Reader) (int64, error) {
+	var (
+		magic [4]byte
+		size  uint64
+	)
+	if err := binary.Read(stream, binary.BigEndian, &magic); err != nil {
+		return 0, err
+	}
+	if magic != dumpMagic {
+		return 0, ErrNotRedisDump
+	}
+
+	header := []any{
+		&f.options, &f.growth, &f.entries, &f.errRate, &f.bpe, &f.hashes, &f.bits, &f.n2, &f.count, &size,
+	}
+	read := int64(len(magic))
+	for _, field := range header {
+		if err := binary.Read(stream, binary.BigEndian, field); err != nil {
+			return read, err
+		}
+		read += int64(binary.Size(field))
+	}
+
+	f.data = make([]byte, size)
+	n, err := io.ReadFull(stream, f.data)
+	return read + int64(n), err
+}
+
+// LoadDump читает дамп bloom-du фильтра `redis`. Для дампа classic возвращает ErrClassicDump.
+func LoadDump(path string) (*Filter, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	if header, _ := reader.Peek(classicHeaderSize); isClassicDump(header) {
+		return nil, fmt.Errorf("%s: %w", path, ErrClassicDump)
+	}
+	f := &Filter{}
+	if _, err = f.ReadFrom(reader); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return f, nil
+}
+
+// isClassicDump заголовок дампа classic: buckets по одному биту, их столько же, сколько m.
+func isClassicDump(header []byte) bool {
+	if len(header) < classicHeaderSize {
+		return false
+	}
+	m, k := binary.BigEndian.Uint64(header[8:]), binary.BigEndian.Uint64(header[16:])
+	bucketSize, bucketMax := header[24], header[25]
+	buckets, size := binary.BigEndian.Uint64(header[26:]), binary.BigEndian.Uint64(header[34:])
+	return m > 0 && k > 0 && k <= 64 && bucketSize == 1 && bucketMax == 1 && buckets == m && size == (m+7)/8
+}
+
+// SaveDump записывает дамп bloom-du фильтра `redis` так же, как чекпоинт: через временный файл.
+func SaveDump(path string, f *Filter) error {
+	return utils.WriteDump(path, f)
+}
@@ -0,0 +1,65 @@
+package redisbloom
+
+import (
+	"encoding/binary"
+)
+
+const (
+	murmurM = 0xc6a4a7935bd1e995
+	murmurR = 47
+	// hashSeed начальное значение первого хеша в RedisBloom (bloom_calc_hash64).
+	hashSeed = 0xc6a4a7935bd1e995
+)
+
+// murmurHash64A MurmurHash2 64-bit (вариант A), как в RedisBloom (MurmurHash64A_Bloom).
+func murmurHash64A(data []byte, seed uint64) uint64 {
+	h := seed ^ (uint64(len(data)) * murmurM)
+
+	for len(data) >= 8 {
+		k := binary.LittleEndian.Uint64(data)
+		data = data[8:]
+
+		k *= murmurM
+		k ^= k >> murmurR
+		k *= murmurM
+
+		h ^= k
+		h *= murmurM
+	}
+
+	switch len(data) {
+	case 7:
+		h ^= uint64(data[6]) << 48
+		fallthrough
+	case 6:
+		h ^= uint64(data[5]) << 40
+		fallthrough
+	case 5:
+		h ^= uint64(data[4]) << 32
+		fallthrough
+	case 4:
+		h ^= uint64(data[3]) << 24
+		fallthrough
+	case 3:
+		h ^= uint64(data[2]) << 16
+		fallthrough
+	case 2:
+		h ^= uint64(data[1]) << 8
+		fallthrough
+	case 1:
+		h ^= uint64(data[0])
+		h *= murmurM
+	}
+
+	h ^= h >> murmurR
+	h *= murmurM
+	h ^= h >> murmurR
+
+	return h
+}
+
+// hash64 пара хешей (a, b), из которой RedisBloom получает все k индексов.
+func hash64(data []byte) (uint64, uint64) {
+	a := murmurHash64A(data, hashSeed)
+	return a, murmurHash64A(data, a)
+}
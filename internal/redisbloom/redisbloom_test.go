@@ -0,0 +1,218 @@
+package redisbloom
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	boom "github.com/tylertreat/BoomFilters"
+)
+
+func newTestFilter(t *testing.T) *Filter {
+	t.Helper()
+	f := New(10_000, 0.01)
+	for i := 0; i < 1_000; i++ {
+		f.Add([]byte(fmt.Sprintf("test_%d", i)))
+	}
+	return f
+}
+
+func assertMembers(t *testing.T, f *Filter) {
+	t.Helper()
+	for i := 0; i < 1_000; i++ {
+		if !f.Test([]byte(fmt.Sprintf("test_%d", i))) {
+			t.Fatalf("Expected test_%d to be a member", i)
+		}
+	}
+	if f.Count() != 1_000 {
+		t.Errorf("Expected count 1000, got %d", f.Count())
+	}
+}
+
+func TestTestAndAdd(t *testing.T) {
+	t.Parallel()
+	f := New(1_000, 0.01)
+	if f.TestAndAdd([]byte("value")) {
+		t.Error("Expected new value to be added")
+	}
+	if !f.TestAndAdd([]byte("value")) {
+		t.Error("Expected value to be already present")
+	}
+	if f.Count() != 1 {
+		t.Errorf("Expected count 1, got %d", f.Count())
+	}
+}
+
+func TestChunksRoundTrip(t *testing.T) {
+	t.Parallel()
+	f := newTestFilter(t)
+
+	chunks := f.Chunks(1000)
+	if len(chunks[0].Data) != 20+53 {
+		t.Errorf("Expected header of 73 bytes, got %d", len(chunks[0].Data))
+	}
+
+	restored, err := FromChunks(chunks)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertMembers(t, restored)
+
+	if _, err = FromChunks(chunks[:len(chunks)-1]); err == nil {
+		t.Error("Expected error for incomplete dump")
+	}
+}
+
+func TestDumpRoundTrip(t *testing.T) {
+	t.Parallel()
+	f := newTestFilter(t)
+
+	var buf bytes.Buffer
+	n, err := f.WriteTo(&buf)
+	if err != nil || n != int64(buf.Len()) || n != DumpHeaderSize+int64(len(f.data)) {
+		t.Fatalf("WriteTo: %d bytes, buffer %d, err %v", n, buf.Len(), err)
+	}
+
+	restored := &Filter{}
+	if _, err = restored.ReadFrom(&buf); err != nil {
+		t.Fatal(err)
+	}
+	assertMembers(t, restored)
+
+	if _, err = restored.ReadFrom(bytes.NewReader([]byte("garbage data"))); !errors.Is(err, ErrNotRedisDump) {
+		t.Errorf("Expected ErrNotRedisDump, got %v", err)
+	}
+}
+
+func TestLoadDump(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+
+	path := filepath.Join(dir, "redis.bloom")
+	if err := SaveDump(path, newTestFilter(t)); err != nil {
+		t.Fatal(err)
+	}
+	restored, err := LoadDump(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertMembers(t, restored)
+	if _, err = os.Stat(path + ".tmp"); !os.IsNotExist(err) {
+		t.Errorf("Expected no temporary file, got %v", err)
+	}
+
+	classic := boom.NewBloomFilter(1_000, 0.01)
+	classic.Add([]byte("test_1"))
+	var buf bytes.Buffer
+	if _, err = classic.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	path = filepath.Join(dir, "classic.bloom")
+	if err = os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = LoadDump(path); !errors.Is(err, ErrClassicDump) {
+		t.Errorf("Expected ErrClassicDump, got %v", err)
+	}
+}
+
+func TestCommandsRoundTrip(t *testing.T) {
+	t.Parallel()
+	f := newTestFilter(t)
+
+	var buf bytes.Buffer
+	if err := WriteCommands(&buf, "key", f.Chunks(500)); err != nil {
+		t.Fatal(err)
+	}
+
+	key, chunks, err := ReadCommands(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key != "key" {
+		t.Errorf("Expected key `key`, got %s", key)
+	}
+
+	restored, err := FromChunks(chunks)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertMembers(t, restored)
+}
+
+// fakeRedis отвечает на BF.SCANDUMP и BF.LOADCHUNK как RedisBloom, храня чанки в памяти.
+func fakeRedis(t *testing.T, conn net.Conn, stored []Chunk) {
+	t.Helper()
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	for {
+		reply, err := readReply(reader)
+		if err != nil {
+			return
+		}
+		args := reply.([]any)
+		switch string(args[0].([]byte)) {
+		case cmdScanDump:
+			// итератор 0 - заголовок, дальше следующий чанк после запрошенного итератора
+			var iter int64
+			_, _ = fmt.Sscan(string(args[2].([]byte)), &iter)
+			next := 0
+			if iter != 0 {
+				next = len(stored)
+				for i, chunk := range stored {
+					if chunk.Iter == iter {
+						next = i + 1
+					}
+				}
+			}
+			if next >= len(stored) {
+				_, _ = conn.Write([]byte("*2\r\n:0\r\n$0\r\n\r\n"))
+				continue
+			}
+			chunk := stored[next]
+			_, _ = fmt.Fprintf(conn, "*2\r\n:%d\r\n$%d\r\n%s\r\n", chunk.Iter, len(chunk.Data), chunk.Data)
+		case cmdLoadChunk:
+			_, _ = conn.Write([]byte("+OK\r\n"))
+		default:
+			_, _ = conn.Write([]byte("-ERR unknown command\r\n"))
+		}
+	}
+}
+
+func TestClient(t *testing.T) {
+	t.Parallel()
+	f := newTestFilter(t)
+	chunks := f.Chunks(4096)
+
+	server, conn := net.Pipe()
+	go fakeRedis(t, server, chunks)
+
+	client := &Client{conn: conn, reader: bufio.NewReader(conn)}
+	defer client.Close()
+
+	if err := client.LoadChunks("key", chunks); err != nil {
+		t.Fatal(err)
+	}
+
+	dumped, err := client.ScanDump("key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dumped) != len(chunks) {
+		t.Fatalf("Expected %d chunks, got %d", len(chunks), len(dumped))
+	}
+	restored, err := FromChunks(dumped)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertMembers(t, restored)
+
+	if _, err = client.Do("PING"); err == nil {
+		t.Error("Expected error reply for unknown command")
+	}
+}
@@ -0,0 +1,216 @@
+package redisbloom
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"time"
+)
+
+const (
+	cmdScanDump  = "BF.SCANDUMP"
+	cmdLoadChunk = "BF.LOADCHUNK"
+)
+
+// Client минимальный клиент Redis (RESP2), достаточный для BF.SCANDUMP и BF.LOADCHUNK.
+type Client struct {
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+func Dial(addr, password string, timeout time.Duration) (*Client, error) {
+	conn, err := net.DialTimeout("tcp", addr, timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	client := &Client{conn: conn, reader: bufio.NewReader(conn)}
+	if password != "" {
+		if _, err = client.Do("AUTH", password); err != nil {
+			_ = conn.Close()
+			return nil, err
+		}
+	}
+
+	return client, nil
+}
+
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+// Do отправляет команду и возвращает ответ: string, int64, []byte, []any или nil.
+func (c *Client) Do(args ...any) (any, error) {
+	if err := writeCommand(c.conn, args...); err != nil {
+		return nil, err
+	}
+	return readReply(c.reader)
+}
+
+// ScanDump выгружает фильтр key через BF.SCANDUMP.
+func (c *Client) ScanDump(key string) ([]Chunk, error) {
+	var chunks []Chunk
+	iter := int64(0)
+	for {
+		reply, err := c.Do(cmdScanDump, key, iter)
+		if err != nil {
+			return nil, err
+		}
+
+		parts, ok := reply.([]any)
+		if !ok || len(parts) != 2 {
+			return nil, fmt.Errorf("unexpected %s reply: %v", cmdScanDump, reply)
+		}
+		next, ok := parts[0].(int64)
+		if !ok {
+			return nil, fmt.Errorf("unexpected %s iterator: %v", cmdScanDump, parts[0])
+		}
+		if next == 0 {
+			return chunks, nil
+		}
+		data, _ := parts[1].([]byte)
+		chunks = append(chunks, Chunk{Iter: next, Data: data})
+		iter = next
+	}
+}
+
+// LoadChunks загружает фильтр key через BF.LOADCHUNK. Ключ не должен существовать.
+func (c *Client) LoadChunks(key string, chunks []Chunk) error {
+	for _, chunk := range chunks {
+		if _, err := c.Do(cmdLoadChunk, key, chunk.Iter, chunk.Data); err != nil {
+			return fmt.Errorf("%s iterator %d: %w", cmdLoadChunk, chunk.Iter, err)
+		}
+	}
+	return nil
+}
+
+// WriteCommands записывает команды BF.LOADCHUNK в формате RESP, пригодном для `redis-cli --pipe`.
+func WriteCommands(w io.Writer, key string, chunks []Chunk) error {
+	buf := bufio.NewWriter(w)
+	for _, chunk := range chunks {
+		if err := writeCommand(buf, cmdLoadChunk, key, chunk.Iter, chunk.Data); err != nil {
+			return err
+		}
+	}
+	return buf.Flush()
+}
+
+// ReadCommands читает команды BF.LOADCHUNK, записанные WriteCommands, и возвращает ключ и чанки.
+func ReadCommands(r io.Reader) (string, []Chunk, error) {
+	reader := bufio.NewReader(r)
+	var (
+		key    string
+		chunks []Chunk
+	)
+	for {
+		reply, err := readReply(reader)
+		if errors.Is(err, io.EOF) {
+			return key, chunks, nil
+		}
+		if err != nil {
+			return "", nil, err
+		}
+
+		args, ok := reply.([]any)
+		if !ok || len(args) != 4 {
+			return "", nil, fmt.Errorf("unexpected command: %v", reply)
+		}
+		name, _ := args[0].([]byte)
+		if string(name) != cmdLoadChunk {
+			return "", nil, fmt.Errorf("unexpected command: %s", name)
+		}
+		keyArg, _ := args[1].([]byte)
+		iterArg, _ := args[2].([]byte)
+		data, _ := args[3].([]byte)
+		iter, err := strconv.ParseInt(string(iterArg), 10, 64)
+		if err != nil {
+			return "", nil, fmt.Errorf("bad iterator %q: %w", iterArg, err)
+		}
+
+		key = string(keyArg)
+		chunks = append(chunks, Chunk{Iter: iter, Data: data})
+	}
+}
+
+func writeCommand(w io.Writer, args ...any) error {
+	if _, err := fmt.Fprintf(w, "*%d\r\n", len(args)); err != nil {
+		return err
+	}
+	for _, arg := range args {
+		var value []byte
+		switch v := arg.(type) {
+		case string:
+			value = []byte(v)
+		case []byte:
+			value = v
+		case int64:
+			value = strconv.AppendInt(nil, v, 10)
+		default:
+			return fmt.Errorf("unsupported argument type %T", arg)
+		}
+
+		if _, err := fmt.Fprintf(w, "$%d\r\n", len(value)); err != nil {
+			return err
+		}
+		if _, err := w.Write(value); err != nil {
+			return err
+		}
+		if _, err := io.WriteString(w, "\r\n"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func readReply(r *bufio.Reader) (any, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return nil, fmt.Errorf("malformed reply: %q", line)
+	}
+	kind, payload := line[0], line[1:len(line)-2]
+
+	switch kind {
+	case '+':
+		return payload, nil
+	case '-':
+		return nil, errors.New(payload)
+	case ':':
+		return strconv.ParseInt(payload, 10, 64)
+	case '$':
+		size, errs := strconv.Atoi(payload)
+		if errs != nil {
+			return nil, errs
+		}
+		if size < 0 {
+			return nil, nil
+		}
+		data := make([]byte, size+2)
+		if _, err = io.ReadFull(r, data); err != nil {
+			return nil, err
+		}
+		return data[:size], nil
+	case '*':
+		size, errs := strconv.Atoi(payload)
+		if errs != nil {
+			return nil, errs
+		}
+		if size < 0 {
+			return nil, nil
+		}
+		items := make([]any, size)
+		for i := range items {
+			if items[i], err = readReply(r); err != nil {
+				return nil, err
+			}
+		}
+		return items, nil
+	default:
+		return nil, fmt.Errorf("unknown reply type: %q", kind)
+	}
+}
@@ -1,14 +1,37 @@
 package utils
 
 import (
+	"bufio"
+	"io"
 	"os"
+	"strings"
 
 	"github.com/dustin/go-humanize"
+	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 )
 
 const integerFormat = "#,###."
 
+var logLevelMatches = map[string]zerolog.Level{
+	"NONE":  zerolog.NoLevel,
+	"TRACE": zerolog.TraceLevel,
+	"DEBUG": zerolog.DebugLevel,
+	"INFO":  zerolog.InfoLevel,
+	"WARN":  zerolog.WarnLevel,
+	"ERROR": zerolog.ErrorLevel,
+	"FATAL": zerolog.FatalLevel,
+}
+
+// ParseLogLevel возвращает уровень логирования по имени, info если имя неизвестно.
+func ParseLogLevel(level string) zerolog.Level {
+	logLevel, ok := logLevelMatches[strings.ToUpper(level)]
+	if !ok {
+		return zerolog.InfoLevel
+	}
+	return logLevel
+}
+
 func HumInt(num int) string {
 	return humanize.FormatInteger(integerFormat, num)
 }
@@ -40,3 +63,30 @@ func AssertWritePermission(filePath string) {
 	}
 	_ = file.Close()
 }
+
+// WriteDump записывает дамп во временный файл и заменяет им старый,
+// чтобы прерванный чекпоинт не испортил последний удачный дамп.
+func WriteDump(dumpFilepath string, filter io.WriterTo) error {
+	tmpFilepath := dumpFilepath + ".tmp"
+	file, err := os.OpenFile(tmpFilepath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+
+	writer := bufio.NewWriter(file)
+	if _, err = filter.WriteTo(writer); err == nil {
+		err = writer.Flush()
+	}
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(tmpFilepath)
+		return err
+	}
+
+	return os.Rename(tmpFilepath, dumpFilepath)
+}
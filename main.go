@@ -2,13 +2,11 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"net/http"
 	"os"
 	"os/signal"
 	"runtime"
-	"strings"
-	"sync"
 	"syscall"
 	"time"
 
@@ -16,19 +14,19 @@ import (
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 	"github.com/spf13/viper"
 
 	"bloom-du/internal/api"
+	"bloom-du/internal/bench"
+	"bloom-du/internal/bloom"
 	"bloom-du/internal/build"
+	"bloom-du/internal/redisbloom"
 	"bloom-du/internal/utils"
 )
 
 func main() {
-	var (
-		force              bool
-		checkpointInterval time.Duration
-		socketPath         string
-	)
+	var force bool
 
 	var rootCmd = &cobra.Command{
 		Use:   "bloom-du",
@@ -36,7 +34,7 @@ func main() {
 		Long:  `bloom-du - Bloom Filter implementation`,
 		Run: func(cmd *cobra.Command, args []string) {
 
-			viper.SetDefault("source", "")
+			viper.SetDefault("source", []string{})
 			viper.SetDefault("port", 8515)
 			viper.SetDefault("address", "0.0.0.0")
 			viper.SetDefault("log_level", "info")
@@ -45,22 +43,61 @@ func main() {
 			viper.SetDefault("checkpoint_interval", 600*time.Second)
 			viper.SetDefault("checkpoint_path", "/var/lib/bloom-du/sbfData.bloom")
 			viper.SetDefault("socket_path", "/tmp/bloom-du.sock")
+			viper.SetDefault("engine", bloom.StableBloom.String())
+			viper.SetDefault("admin_token", "")
+			viper.SetDefault("upgrade_timeout", 5*time.Minute)
+			viper.SetDefault("metrics_interval", time.Minute)
+			viper.SetDefault("saturation_webhook", "")
+			viper.SetDefault("min_free_disk_bytes", 0)
+			viper.SetDefault("shutdown_timeout", 10*time.Second)
+			viper.SetDefault("checkpoint_timeout", 5*time.Minute)
 
 			bindPFlags := []string{
 				"source", "port", "address", "log_level", "log_file", "force",
-				"checkpoint_interval", "socket_path", "checkpoint_path",
+				"checkpoint_interval", "socket_path", "checkpoint_path", "engine",
+				"shutdown_timeout", "checkpoint_timeout", "window", "generations", "capacity", "fp_rate", "cell_bits",
+				"cardinality", "topk", "source_format", "source_delimiter", "source_header",
+				"source_key", "source_key_separator", "source_parallel", "source_skip_ingested",
+				"watch", "watch_pattern", "watch_processed_dir", "watch_settle", "tail", "normalize",
+				"value_min_len", "value_max_len", "value_pattern", "value_charset", "max_bulk",
+				"hmac_key_file", "hmac_key_env",
 			}
 			for _, flag := range bindPFlags {
 				_ = viper.BindPFlag(flag, cmd.Flags().Lookup(flag))
 			}
+			_ = viper.BindEnv("admin_token", "BLOOM_DU_ADMIN_TOKEN")
+
+			configFile, _ := cmd.Flags().GetString("config")
+			if err := readConfig(configFile); err != nil {
+				log.Fatal().Err(err).Msg("error reading config")
+			}
 
 			file := setupLogging()
 			if file != nil {
 				defer func() { _ = file.Close() }()
 			}
+			api.ApplySettings()
+			// после запуска handleSignals конфигурацию может перечитать Reload
+			metricsInterval, checkpointInterval := viper.GetDuration("metrics_interval"), viper.GetDuration("checkpoint_interval")
+			startLog := log.Info().
+				Str("version", build.Version).
+				Str("runtime", runtime.Version()).
+				Int("pid", os.Getpid()).
+				Int("gomaxprocs", runtime.GOMAXPROCS(0)).
+				Str("log_level", viper.GetString("log_level")).
+				Str("config", viper.ConfigFileUsed()).
+				Str("checkpoint_interval", checkpointInterval.String()).
+				Str("checkpoint_path", viper.GetString("checkpoint_path")).
+				Str("engine", viper.GetString("engine"))
 
 			assertPermissions()
 
+			// при обновлении сокеты уже принимают соединения в старом процессе,
+			// поэтому новый начинает их обслуживать только после загрузки фильтров
+			if api.IsUpgrade() {
+				api.Start()
+			}
+
 			httpServer, err := api.RunHTTPServers()
 			if err != nil {
 				log.Fatal().Msgf("error running HTTP server: %v", err)
@@ -69,6 +106,7 @@ func main() {
 				log.Info().Msgf("listen and serve on: %s", httpServer.Addr)
 			}
 
+			socketPath := viper.GetString("socket_path")
 			_, err = api.RunUnixSocket(socketPath)
 			if err != nil {
 				log.Fatal().Msgf("error running Socket: %v", err)
@@ -76,41 +114,61 @@ func main() {
 				log.Info().Msgf("listen on socket: %s", socketPath)
 			}
 
-			go handleSignals(httpServer)
-
-			api.Start()
+			go handleSignals()
 
-			log.Info().
-				Str("version", build.Version).
-				Str("runtime", runtime.Version()).
-				Int("pid", os.Getpid()).
-				Int("gomaxprocs", runtime.GOMAXPROCS(0)).
-				Str("log_level", viper.GetString("log_level")).
-				Str("checkpoint_interval", checkpointInterval.String()).
-				Str("checkpoint_path", viper.GetString("checkpoint_path")).
-				Msg("starting")
+			if api.IsUpgrade() {
+				api.NotifyUpgraded()
+			} else {
+				api.Start()
+			}
+			go api.RunMetrics(metricsInterval)
 
-			ticker := time.NewTicker(checkpointInterval)
-			defer ticker.Stop()
+			startLog.Msg("starting")
 
-			for {
-				select {
-				case <-ticker.C:
-					api.Checkpoint()
-				}
-			}
+			api.RunCheckpoints(checkpointInterval)
 		},
 	}
 
-	rootCmd.Flags().StringP("source", "s", "", "path to source data file")
+	rootCmd.Flags().StringSliceP("source", "s", nil, "source data files, directories or glob patterns, - for stdin (gzip, zstd, bzip2, xz detected by content)")
+	rootCmd.Flags().String("source_format", bloom.SourceLines, "source records: lines, csv or ndjson")
+	rootCmd.Flags().String("source_delimiter", ",", "csv source: column delimiter, \\t for tab")
+	rootCmd.Flags().Bool("source_header", false, "csv source: first row is a header")
+	rootCmd.Flags().StringSlice("source_key", nil, "csv columns (from 1 or header names) or json field paths (user.email), several - composite key")
+	rootCmd.Flags().String("source_key_separator", "|", "separator of composite key parts")
+	rootCmd.Flags().Int("source_parallel", 1, "number of source files read at the same time")
+	rootCmd.Flags().Bool("source_skip_ingested", false, "remember ingested source files and read only new or changed ones after restart")
+	rootCmd.Flags().String("watch", "", "directory whose new and appended files are added to the running filter")
+	rootCmd.Flags().String("watch_pattern", "", "watch: file name pattern, e.g. export-*.csv.gz")
+	rootCmd.Flags().String("watch_processed_dir", "", "watch: move ingested files here after checkpoint instead of remembering offsets")
+	rootCmd.Flags().StringSlice("normalize", nil, "value normalization steps in order: trim, lowercase, nfc, digits, s/pattern/replacement/")
+	rootCmd.Flags().Int("value_min_len", 0, "minimum value length in characters, checked after normalization (0 - no limit)")
+	rootCmd.Flags().Int("value_max_len", 0, "maximum value length in characters, 0 - unlimited")
+	rootCmd.Flags().String("value_pattern", "", "regular expression the whole value must match")
+	rootCmd.Flags().String("value_charset", "", "allowed value characters: digits, hex, alnum, ascii or printable")
+	rootCmd.Flags().Int("max_bulk", 0, "maximum number of values in one /api/bulk request, 0 - unlimited")
+	rootCmd.Flags().String("hmac_key_file", "", "file with secret key: values are stored as HMAC-SHA256, dump is bound to the key")
+	rootCmd.Flags().String("hmac_key_env", "", "environment variable with secret key, instead of hmac_key_file")
+	rootCmd.Flags().String("tail", "", "growing file whose new lines are added to the filter, follows rotation and truncation like tail -F")
+	rootCmd.Flags().Duration("watch_settle", 5*time.Second, "watch: file is read when it has not changed for this long")
 	rootCmd.PersistentFlags().BoolVarP(&force, "force", "f", false, "force load from source file, ignoring a dump")
 	rootCmd.Flags().StringP("address", "a", "0.0.0.0", "address to serve")
 	rootCmd.Flags().Int("port", 8515, "port to serve on")
-	rootCmd.PersistentFlags().StringVarP(&socketPath, "socket_path", "u", "/tmp/bloom-du.sock", "Unix socket path")
+	rootCmd.PersistentFlags().StringP("socket_path", "u", "/tmp/bloom-du.sock", "Unix socket path")
 	rootCmd.Flags().StringP("log_level", "", "info", "log level: trace, debug, info, error, fatal or none")
 	rootCmd.Flags().StringP("log_file", "l", "", "log file path")
-	rootCmd.PersistentFlags().DurationVarP(&checkpointInterval, "checkpoint_interval", "i", 600*time.Second, "checkpoint")
+	rootCmd.PersistentFlags().DurationP("checkpoint_interval", "i", 600*time.Second, "checkpoint")
 	rootCmd.Flags().StringP("checkpoint_path", "o", "/var/lib/bloom-du/sbfData.bloom", "checkpoint path")
+	rootCmd.Flags().Duration("shutdown_timeout", 10*time.Second, "time to finish in-flight requests on shutdown")
+	rootCmd.Flags().Duration("checkpoint_timeout", 5*time.Minute, "time to finish the final checkpoint on shutdown")
+	rootCmd.Flags().String("config", "", "config file path (default: config.yml in /etc/bloom-du or the working directory)")
+	rootCmd.Flags().StringP("engine", "e", bloom.StableBloom.String(), "filter engine: stable, classic, redis, rotating or countmin")
+	rootCmd.Flags().Duration("window", 24*time.Hour, "rotating engine: how long values are remembered")
+	rootCmd.Flags().Int("generations", 24, "rotating engine: number of generations in the window")
+	rootCmd.Flags().Uint("capacity", 0, "expected number of elements (rotating: per generation), 0 - engine default, see calc")
+	rootCmd.Flags().Float64("fp_rate", 0, "desired false positive rate, 0 - engine default, see calc")
+	rootCmd.Flags().Uint8("cell_bits", 0, "stable engine: bits per cell, 0 - engine default (3)")
+	rootCmd.Flags().Bool("cardinality", false, "count distinct values with HyperLogLog (any engine)")
+	rootCmd.Flags().Uint("topk", 0, "track the N most frequently re-submitted values, 0 - disabled (any engine)")
 
 	var versionCmd = &cobra.Command{
 		Use:   "version",
@@ -141,51 +199,313 @@ func main() {
 		},
 	}
 
+	var calcCmd = &cobra.Command{
+		Use:          "calc",
+		Short:        "Calculate optimal filter parameters",
+		Long:         `Calculate optimal M, K, memory footprint and dump size for the given capacity and fpRate`,
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			engineName, _ := cmd.Flags().GetString("engine")
+			capacity, _ := cmd.Flags().GetUint("capacity")
+			fpRate, _ := cmd.Flags().GetFloat64("fp_rate")
+			cellBits, _ := cmd.Flags().GetUint8("cell_bits")
+			// --fp и --bits - старые имена тех же флагов
+			if cmd.Flags().Changed("fp") && !cmd.Flags().Changed("fp_rate") {
+				fpRate, _ = cmd.Flags().GetFloat64("fp")
+			}
+			if cmd.Flags().Changed("bits") && !cmd.Flags().Changed("cell_bits") {
+				cellBits, _ = cmd.Flags().GetUint8("bits")
+			}
+
+			engine, err := bloom.ParseEngine(engineName)
+			if err != nil {
+				return err
+			}
+			params, err := bloom.Calculate(engine, capacity, fpRate, cellBits)
+			if err != nil {
+				return err
+			}
+			printParams(params)
+			return nil
+		},
+	}
+	calcCmd.Flags().Uint("capacity", 0, "expected number of elements")
+	calcCmd.Flags().Float64("fp_rate", 0.001, "desired false positive rate")
+	calcCmd.Flags().String("engine", bloom.StableBloom.String(), "filter engine: stable, classic, redis, rotating or cuckoo")
+	calcCmd.Flags().Uint8("cell_bits", 3, "bits per cell (stable only)")
+	calcCmd.Flags().Float64("fp", 0.001, "desired false positive rate")
+	calcCmd.Flags().Uint8("bits", 3, "bits per cell (stable only)")
+	_ = calcCmd.Flags().MarkDeprecated("fp", "use --fp_rate")
+	_ = calcCmd.Flags().MarkDeprecated("bits", "use --cell_bits")
+	_ = calcCmd.MarkFlagRequired("capacity")
+
+	var benchCmd = &cobra.Command{
+		Use:          "bench",
+		Short:        "Load test a running bloom-du server",
+		Long:         `Load test a running bloom-du server over HTTP or Unix socket and report RPS and latency percentiles`,
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			flags := cmd.Flags()
+			cfg := bench.Config{}
+			cfg.Protocol, _ = flags.GetString("protocol")
+			cfg.URL, _ = flags.GetString("url")
+			cfg.Socket, _ = flags.GetString("socket_path")
+			cfg.Timeout, _ = flags.GetDuration("timeout")
+			cfg.Concurrency, _ = flags.GetInt("concurrency")
+			cfg.Requests, _ = flags.GetInt("requests")
+			cfg.Duration, _ = flags.GetDuration("duration")
+			cfg.AddRatio, _ = flags.GetFloat64("add_ratio")
+			cfg.Distribution, _ = flags.GetString("distribution")
+			cfg.Keys, _ = flags.GetUint64("keys")
+			cfg.Prefix, _ = flags.GetString("prefix")
+			cfg.Seed, _ = flags.GetUint64("seed")
+			if cfg.Seed == 0 {
+				// отчёт печатает seed: запуск можно повторить с --seed
+				cfg.Seed = uint64(time.Now().UnixNano())
+			}
+
+			report, err := bench.Run(cfg)
+			if err != nil {
+				return err
+			}
+			report.Print(os.Stdout)
+			return nil
+		},
+	}
+	benchCmd.Flags().String("protocol", bench.ProtocolHTTP, "protocol: http or unix")
+	benchCmd.Flags().String("url", "http://localhost:8515", "server base URL (http)")
+	benchCmd.Flags().Duration("timeout", 5*time.Second, "request timeout")
+	benchCmd.Flags().IntP("concurrency", "c", 1, "number of parallel connections")
+	benchCmd.Flags().IntP("requests", "n", 100_000, "total number of requests")
+	benchCmd.Flags().DurationP("duration", "d", 0, "run for the duration instead of a fixed number of requests")
+	benchCmd.Flags().Float64("add_ratio", 0, "share of add requests, from 0 (checks only) to 1 (adds only)")
+	benchCmd.Flags().String("distribution", bench.DistUniform, "key distribution: uniform, zipf, sequential or unique")
+	benchCmd.Flags().Uint64("keys", 1_000_000, "key space size")
+	benchCmd.Flags().String("prefix", "bench_", "key prefix")
+	benchCmd.Flags().Uint64("seed", 0, "random seed, 0 - random (printed in the report)")
+
+	var exportCmd = &cobra.Command{
+		Use:          "export",
+		Short:        "Export a redis engine dump to RedisBloom",
+		Long:         `Export a bloom-du dump of the redis engine as BF.LOADCHUNK commands to a file (for redis-cli --pipe) or directly to Redis`,
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			flags := cmd.Flags()
+			input, _ := flags.GetString("input")
+			output, _ := flags.GetString("output")
+			key, _ := flags.GetString("key")
+			chunkSize, _ := flags.GetInt("chunk_size")
+
+			filter, err := redisbloom.LoadDump(input)
+			if err != nil {
+				return err
+			}
+			chunks := filter.Chunks(chunkSize)
+
+			if output != "" {
+				file, errs := os.Create(output)
+				if errs != nil {
+					return errs
+				}
+				defer file.Close()
+				if err = redisbloom.WriteCommands(file, key, chunks); err != nil {
+					return err
+				}
+				fmt.Printf("%d chunks written to %s, load with: redis-cli --pipe < %s\n", len(chunks), output, output)
+				return nil
+			}
+
+			client, err := dialRedis(flags)
+			if err != nil {
+				return err
+			}
+			defer client.Close()
+			if err = client.LoadChunks(key, chunks); err != nil {
+				return err
+			}
+			fmt.Printf("%d chunks loaded into `%s`\n", len(chunks), key)
+			return nil
+		},
+	}
+	exportCmd.Flags().String("input", "/var/lib/bloom-du/sbfData.bloom", "bloom-du dump path")
+	exportCmd.Flags().String("output", "", "write BF.LOADCHUNK commands to the file instead of Redis")
+	exportCmd.Flags().Int("chunk_size", redisbloom.MaxChunkSize, "max chunk size in bytes")
+
+	var importCmd = &cobra.Command{
+		Use:          "import",
+		Short:        "Import a RedisBloom filter as a redis engine dump",
+		Long:         `Import a RedisBloom filter via BF.SCANDUMP (or from a file written by export) into a bloom-du dump of the redis engine`,
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			flags := cmd.Flags()
+			input, _ := flags.GetString("input")
+			output, _ := flags.GetString("output")
+			key, _ := flags.GetString("key")
+
+			if _, err := os.Stat(output); err == nil && !force {
+				return fmt.Errorf("%s already exists, use --force to overwrite", output)
+			}
+
+			var chunks []redisbloom.Chunk
+			if input != "" {
+				file, err := os.Open(input)
+				if err != nil {
+					return err
+				}
+				defer file.Close()
+				if _, chunks, err = redisbloom.ReadCommands(file); err != nil {
+					return err
+				}
+			} else {
+				client, err := dialRedis(flags)
+				if err != nil {
+					return err
+				}
+				defer client.Close()
+				if chunks, err = client.ScanDump(key); err != nil {
+					return err
+				}
+			}
+
+			filter, err := redisbloom.FromChunks(chunks)
+			if err != nil {
+				return err
+			}
+			if err = redisbloom.SaveDump(output, filter); err != nil {
+				return err
+			}
+			fmt.Printf("Imported `%s`: capacity %s, count %s, error rate %g -> %s\n",
+				key, utils.HumInt(int(filter.Capacity())), utils.HumInt(int(filter.Count())), filter.ErrorRate(), output)
+			fmt.Println("Start bloom-du with --engine=redis to use it")
+			return nil
+		},
+	}
+	importCmd.Flags().String("input", "", "read BF.LOADCHUNK commands from the file instead of Redis")
+	importCmd.Flags().String("output", "/var/lib/bloom-du/sbfData.bloom", "bloom-du dump path")
+
+	for _, c := range []*cobra.Command{exportCmd, importCmd} {
+		c.Flags().String("redis", "localhost:6379", "Redis address")
+		c.Flags().String("redis_password", "", "Redis password")
+		c.Flags().String("key", "", "RedisBloom key")
+		_ = c.MarkFlagRequired("key")
+	}
+
 	rootCmd.AddCommand(serveCmd)
 	rootCmd.AddCommand(versionCmd)
 	rootCmd.AddCommand(filterCmd)
+	rootCmd.AddCommand(calcCmd)
+	rootCmd.AddCommand(benchCmd)
+	rootCmd.AddCommand(exportCmd)
+	rootCmd.AddCommand(importCmd)
 	_ = rootCmd.Execute()
 }
 
-func handleSignals(httpServer *http.Server) {
+func dialRedis(flags *pflag.FlagSet) (*redisbloom.Client, error) {
+	addr, _ := flags.GetString("redis")
+	password, _ := flags.GetString("redis_password")
+	return redisbloom.Dial(addr, password, 5*time.Second)
+}
+
+func printParams(p bloom.Params) {
+	row := func(label string, value any) {
+		fmt.Printf("%-16s %v\n", label+":", value)
+	}
+
+	row("Engine", p.Engine)
+	row("Capacity", utils.HumInt(int(p.Capacity)))
+	row("FP rate", p.FpRate)
+
+	switch p.Engine {
+	case bloom.StableBloom:
+		row("M (cells)", utils.HumInt(int(p.M)))
+		row("D (cell bits)", p.D)
+		row("K", p.K)
+		row("P", p.P)
+		row("Stable point", fmt.Sprintf("%f", p.StablePoint))
+		row("Stable FP rate", fmt.Sprintf("%f", p.StableFpRate))
+	case bloom.CuckooBloom:
+		row("M (buckets)", utils.HumInt(int(p.M)))
+		row("Fingerprint", fmt.Sprintf("%d bytes", p.F))
+	default:
+		row("M (bits)", utils.HumInt(int(p.M)))
+		row("K", p.K)
+	}
+
+	row("Memory", utils.HumByte(&p.MemoryBytes))
+	if p.DumpBytes == 0 {
+		row("Dump size", "n/a (engine has no checkpoint)")
+	} else {
+		row("Dump size", utils.HumByte(&p.DumpBytes))
+	}
+
+	// те же параметры в конфигурации фильтра дают именно этот фильтр
+	switch p.Engine {
+	case bloom.StableBloom:
+		row("Flags", fmt.Sprintf("--engine=%s --capacity=%d --fp_rate=%g --cell_bits=%d", p.Engine, p.Capacity, p.FpRate, p.D))
+	case bloom.ClassicBloom, bloom.RedisBloom, bloom.RotatingBloom:
+		row("Flags", fmt.Sprintf("--engine=%s --capacity=%d --fp_rate=%g", p.Engine, p.Capacity, p.FpRate))
+	}
+}
+
+func handleSignals() {
 	sigCh := make(chan os.Signal, 1)
 	signal.Notify(sigCh,
 		os.Interrupt,
 		syscall.SIGHUP,
 		syscall.SIGINT,
 		syscall.SIGTERM,
+		syscall.SIGQUIT,
 		syscall.SIGUSR1,
 		syscall.SIGUSR2,
 	)
 
+	shuttingDown := false
 	for {
 		sig := <-sigCh
 		log.Info().Msgf("signal received: %v", sig)
 		switch sig {
 		case syscall.SIGHUP:
-			log.Info().Msg("TODO reloading configuration")
-			// tryReadConfig()
+			api.Reload()
 		case syscall.SIGINT, syscall.SIGTERM, os.Interrupt:
+			if shuttingDown {
+				log.Warn().Msg("Forced shutdown")
+				os.Exit(1)
+			}
+			shuttingDown = true
 			log.Info().Msg("Shutting down ...")
 
-			shutdownTimeout := 3 * time.Second
-			go time.AfterFunc(shutdownTimeout, func() { os.Exit(1) })
-
-			var wg sync.WaitGroup
+			go func() {
+				shutdownTimeout, checkpointTimeout, _ := api.Timeouts()
+				err := api.Shutdown(shutdownTimeout, checkpointTimeout)
+				if err != nil {
+					log.Error().Err(err).Msg("shutdown")
+					os.Exit(1)
+				}
+				log.Info().Msg("Stopped")
+				os.Exit(0)
+			}()
+		case syscall.SIGQUIT:
+			log.Info().Msg("Upgrading ...")
+			shutdownTimeout, _, upgradeTimeout := api.Timeouts()
+			if err := api.Upgrade(upgradeTimeout); err != nil {
+				log.Error().Err(err).Msg("upgrade failed, continue serving")
+				continue
+			}
 
 			ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
-			wg.Add(1)
-			go func(srv *http.Server) {
-				defer wg.Done()
-				_ = srv.Shutdown(ctx)
-			}(httpServer)
-
-			wg.Wait()
+			if err := api.ShutdownServers(ctx); err != nil {
+				log.Error().Err(err).Msg("not all requests are finished")
+			}
 			cancel()
-			api.Checkpoint()
-			cleanup()
+			log.Info().Msg("Upgrade done, exiting")
+			os.Exit(0)
+		case syscall.SIGUSR1:
+			go func() {
+				log.Info().Msg("Checkpoint all filters ...")
+				_ = api.Checkpoint()
+			}()
 		case syscall.SIGUSR2:
-			log.Info().Msg("Test SIGUSR2")
+			go api.LogStatus()
 		default:
 		}
 	}
@@ -210,20 +530,7 @@ func isTerminalAttached() bool {
 
 func setupLogging() *os.File {
 	configureConsoleWriter()
-	var logLevelMatches = map[string]zerolog.Level{
-		"NONE":  zerolog.NoLevel,
-		"TRACE": zerolog.TraceLevel,
-		"DEBUG": zerolog.DebugLevel,
-		"INFO":  zerolog.InfoLevel,
-		"WARN":  zerolog.WarnLevel,
-		"ERROR": zerolog.ErrorLevel,
-		"FATAL": zerolog.FatalLevel,
-	}
-	logLevel, ok := logLevelMatches[strings.ToUpper(viper.GetString("log_level"))]
-	if !ok {
-		logLevel = zerolog.InfoLevel
-	}
-	zerolog.SetGlobalLevel(logLevel)
+	zerolog.SetGlobalLevel(utils.ParseLogLevel(viper.GetString("log_level")))
 	if viper.IsSet("log_file") && viper.GetString("log_file") != "" {
 		f, err := os.OpenFile(viper.GetString("log_file"), os.O_RDWR|os.O_CREATE|os.O_APPEND, 0644)
 		if err != nil {
@@ -236,17 +543,43 @@ func setupLogging() *os.File {
 	return nil
 }
 
-// TODO add map and for range check
-func assertPermissions() {
-	checkpointPath := viper.GetString("checkpoint_path")
-	source := viper.GetString("source")
+// readConfig читает файл конфигурации. Без явного пути ищет config.yml
+// в /etc/bloom-du и рабочей директории, отсутствие файла не ошибка.
+func readConfig(path string) error {
+	if path != "" {
+		viper.SetConfigFile(path)
+		return viper.ReadInConfig()
+	}
 
-	utils.AssertReadPermission(source)
-	utils.AssertWritePermission(checkpointPath)
+	viper.SetConfigName("config")
+	viper.SetConfigType("yml")
+	viper.AddConfigPath("/etc/bloom-du")
+	viper.AddConfigPath(".")
+	if err := viper.ReadInConfig(); err != nil {
+		var notFound viper.ConfigFileNotFoundError
+		if !errors.As(err, &notFound) {
+			return err
+		}
+	}
+	return nil
 }
 
-func cleanup() {
-	socketPath := viper.GetString("socket_path")
-	_ = os.Remove(socketPath)
-	// os.Exit(1)
+func assertPermissions() {
+	configs, err := api.FilterConfigs()
+	if err != nil {
+		log.Fatal().Err(err).Send()
+	}
+
+	for _, cfg := range configs {
+		sources, err := bloom.ExpandSources(cfg.Source)
+		if err != nil {
+			log.Fatal().Err(err).Send()
+		}
+		for _, source := range sources {
+			if source != bloom.StdinSource {
+				utils.AssertReadPermission(source)
+			}
+		}
+		utils.AssertWritePermission(cfg.CheckpointPath)
+	}
 }